@@ -21,11 +21,31 @@ type CanStoreResultFunc func(v interface{}) bool
 
 type AddCacheParam struct {
 	cacheKey       string
-	timeout        time.Duration
-	cacheBust      bool // 确保获取最新的数据通过判断cache bust 破坏缓存
+	timeout        time.Duration // 默认为DefaultTTL，使用TTLRules中匹配key前缀的过期时间
+	ttlJitter      int           // 过期时间随机浮动的百分比，timeout为DefaultTTL时使用TTLRules的配置
+	cacheBust      bool          // 确保获取最新的数据通过判断cache bust 破坏缓存
 	encodeKeyType  EncodeKeyType
-	cacheStore     Storage
+	cacheStore     Storage // 为空时根据key的后缀或配置的默认storage判断
 	canStoreResult CanStoreResultFunc
+	callOptions    []CallOption // 透传给Client的参数，如codec、compression
+	cluster        string       // 为空时按key的前缀路由
+
+	// 合并同一个key的并发回源请求
+	singleFlight bool
+	// 大于0时开启分布式合并，使用redis锁保证所有实例中只有一个调用者回源
+	singleFlightLockTimeout time.Duration
+	// 分布式合并时，未获得锁的调用者等待缓存结果的最长时间，超时后自行回源
+	singleFlightWaitTimeout time.Duration
+
+	// 大于0时开启stale-while-revalidate，timeout为hard TTL，超过softTimeout后返回旧值并在后台刷新
+	softTimeout time.Duration
+	// 大于0时开启XFetch提前刷新，值越大越倾向于提前刷新，通常为1
+	earlyRefreshBeta float64
+
+	// 大于0时开启negative cache，real func返回nil或canStoreResult返回false时写入空值标记
+	negativeCacheTimeout time.Duration
+	// 命中空值标记时返回的错误，默认为constant.ErrorAddCacheGotNilResult
+	negativeCacheErr error
 }
 
 func NewAddCacheParam(cacheKey string, options ...SetParam) *AddCacheParam {
@@ -38,12 +58,12 @@ func NewAddCacheParam(cacheKey string, options ...SetParam) *AddCacheParam {
 	}
 
 	params := &AddCacheParam{
-		cacheKey:       cacheKey,
-		timeout:        defaultCacheTimeoutSecond,
-		cacheBust:      cacheBust,
-		encodeKeyType:  Utf8,
-		cacheStore:     Main,
-		canStoreResult: defaultCanStoreResult,
+		cacheKey:         cacheKey,
+		timeout:          DefaultTTL,
+		cacheBust:        cacheBust,
+		encodeKeyType:    Utf8,
+		canStoreResult:   defaultCanStoreResult,
+		negativeCacheErr: constant.ErrorAddCacheGotNilResult,
 	}
 
 	// set options
@@ -61,6 +81,13 @@ func WithTimeout(timeout time.Duration) SetParam {
 	}
 }
 
+// WithTTLJitter 写入时过期时间在timeout上随机浮动percent%，避免同时回源的key同时过期
+func WithTTLJitter(percent int) SetParam {
+	return func(param *AddCacheParam) {
+		param.ttlJitter = percent
+	}
+}
+
 func WithCacheBust(cacheBust bool) SetParam {
 	return func(param *AddCacheParam) {
 		param.cacheBust = cacheBust
@@ -73,18 +100,173 @@ func WithEncodeKeyType(encodeKeyType EncodeKeyType) SetParam {
 	}
 }
 
+// WithCacheStore 显式指定storage: Main, Local 或 Tiered，优先于key的后缀
 func WithCacheStore(cacheStore Storage) SetParam {
 	return func(param *AddCacheParam) {
 		param.cacheStore = cacheStore
 	}
 }
 
+// WithCluster 使用指定名称的redis连接，优先于按key前缀的路由，分布式合并的锁也使用该连接
+func WithCluster(name string) SetParam {
+	return func(param *AddCacheParam) {
+		param.cluster = name
+	}
+}
+
 func WithCanStoreResult(canStoreResult CanStoreResultFunc) SetParam {
 	return func(param *AddCacheParam) {
 		param.canStoreResult = canStoreResult
 	}
 }
 
+// WithCodec 写入Main storage时使用的编解码器，默认使用Client的配置
+func WithCodec(codec Codec) SetParam {
+	return func(param *AddCacheParam) {
+		param.callOptions = append(param.callOptions, CallWithCodec(codec))
+	}
+}
+
+// WithCompression 写入Main storage时，编码后的值达到threshold(字节)使用t压缩，默认使用Client的配置
+func WithCompression(t CompressType, threshold int) SetParam {
+	return func(param *AddCacheParam) {
+		param.callOptions = append(param.callOptions, CallWithCompression(t, threshold))
+	}
+}
+
+// WithEncryption 为true时写入Main storage的值加密，见CallWithEncryption
+func WithEncryption(encrypt bool) SetParam {
+	return func(param *AddCacheParam) {
+		param.callOptions = append(param.callOptions, CallWithEncryption(encrypt))
+	}
+}
+
+// WithSingleFlight 同一进程内对同一个key的并发回源请求只调用一次real func，其他调用者共享结果
+func WithSingleFlight(singleFlight bool) SetParam {
+	return func(param *AddCacheParam) {
+		param.singleFlight = singleFlight
+	}
+}
+
+// WithDistributedSingleFlight
+//
+//	@Description: 在WithSingleFlight的基础上，使用过期时间为lockTimeout的redis锁保证所有实例中只有一个调用者回源，
+//	其他调用者最多等待waitTimeout，期间轮询缓存结果，超时后自行回源。对Local storage不生效
+//	@param lockTimeout
+//	@param waitTimeout
+//	@return SetParam
+func WithDistributedSingleFlight(lockTimeout, waitTimeout time.Duration) SetParam {
+	return func(param *AddCacheParam) {
+		param.singleFlight = true
+		param.singleFlightLockTimeout = lockTimeout
+		param.singleFlightWaitTimeout = waitTimeout
+	}
+}
+
+// WithTags 写入缓存时为key添加tag，之后可以通过Client.InvalidateTags删除带有该tag的所有key
+func WithTags(tags ...string) SetParam {
+	return func(param *AddCacheParam) {
+		param.callOptions = append(param.callOptions, CallWithTags(tags...))
+	}
+}
+
+// WithStaleWhileRevalidate
+//
+//	@Description: 开启soft TTL/hard TTL模式，timeout(WithTimeout)为hard TTL，
+//	写入超过softTimeout后值变为stale，此时直接返回旧值，并在后台调用real func刷新缓存
+//	@param softTimeout 应小于timeout
+//	@return SetParam
+func WithStaleWhileRevalidate(softTimeout time.Duration) SetParam {
+	return func(param *AddCacheParam) {
+		param.softTimeout = softTimeout
+	}
+}
+
+// WithEarlyRefresh
+//
+//	@Description: 开启XFetch概率提前刷新，根据上一次回源耗时delta，
+//	在 now - delta * beta * ln(rand()) >= expireAt 时提前刷新，越接近过期刷新的概率越大，
+//	开启stale-while-revalidate时在后台刷新，否则由当前调用者同步刷新
+//	@param beta 通常为1，大于1更倾向于提前刷新
+//	@return SetParam
+func WithEarlyRefresh(beta float64) SetParam {
+	return func(param *AddCacheParam) {
+		param.earlyRefreshBeta = beta
+	}
+}
+
+// WithNegativeCache
+//
+//	@Description: 开启negative cache，real func返回nil或canStoreResult(WithCanStoreResult)返回false时，
+//	写入过期时间为timeout的空值标记，之后命中空值标记时直接返回negativeCacheErr(WithNegativeCacheError)，不再调用real func
+//	@param timeout 通常应小于timeout(WithTimeout)
+//	@return SetParam
+func WithNegativeCache(timeout time.Duration) SetParam {
+	return func(param *AddCacheParam) {
+		param.negativeCacheTimeout = timeout
+	}
+}
+
+// WithNegativeCacheError 命中空值标记时返回的错误，默认为constant.ErrorAddCacheGotNilResult
+func WithNegativeCacheError(err error) SetParam {
+	return func(param *AddCacheParam) {
+		param.negativeCacheErr = err
+	}
+}
+
+// needRefresh 根据值中存储的元数据判断是否需要刷新
+func (param *AddCacheParam) needRefresh(meta *valueMeta, now time.Time) bool {
+	if param.softTimeout > 0 && meta.isStale(now) {
+		return true
+	}
+	return param.earlyRefreshBeta > 0 && shouldRefreshEarly(meta, param.earlyRefreshBeta, now)
+}
+
+// newValueMeta 未开启stale-while-revalidate及提前刷新时返回nil，timeout为本次写入实际使用的过期时间
+func (param *AddCacheParam) newValueMeta(now time.Time, delta time.Duration, timeout time.Duration) *valueMeta {
+	if param.softTimeout <= 0 && param.earlyRefreshBeta <= 0 {
+		return nil
+	}
+	meta := &valueMeta{delta: delta.Milliseconds()}
+	if timeout > 0 {
+		meta.hardExpireAt = now.Add(timeout).UnixMilli()
+	}
+	if param.softTimeout > 0 {
+		meta.softExpireAt = now.Add(param.softTimeout).UnixMilli()
+	}
+	return meta
+}
+
+// getCallOptions 返回透传给Client的参数，每次返回新的slice，调用者可以继续append
+func (param *AddCacheParam) getCallOptions() []CallOption {
+	opts := make([]CallOption, 0, len(param.callOptions)+2)
+	opts = append(opts, param.callOptions...)
+	if param.cacheStore != "" {
+		opts = append(opts, CallWithStorage(param.cacheStore))
+	}
+	if param.cluster != "" {
+		opts = append(opts, CallWithCluster(param.cluster))
+	}
+	return opts
+}
+
+// getKeyCallOptions 在getCallOptions的基础上，使Client按编码前的keyRaw匹配TTLRules及EncryptionKeyPrefixes
+func (param *AddCacheParam) getKeyCallOptions(key string, keyRaw string) []CallOption {
+	opts := param.getCallOptions()
+	if key != keyRaw {
+		opts = append(opts, callWithRuleKeys(map[string]string{key: keyRaw}))
+	}
+	return opts
+}
+
+// getStorage 与Client使用相同的规则判断storage，见callOption.getStorage
+func (param *AddCacheParam) getStorage(key string) Storage {
+	if param.cacheStore != "" {
+		return param.cacheStore
+	}
+	return client.newCallOption().getStorage(key)
+}
+
 // AddCacheOperator
 // receiver must be a ptr and not nil, like new(string)
 // your func return value should set to receiver
@@ -120,65 +302,114 @@ func addCache(m AddCacheOperator, param *AddCacheParam) AddCacheOperator {
 		keyRaw := key
 		key = getCacheKey(param, key)
 		// 尝试从cache中获取值
-		cacheFound := tryFindCache(ctx, receiver, param, key, keyRaw)
-		c := GetCacheManager()
+		meta := new(valueMeta)
+		cacheFound, negativeHit := tryFindCache(ctx, receiver, param, key, keyRaw, meta)
+		// 未开启negative cache时忽略空值标记，重新回源
+		if negativeHit && param.negativeCacheTimeout > 0 {
+			return nil, param.negativeCacheErr
+		}
 		if cacheFound {
-			return receiver, nil
-		} else {
-			// get from real func
-			i, e = m(ctx, receiver)
-			if e != nil {
-				if e == constant.ErrorAddCacheGotNilResult {
-					log.Warnf("addCache call real function, key=%v,result is nil", keyRaw)
-				} else {
-					log.Warnf("addCache call func m(ctx, receiver), key=%v,err=%+v", keyRaw, e)
-				}
-				return i, e
+			if !param.needRefresh(meta, time.Now()) {
+				return receiver, nil
 			}
-
-			// i == nil
-			if util.IsNil(i) {
-				log.Warnf("addCache call real function, key=%v,result is nil", keyRaw)
-				return i, constant.ErrorAddCacheGotNilResult
+			// stale-while-revalidate模式下先返回旧值，在后台刷新
+			if param.softTimeout > 0 {
+				refreshInBackground(ctx, receiver, m, param, key, keyRaw)
+				return receiver, nil
 			}
-			e = util.DeepCopy(receiver, i)
+			log.Infof("addCache refresh early|key=%s, delta=%d[ms], expire_at=%d", keyRaw, meta.delta, meta.expireAt())
+		}
+		if param.singleFlight {
+			return loadWithSingleFlight(ctx, receiver, m, param, key, keyRaw)
+		}
+		return loadAndSetCache(ctx, receiver, m, param, key, keyRaw)
+	}
+}
 
-			if !param.canStoreResult(i) {
-				return i, e
-			}
-			setCache(ctx, i, c, key, param, log, keyRaw)
+// loadAndSetCache 调用real func获取值，并根据param写入cache
+func loadAndSetCache(ctx context.Context, receiver interface{}, m AddCacheOperator, param *AddCacheParam, key string, keyRaw string) (i interface{}, e error) {
+	log := logger.CtxSugar(ctx)
+	// get from real func
+	startTime := time.Now()
+	i, e = m(ctx, receiver)
+	delta := time.Since(startTime)
+	if e != nil {
+		if e == constant.ErrorAddCacheGotNilResult {
+			log.Warnf("addCache call real function, key=%v,result is nil", keyRaw)
+			setNegativeCache(ctx, key, param, log, keyRaw)
+		} else {
+			log.Warnf("addCache call func m(ctx, receiver), key=%v,err=%+v", keyRaw, e)
 		}
 		return i, e
 	}
+
+	// i == nil
+	if util.IsNil(i) {
+		log.Warnf("addCache call real function, key=%v,result is nil", keyRaw)
+		setNegativeCache(ctx, key, param, log, keyRaw)
+		return i, constant.ErrorAddCacheGotNilResult
+	}
+	e = util.DeepCopy(receiver, i)
+
+	if !param.canStoreResult(i) {
+		setNegativeCache(ctx, key, param, log, keyRaw)
+		return i, e
+	}
+	setCache(ctx, i, GetCacheManager(), key, param, log, keyRaw, delta)
+	return i, e
 }
 
-func tryFindCache(ctx context.Context, receiver interface{}, param *AddCacheParam, key string, keyRaw string) bool {
+// tryFindCache meta接收与值一起存储的元数据，negativeHit表示命中了空值标记
+func tryFindCache(ctx context.Context, receiver interface{}, param *AddCacheParam, key string, keyRaw string, meta *valueMeta) (cacheFound bool, negativeHit bool) {
 	log := logger.CtxSugar(ctx)
 	c := GetCacheManager()
-	cacheFound := false
 
 	if param.cacheBust {
-		err := c.Delete(ctx, key)
+		err := c.Delete(ctx, key, param.getKeyCallOptions(key, keyRaw)...)
 		if err != nil {
 			log.Warnf("addCache Delete failed|key=%+v, err=%+v", key, err)
 		}
 	} else {
 		readStartTime := time.Now()
-		err := c.Get(ctx, key, receiver)
+		err := c.Get(ctx, key, receiver, append(param.getKeyCallOptions(key, keyRaw), callWithMetaReceiver(meta))...)
 		readElapsedTime := time.Since(readStartTime).Milliseconds()
-		if err != nil {
+		if err == constant.ErrorNegativeCacheHit {
+			negativeHit = true
+		} else if err != nil {
 			log.Warnf("addCache get from cache failed|key=%+v, err=%+v", key, err)
 		} else {
 			cacheFound = true
 		}
 		logForReadOvertimeCost(readElapsedTime, receiver, log, keyRaw, key)
 	}
-	return cacheFound
+	return cacheFound, negativeHit
 }
 
-func setCache(ctx context.Context, value interface{}, c Client, key string, param *AddCacheParam, log *zap.SugaredLogger, keyRaw string) {
+// setNegativeCache 开启negative cache时写入空值标记
+func setNegativeCache(ctx context.Context, key string, param *AddCacheParam, log *zap.SugaredLogger, keyRaw string) {
+	if param.negativeCacheTimeout <= 0 {
+		return
+	}
+	err := GetCacheManager().Set(ctx, key, nil, param.negativeCacheTimeout, append(param.getKeyCallOptions(key, keyRaw), callWithTombstone())...)
+	if err != nil {
+		log.Warnf("addCache Set negative cache failed|key=%+v,keyRaw=%+v, err=%+v", key, keyRaw, err)
+	}
+}
+
+// setCache delta为本次回源耗时，开启stale-while-revalidate或提前刷新时与值一起存储
+func setCache(ctx context.Context, value interface{}, c Client, key string, param *AddCacheParam, log *zap.SugaredLogger, keyRaw string, delta time.Duration) {
 	writeStartTime := time.Now()
-	err := c.Set(ctx, key, value, param.timeout)
+	opts := param.getKeyCallOptions(key, keyRaw)
+	// 先计算过期时间，元数据中的hardExpireAt与实际的过期时间一致；
+	// key可能经过WithEncodeKeyType编码，TTLRules按编码前的keyRaw匹配
+	timeout := param.timeout
+	if m, ok := c.(*cacheManager); ok {
+		timeout = m.getTTL(keyRaw, param.timeout, &callOption{ttlJitter: param.ttlJitter})
+	}
+	if meta := param.newValueMeta(writeStartTime, delta, timeout); meta != nil {
+		opts = append(opts, callWithMeta(meta))
+	}
+	err := c.Set(ctx, key, value, timeout, opts...)
 	writeElapsedTime := time.Since(writeStartTime).Milliseconds()
 	if err != nil {
 		log.Warnf("addCache Set to cache failed|key=%+v,keyRaw=%+v, err=%+v", key, keyRaw, err)
@@ -187,14 +418,14 @@ func setCache(ctx context.Context, value interface{}, c Client, key string, para
 }
 
 func logForWriteOvertimeCost(costTime int64, receiver interface{}, log *zap.SugaredLogger, keyRaw, key string) {
-	if costTime > warningThreshold {
+	if costTime > getSlowOpThreshold().Milliseconds() {
 		logValue := getValueForLog(receiver)
 		log.Warnf("cache write is too slow|key_raw=%s,key=%s,value=%s,time=%d", keyRaw, key, logValue, costTime)
 	}
 }
 
 func logForReadOvertimeCost(costTime int64, receiver interface{}, log *zap.SugaredLogger, keyRaw string, key string) {
-	if costTime > warningThreshold {
+	if costTime > getSlowOpThreshold().Milliseconds() {
 		logValue := getValueForLog(receiver)
 		log.Warnf("cache_read_is_too_slow|key_raw=%s,key=%s,value=%s,time=%d", keyRaw, key, logValue, costTime)
 	}
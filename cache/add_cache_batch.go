@@ -0,0 +1,127 @@
+// Package cache @Author  wangjian    2023/8/28 5:40 PM
+package cache
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	logger "github.com/JianWangEx/commonService/log"
+	"github.com/JianWangEx/commonService/util"
+	"github.com/pkg/errors"
+)
+
+// AddCacheBatchOperator
+// ids are the missed ids, the returned map key should be id,
+// ids not in the returned map or with nil value are treated as not found and will not be cached
+type AddCacheBatchOperator func(ctx context.Context, ids []string) (map[string]interface{}, error)
+
+// AddCacheBatchHandle
+//
+//	@Description: 批量版本的AddCacheHandle，使用fmt.Sprintf(param.cacheKey, id)生成每个id的缓存key，
+//	通过MGet一次读取所有key，只对未命中的id调用f，并通过MSet一次写回
+//	@param ctx
+//	@param ids
+//	@param receiver 指向key为string的map的指针，如new(map[string]*User)，key为id
+//	@param f
+//	@param param cacheKey为包含一个%s或%v的模板，如"user_profile:%s"
+//	@return error
+func AddCacheBatchHandle(ctx context.Context, ids []string, receiver interface{}, f AddCacheBatchOperator, param *AddCacheParam) (e error) {
+	log := logger.CtxSugar(ctx)
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("addCacheBatch panic,param=%+v, err=%+v", param, err)
+			e = errors.WithStack(constant.CommonErrorServer.WithMsgF("add cache batch panic,err=%+v", err))
+		}
+	}()
+	mv, err := getMapReceiver(receiver)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	c := GetCacheManager()
+	elemType := mv.Type().Elem()
+
+	idToKey := make(map[string]string, len(ids))
+	keys := make([]string, 0, len(ids))
+	// 编码后的key到编码前key的映射，Client按编码前的key匹配TTLRules及EncryptionKeyPrefixes
+	ruleKeys := make(map[string]string, len(ids))
+	for _, id := range ids {
+		keyRaw := fmt.Sprintf(param.cacheKey, id)
+		key := getCacheKey(param, keyRaw)
+		idToKey[id] = key
+		keys = append(keys, key)
+		ruleKeys[key] = keyRaw
+	}
+	opts := append(param.getCallOptions(), callWithRuleKeys(ruleKeys))
+
+	// 读取缓存
+	missIDs := ids
+	if param.cacheBust {
+		if err := c.MDelete(ctx, keys, opts...); err != nil {
+			log.Warnf("addCacheBatch MDelete failed|keys=%+v, err=%+v", keys, err)
+		}
+	} else {
+		hits := reflect.New(mv.Type())
+		readStartTime := time.Now()
+		if err := c.MGet(ctx, keys, hits.Interface(), opts...); err != nil {
+			log.Warnf("addCacheBatch MGet failed|keys=%+v, err=%+v", keys, err)
+		}
+		logForReadOvertimeCost(time.Since(readStartTime).Milliseconds(), ids, log, param.cacheKey, param.cacheKey)
+
+		missIDs = make([]string, 0)
+		hitMap := hits.Elem()
+		for _, id := range ids {
+			v := hitMap.MapIndex(reflect.ValueOf(idToKey[id]).Convert(hitMap.Type().Key()))
+			if !v.IsValid() {
+				missIDs = append(missIDs, id)
+				continue
+			}
+			setMapValue(mv, id, v)
+		}
+	}
+	if len(missIDs) == 0 {
+		return nil
+	}
+
+	// 只对未命中的id回源
+	results, err := f(ctx, missIDs)
+	if err != nil {
+		log.Warnf("addCacheBatch call func f(ctx, ids), key=%v, ids=%+v, err=%+v", param.cacheKey, missIDs, err)
+		return err
+	}
+	toStore := make(map[string]interface{}, len(results))
+	for _, id := range missIDs {
+		v, ok := results[id]
+		if !ok || util.IsNil(v) {
+			continue
+		}
+		// DeepCopy不支持指向nil的指针，elemType为指针时直接创建其指向的值
+		target := reflect.New(elemType)
+		if elemType.Kind() == reflect.Ptr {
+			target.Elem().Set(reflect.New(elemType.Elem()))
+		}
+		if err := util.DeepCopy(target.Interface(), v); err != nil {
+			return errors.Wrapf(err, "id=%s", id)
+		}
+		setMapValue(mv, id, target.Elem())
+		if param.canStoreResult(v) {
+			toStore[idToKey[id]] = v
+		}
+	}
+	if len(toStore) == 0 {
+		return nil
+	}
+
+	// 一次写回所有未命中的值
+	writeStartTime := time.Now()
+	if err := c.MSet(ctx, toStore, param.timeout, append(opts, CallWithTTLJitter(param.ttlJitter))...); err != nil {
+		log.Warnf("addCacheBatch MSet failed|key=%s, err=%+v", param.cacheKey, err)
+	}
+	logForWriteOvertimeCost(time.Since(writeStartTime).Milliseconds(), missIDs, log, param.cacheKey, param.cacheKey)
+	return nil
+}
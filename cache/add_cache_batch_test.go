@@ -0,0 +1,50 @@
+// Package cache @Author  wangjian    2023/8/28 7:10 PM
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type batchTestUser struct {
+	ID   string
+	Name string
+}
+
+func TestAddCacheBatchHandle(t *testing.T) {
+	newTestManager(t)
+
+	var loaded [][]string
+	f := func(ctx context.Context, ids []string) (map[string]interface{}, error) {
+		loaded = append(loaded, ids)
+		result := make(map[string]interface{})
+		for _, id := range ids {
+			if id == "404" {
+				continue
+			}
+			result[id] = &batchTestUser{ID: id, Name: "user_" + id}
+		}
+		return result, nil
+	}
+	param := NewAddCacheParam("batch_user:%s")
+
+	result := new(map[string]*batchTestUser)
+	require.NoError(t, AddCacheBatchHandle(context.TODO(), []string{"1", "2"}, result, f, param))
+	assert.Len(t, *result, 2)
+	assert.Equal(t, "user_1", (*result)["1"].Name)
+
+	result = new(map[string]*batchTestUser)
+	require.NoError(t, AddCacheBatchHandle(context.TODO(), []string{"1", "2", "3", "404"}, result, f, param))
+	assert.Len(t, *result, 3)
+	assert.Equal(t, "user_3", (*result)["3"].Name)
+	assert.Equal(t, [][]string{{"1", "2"}, {"3", "404"}}, loaded)
+}
+
+func TestMGetInvalidReceiver(t *testing.T) {
+	newTestManager(t)
+	assert.Error(t, client.MGet(context.TODO(), []string{"a"}, new(string)))
+	assert.Error(t, client.MGet(context.TODO(), []string{"a"}, map[string]string{}))
+}
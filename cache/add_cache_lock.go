@@ -20,6 +20,10 @@ type addCacheLockParam struct {
 	blockingTimeout      time.Duration
 	noLockRaiseException bool
 	noLockReturn         constant.ErrorDict
+	watchdog             bool
+	redlock              bool
+	cluster              string
+	fair                 bool
 }
 
 type SetCacheLockParam func(param *addCacheLockParam)
@@ -80,8 +84,61 @@ func CacheLockWithCacheValueFunc(f func() string) SetCacheLockParam {
 	}
 }
 
+// CacheLockWithWatchdog
+//
+//	@Description: 开启后在operator执行期间每隔timeout/3(不小于1ms)续期一次锁，operator结束或ctx结束时停止，
+//	续期发现锁已被其他持有者获得或在锁过期前都未能续期成功时取消operator的ctx，
+//	operator未返回错误时AddCacheLockHandler返回ErrorCacheLockLost。timeout为0(不过期)时不需要续期
+//	@param b
+//	@return SetCacheLockParam
+func CacheLockWithWatchdog(b bool) SetCacheLockParam {
+	return func(param *addCacheLockParam) {
+		param.watchdog = b
+	}
+}
+
+// CacheLockWithRedlock 使用配置的RedlockAddrs中的多个独立redis节点加锁(Redlock)，
+// 未配置节点时加锁失败，Local storage的key同样使用Redlock
+func CacheLockWithRedlock(b bool) SetCacheLockParam {
+	return func(param *addCacheLockParam) {
+		param.redlock = b
+	}
+}
+
+// CacheLockWithCluster 使用指定名称的redis连接加锁，优先于配置的LockCluster及按key前缀的路由，
+// 使用Redlock或key为Local storage时忽略该参数
+func CacheLockWithCluster(name string) SetCacheLockParam {
+	return func(param *addCacheLockParam) {
+		param.cluster = name
+	}
+}
+
+// CacheLockWithFair 等待锁的调用按开始等待的顺序(FIFO)获得锁，需要配合CacheLockWithBlockingTimeout使用，
+// Redlock不支持公平模式，此时忽略该参数
+func CacheLockWithFair(b bool) SetCacheLockParam {
+	return func(param *addCacheLockParam) {
+		param.fair = b
+	}
+}
+
+// AddCacheLockOperator 通过GetFencingToken(ctx, cacheKey)获取本次持有锁的fencing token
 type AddCacheLockOperator func(ctx context.Context) (i interface{}, e error)
 
+type fencingTokenCtxKey string
+
+// GetFencingToken
+//
+//	@Description: 获取ctx中cacheKey对应的锁的fencing token，每次获得锁时token单调递增，
+//	下游写入时携带该token并拒绝小于已见最大值的请求，可以避免锁过期后旧持有者的写入覆盖新持有者
+//	@param ctx
+//	@param cacheKey 与NewAddCacheLockParam的cacheKey相同
+//	@return int64
+//	@return bool 当前ctx未持有该锁时为false
+func GetFencingToken(ctx context.Context, cacheKey string) (int64, bool) {
+	token, ok := ctx.Value(fencingTokenCtxKey(cacheKey)).(int64)
+	return token, ok
+}
+
 // AddCacheLockHandler
 //
 //	@Description:对o function进行修饰，根据params添加缓存锁，并返回o执行结果
@@ -128,7 +185,19 @@ func addCacheLock(o AddCacheLockOperator, param *addCacheLockParam) AddCacheLock
 			}
 			return param.noLockReturn, errors.WithStack(constant.CommonErrorServer.WithMsg("blocked by cache lock"))
 		}
-		i, e = o(ctx)
+		opCtx := context.WithValue(ctx, fencingTokenCtxKey(key), lock.fencingToken)
+		if param.watchdog && lock.lockSuccess && lock.cacheTimeout > 0 {
+			w := lock.startWatchdog(opCtx)
+			// 在release之前停止续期
+			defer w.stop()
+			opCtx = w.ctx
+			i, e = o(opCtx)
+			if w.isLost() && e == nil {
+				e = errors.WithStack(constant.ErrorCacheLockLost)
+			}
+			return i, e
+		}
+		i, e = o(opCtx)
 		return i, e
 	}
 }
@@ -141,9 +210,18 @@ type cacheLock struct {
 	lockSuccess     bool
 	blockingTimeout time.Duration
 	didInheritLock  bool
+
+	// store中使用添加namespace后的key
+	storeKey     string
+	store        lockStore
+	cluster      string // store使用的redis连接名称，用于指标
+	fencingToken int64
+	fair         bool
 }
 
 func newLock(key string, param *addCacheLockParam, value string) *cacheLock {
+	storeKey := client.buildKey(key)
+	store, cluster := getLockStore(storeKey, param.redlock, param.cluster)
 	return &cacheLock{
 		cacheKey:        key,
 		cacheValue:      value,
@@ -152,10 +230,14 @@ func newLock(key string, param *addCacheLockParam, value string) *cacheLock {
 		blockingTimeout: param.blockingTimeout,
 		// 新锁没有继承标记
 		didInheritLock: false,
+		storeKey:       storeKey,
+		store:          store,
+		cluster:        cluster,
+		fair:           param.fair,
 	}
 }
 
-// acquire 获得锁
+// acquire 获得锁，锁被占用时在blockingTimeout内等待锁的释放通知并重试，见blockingAcquire
 func (l *cacheLock) acquire(ctx context.Context) bool {
 	log := logger.CtxSugar(ctx)
 	enterTime := time.Now()
@@ -164,44 +246,58 @@ func (l *cacheLock) acquire(ctx context.Context) bool {
 		return true
 	}
 
-	numOfRetry := 0
-	c := GetCacheManager()
-	for {
+	attempts := 0
+	l.lockSuccess = blockingAcquire(ctx, l.blockingTimeout, func() bool {
+		attempts++
 		startTime := time.Now()
-		err := c.Add(ctx, l.cacheKey, l.cacheValue, l.cacheTimeout)
+		token, err := l.tryAcquire(ctx)
 		timeToken := time.Since(startTime).Milliseconds()
-		if err == nil {
-			l.lockSuccess = true
-		} else {
+		if err != nil {
 			log.Errorf("cache lock acquire call error|cache_key=%s,cache_value=%s,err=%v", l.cacheKey, l.cacheValue, err)
-			l.lockSuccess = false
 		}
-		if timeToken > warningThreshold {
+		if timeToken > getSlowOpThreshold().Milliseconds() {
 			log.Warnf("cache_is_too_slow|cache_key=%s,cache_value=%s,time_token=%d[ms]", l.cacheKey, l.cacheValue, timeToken)
 		}
+		l.fencingToken = token
+		return err == nil && token > 0
+	}, func() (<-chan struct{}, func()) {
+		return l.store.subscribe(ctx, l.storeKey)
+	})
+	recordLockWait("lock", l.cluster, metricsKeyPrefix(l.cacheKey), l.lockSuccess, attempts, enterTime)
 
-		if l.lockSuccess {
-			// set lock to context
-			addCacheLockContext := ctx.Value(addCacheLockCtxKey)
-			if addCacheLockContext != nil {
-				if m, ok := addCacheLockContext.(*sync.Map); ok {
-					m.Store(l.cacheKey, l.cacheValue)
-				}
-			}
-			return l.lockSuccess
-		} else {
-			timeToken := time.Since(enterTime)
-			if timeToken <= l.blockingTimeout {
-				waitTime := util.MinDuration(l.blockingTimeout-timeToken, (1<<numOfRetry)*time.Second/lockPollingIntervalDiv)
-				time.Sleep(waitTime)
-				numOfRetry++
-				continue
+	if l.lockSuccess {
+		// set lock to context
+		addCacheLockContext := ctx.Value(addCacheLockCtxKey)
+		if addCacheLockContext != nil {
+			if m, ok := addCacheLockContext.(*sync.Map); ok {
+				m.Store(l.cacheKey, l.cacheValue)
 			}
-			nowCacheValue := l.getNowCacheValue(ctx, c)
-			log.Errorf("acquire lock failed|cacheKey=%s,cacheValue=%s,cacheTimeout=%d,nowCacheValue=%+v", l.cacheKey, l.cacheValue, l.cacheTimeout.Milliseconds(), nowCacheValue)
-			return false
 		}
+		return l.lockSuccess
+	}
+	l.leaveQueue(ctx)
+	nowCacheValue := l.getNowCacheValue(ctx)
+	log.Errorf("acquire lock failed|cacheKey=%s,cacheValue=%s,cacheTimeout=%d,nowCacheValue=%+v,ctxErr=%v", l.cacheKey, l.cacheValue, l.cacheTimeout.Milliseconds(), nowCacheValue, ctx.Err())
+	return false
+}
+
+// tryAcquire 公平模式下store不支持公平锁时退化为普通加锁
+func (l *cacheLock) tryAcquire(ctx context.Context) (int64, error) {
+	if fs, ok := l.store.(fairLockStore); ok && l.fair {
+		return fs.acquireFair(ctx, l.storeKey, l.cacheValue, l.cacheTimeout)
+	}
+	return l.store.acquire(ctx, l.storeKey, l.cacheValue, l.cacheTimeout)
+}
 
+// leaveQueue 公平模式下放弃等待时离开等待队列，避免阻塞后面的等待者
+func (l *cacheLock) leaveQueue(ctx context.Context) {
+	fs, ok := l.store.(fairLockStore)
+	if !ok || !l.fair {
+		return
+	}
+	// ctx可能已经结束，离开队列不受其影响
+	if err := fs.leaveQueue(context.Background(), l.storeKey, l.cacheValue); err != nil {
+		logger.CtxSugar(ctx).Warnf("cache lock leave queue failed|cacheKey=%s, cacheValue=%s, err=%+v", l.cacheKey, l.cacheValue, err)
 	}
 }
 
@@ -212,11 +308,12 @@ func (l *cacheLock) inheritLockFromCurrentContext(ctx context.Context) bool {
 	if !ok {
 		return false
 	}
-	c := GetCacheManager()
-	nowCacheValue := l.getNowCacheValue(ctx, c)
+	nowCacheValue := l.getNowCacheValue(ctx)
 	if val == *nowCacheValue {
 		l.didInheritLock = true
 		l.cacheValue = val
+		// 继承的锁沿用外层获得锁时的token
+		l.fencingToken, _ = GetFencingToken(ctx, l.cacheKey)
 		return true
 	}
 	return false
@@ -237,18 +334,21 @@ func (l *cacheLock) getValueFromCtx(addCacheLockContext interface{}) (bool, stri
 	return false, ""
 }
 
-func (l *cacheLock) getNowCacheValue(ctx context.Context, c Client) *string {
-	nowCacheValue := new(string)
-	err := c.Get(ctx, l.cacheKey, nowCacheValue)
-	if err != nil {
-		nowCacheValue = util.StringToPtr("?")
+func (l *cacheLock) getNowCacheValue(ctx context.Context) *string {
+	nowCacheValue, err := l.store.get(ctx, l.storeKey)
+	if err != nil || nowCacheValue == "" {
+		return util.StringToPtr("?")
 	}
-	return nowCacheValue
+	return &nowCacheValue
+}
+
+// refresh 仍持有锁时重置锁的过期时间，锁已过期或被其他持有者获得时返回false
+func (l *cacheLock) refresh(ctx context.Context) (bool, error) {
+	return l.store.refresh(ctx, l.storeKey, l.cacheValue, l.cacheTimeout)
 }
 
 func (l *cacheLock) release(ctx context.Context) {
 	log := logger.CtxSugar(ctx)
-	c := GetCacheManager()
 	if l.lockSuccess {
 		// 如果使用map删除它
 		addCacheLockContext := ctx.Value(addCacheLockCtxKey)
@@ -258,15 +358,12 @@ func (l *cacheLock) release(ctx context.Context) {
 			}
 		}
 
-		// 如果设置了完成后删除
+		// 如果设置了完成后删除，仅当锁仍由自己持有时原子删除
 		if l.deleteAfterDone {
-			nowCacheValue := l.getNowCacheValue(ctx, c)
-			if *nowCacheValue == l.cacheValue {
-				err := c.Delete(ctx, l.cacheKey)
-				if err != nil {
-					log.Errorf("cache lock, release delete error|cacheKey=%s, cacheValue=%+v, cacheTimeout=%d[ms]", l.cacheKey, l.cacheValue, l.cacheTimeout.Milliseconds())
-				}
-			} else {
+			released, err := l.store.release(ctx, l.storeKey, l.cacheValue)
+			if err != nil {
+				log.Errorf("cache lock, release delete error|cacheKey=%s, cacheValue=%+v, cacheTimeout=%d[ms], err=%+v", l.cacheKey, l.cacheValue, l.cacheTimeout.Milliseconds(), err)
+			} else if !released {
 				log.Errorf("cache lock, release|cache lock already expire|cacheKey=%s, cacheValue=%+v, cacheTimeout=%d[ms]", l.cacheKey, l.cacheValue, l.cacheTimeout.Milliseconds())
 			}
 			l.lockSuccess = false
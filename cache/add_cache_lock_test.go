@@ -2,8 +2,18 @@
 package cache_test
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/JianWangEx/commonService/cache"
+	"github.com/JianWangEx/commonService/cache/cachetest"
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func mainTestLockKey() string {
@@ -11,28 +21,77 @@ func mainTestLockKey() string {
 }
 
 func TestAddCacheLockDecorator(t *testing.T) {
-	//path := "./config/redis_config.toml"
-	//err := config.InitCacheTomlConfig(path)
-	//if err != nil {
-	//	panic(err)
-	//}
-	//err = cache.Init()
-	//if err != nil {
-	//	panic(err)
-	//}
-	//
-	//d1 := cache.NewAddCacheLockParam(
-	//	mainTestLockKey(),
-	//	cache.CacheLockWithTimeout(5*time.Minute),
-	//	cache.CacheLockWithBlockingTimeout(5*time.Second),
-	//	cache.CacheLockWithDeleteAfterDone(true),
-	//	cache.CacheLockWithNoLockRaiseException(false),
-	//	cache.CacheLockWithNoLockReturn(constant.ErrorCodeErrorLock),
-	//)
-	//i, err := cache.AddCacheLockHandler(context.TODO(), func(ctx context.Context) (i interface{}, e error) {
-	//	fmt.Println("exec")
-	//	return i, e
-	//}, d1)
-	//
-	//fmt.Print(i, err)
+	server, _ := cachetest.NewTestManager(t)
+
+	d1 := cache.NewAddCacheLockParam(
+		mainTestLockKey(),
+		cache.CacheLockWithTimeout(5*time.Minute),
+		cache.CacheLockWithBlockingTimeout(5*time.Second),
+		cache.CacheLockWithDeleteAfterDone(true),
+		cache.CacheLockWithNoLockRaiseException(false),
+		cache.CacheLockWithNoLockReturn(constant.ErrorCodeErrorLock),
+	)
+
+	var running, maxRunning, total int32
+	var tokens sync.Map
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.AddCacheLockHandler(context.TODO(), func(ctx context.Context) (i interface{}, e error) {
+				n := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					m := atomic.LoadInt32(&maxRunning)
+					if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+						break
+					}
+				}
+				token, ok := cache.GetFencingToken(ctx, mainTestLockKey())
+				assert.True(t, ok)
+				tokens.Store(token, true)
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&total, 1)
+				return nil, nil
+			}, d1)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), maxRunning)
+	assert.Equal(t, int32(5), total)
+	count := 0
+	tokens.Range(func(_, _ interface{}) bool {
+		count++
+		return true
+	})
+	assert.Equal(t, 5, count)
+	// deleteAfterDone为true时执行结束后删除锁
+	assert.False(t, server.Exists(mainTestLockKey()))
+}
+
+func TestAddCacheLockBlocked(t *testing.T) {
+	server, _ := cachetest.NewTestManager(t)
+	require.NoError(t, server.Set(mainTestLockKey(), "other"))
+
+	d1 := cache.NewAddCacheLockParam(
+		mainTestLockKey(),
+		cache.CacheLockWithBlockingTimeout(50*time.Millisecond),
+		cache.CacheLockWithNoLockReturn(constant.ErrorCodeErrorLock),
+	)
+	called := false
+	i, err := cache.AddCacheLockHandler(context.TODO(), func(ctx context.Context) (i interface{}, e error) {
+		called = true
+		return nil, nil
+	}, d1)
+	assert.Error(t, err)
+	assert.Equal(t, constant.ErrorCodeErrorLock, i)
+	assert.False(t, called)
+
+	// 其他持有者的锁不会被删除
+	val, err := server.Get(mainTestLockKey())
+	require.NoError(t, err)
+	assert.Equal(t, "other", val)
 }
@@ -0,0 +1,58 @@
+// Package cache @Author  wangjian    2023/8/27 10:20 AM
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddCacheHandleWithNegativeCache(t *testing.T) {
+	newTestManager(t)
+
+	for _, storage := range []Storage{Local, Main} {
+		calls := 0
+		f := func(ctx context.Context, receiver interface{}) (interface{}, error) {
+			calls++
+			return nil, nil
+		}
+		param := NewAddCacheParam("negative_key", WithCacheStore(storage), WithNegativeCache(time.Minute))
+
+		result := new(string)
+		err := AddCacheHandle(context.TODO(), result, f, param)
+		assert.Equal(t, constant.ErrorAddCacheGotNilResult, err)
+		err = AddCacheHandle(context.TODO(), result, f, param)
+		assert.Equal(t, constant.ErrorAddCacheGotNilResult, err)
+		assert.Equal(t, 1, calls, storage)
+
+		// 未开启negative cache的调用者忽略空值标记
+		err = AddCacheHandle(context.TODO(), result, f, NewAddCacheParam("negative_key", WithCacheStore(storage)))
+		assert.Equal(t, constant.ErrorAddCacheGotNilResult, err)
+		assert.Equal(t, 2, calls, storage)
+	}
+}
+
+func TestAddCacheHandleWithNegativeCacheCanStoreResult(t *testing.T) {
+	newTestManager(t)
+
+	errNotFound := errors.New("not found")
+	f := func(ctx context.Context, receiver interface{}) (interface{}, error) {
+		return "", nil
+	}
+	param := NewAddCacheParam("negative_empty_key",
+		WithNegativeCache(time.Minute),
+		WithNegativeCacheError(errNotFound),
+		WithCanStoreResult(func(v interface{}) bool {
+			s, ok := v.(string)
+			return ok && s != ""
+		}),
+	)
+
+	result := new(string)
+	assert.NoError(t, AddCacheHandle(context.TODO(), result, f, param))
+	assert.Equal(t, errNotFound, AddCacheHandle(context.TODO(), result, f, param))
+}
@@ -0,0 +1,55 @@
+// Package cache @Author  wangjian    2023/8/26 11:05 AM
+package cache
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
+	logger "github.com/JianWangEx/commonService/log"
+)
+
+// refreshingKeys 正在后台刷新的storage+key，同一个key在同一进程内只有一个后台刷新
+var refreshingKeys sync.Map
+
+// refreshInBackground
+//
+//	@Description: 使用新的receiver在后台调用real func并写入cache，不影响当前调用者的receiver，
+//	后台刷新不受当前请求ctx的取消影响，但保留ctx中的logger
+func refreshInBackground(ctx context.Context, receiver interface{}, m AddCacheOperator, param *AddCacheParam, key string, keyRaw string) {
+	flightKey := getFlightKey(param.getStorage(key), key)
+	if _, loaded := refreshingKeys.LoadOrStore(flightKey, struct{}{}); loaded {
+		return
+	}
+
+	refreshCtx := logger.WithLogger(context.Background(), logger.CtxLogger(ctx))
+	newReceiver := reflect.New(reflect.TypeOf(receiver).Elem()).Interface()
+	go func() {
+		log := logger.CtxSugar(refreshCtx)
+		defer func() {
+			if err := recover(); err != nil {
+				log.Errorf("addCache refresh in background panic|key=%s, err=%+v", keyRaw, err)
+			}
+			refreshingKeys.Delete(flightKey)
+		}()
+		if _, err := loadAndSetCache(refreshCtx, newReceiver, m, param, key, keyRaw); err != nil {
+			log.Warnf("addCache refresh in background failed|key=%s, err=%+v", keyRaw, err)
+		}
+	}()
+}
+
+// shouldRefreshEarly
+//
+//	@Description: XFetch算法，now - delta * beta * ln(rand()) >= expireAt 时提前刷新，
+//	其中rand()取值范围为(0, 1]，回源耗时越长、越接近过期，刷新的概率越大
+func shouldRefreshEarly(meta *valueMeta, beta float64, now time.Time) bool {
+	expireAt := meta.expireAt()
+	if meta.delta <= 0 || expireAt <= 0 {
+		return false
+	}
+	gap := -float64(meta.delta) * beta * math.Log(1-rand.Float64())
+	return float64(now.UnixMilli())+gap >= float64(expireAt)
+}
@@ -0,0 +1,50 @@
+// Package cache @Author  wangjian    2023/8/26 3:30 PM
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddCacheHandleWithStaleWhileRevalidate(t *testing.T) {
+	newTestManager(t)
+
+	var version int32
+	f := func(ctx context.Context, receiver interface{}) (interface{}, error) {
+		return atomic.AddInt32(&version, 1), nil
+	}
+	param := NewAddCacheParam("swr_key", WithTimeout(time.Minute), WithStaleWhileRevalidate(20*time.Millisecond))
+
+	result := new(int32)
+	require.NoError(t, AddCacheHandle(context.TODO(), result, f, param))
+	assert.Equal(t, int32(1), *result)
+
+	// 超过soft TTL后返回旧值，并在后台刷新
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, AddCacheHandle(context.TODO(), result, f, param))
+	assert.Equal(t, int32(1), *result)
+
+	assert.Eventually(t, func() bool {
+		r := new(int32)
+		_ = AddCacheHandle(context.TODO(), r, f, param)
+		return *r == 2
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestShouldRefreshEarly(t *testing.T) {
+	now := time.Now()
+	// 距离过期还很远
+	meta := &valueMeta{hardExpireAt: now.Add(time.Hour).UnixMilli(), delta: 10}
+	assert.False(t, shouldRefreshEarly(meta, 1, now))
+	// 已经过期
+	meta = &valueMeta{hardExpireAt: now.Add(-time.Millisecond).UnixMilli(), delta: 10}
+	assert.True(t, shouldRefreshEarly(meta, 1, now))
+	// 没有回源耗时
+	meta = &valueMeta{hardExpireAt: now.Add(-time.Millisecond).UnixMilli()}
+	assert.False(t, shouldRefreshEarly(meta, 1, now))
+}
@@ -0,0 +1,99 @@
+// Package cache @Author  wangjian    2023/8/24 2:30 PM
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	logger "github.com/JianWangEx/commonService/log"
+	"github.com/JianWangEx/commonService/util"
+	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
+)
+
+var singleFlightGroup singleflight.Group
+
+// loadWithSingleFlight
+//
+//	@Description: 合并同一个storage+key的并发回源请求，只有一个调用者(leader)执行real func并写入cache，
+//	其他调用者复制leader的结果到自己的receiver中
+func loadWithSingleFlight(ctx context.Context, receiver interface{}, m AddCacheOperator, param *AddCacheParam, key string, keyRaw string) (interface{}, error) {
+	storage := param.getStorage(key)
+	isLeader := false
+	i, e, _ := singleFlightGroup.Do(getFlightKey(storage, key), func() (interface{}, error) {
+		isLeader = true
+		if param.singleFlightLockTimeout > 0 && storage != Local {
+			return loadWithDistributedLock(ctx, receiver, m, param, key, keyRaw)
+		}
+		return loadAndSetCache(ctx, receiver, m, param, key, keyRaw)
+	})
+	if isLeader || e != nil || util.IsNil(i) {
+		return i, e
+	}
+	return i, util.DeepCopy(receiver, i)
+}
+
+// getFlightKey 同一个key的多层decorator(如Local+Main)使用不同的storage，需要区分，否则会在同一个goroutine中互相等待
+func getFlightKey(storage Storage, key string) string {
+	return strings.Join([]string{storage.name(), key}, "|")
+}
+
+// loadWithDistributedLock 获得redis锁的调用者回源，其他调用者等待缓存结果，等待超时或锁不可用时自行回源
+func loadWithDistributedLock(ctx context.Context, receiver interface{}, m AddCacheOperator, param *AddCacheParam, key string, keyRaw string) (interface{}, error) {
+	log := logger.CtxSugar(ctx)
+	c := GetCacheManager()
+	// 直接使用redis client，需要自行添加namespace前缀
+	lockKey := client.buildKey(strings.Join([]string{key, singleFlightLockKeySuffix}, ""))
+	lockValue := uuid.NewString()
+	// 与缓存的key使用相同的redis连接
+	cluster, err := client.getCluster(param.cluster, lockKey)
+	if err != nil {
+		return nil, err
+	}
+
+	locked, err := cluster.client.SetNX(ctx, lockKey, lockValue, param.singleFlightLockTimeout).Result()
+	if err != nil {
+		log.Warnf("addCache single flight lock failed, load directly|key=%s, err=%+v", key, err)
+		return loadAndSetCache(ctx, receiver, m, param, key, keyRaw)
+	}
+	if locked {
+		defer func() {
+			if err := compareAndDeleteScript.Run(ctx, cluster.client, []string{lockKey}, lockValue).Err(); err != nil {
+				log.Warnf("addCache single flight unlock failed|key=%s, err=%+v", key, err)
+			}
+		}()
+		// 获得锁之前其他实例可能已经写入了cache
+		if err := c.Get(ctx, key, receiver, param.getKeyCallOptions(key, keyRaw)...); err == nil {
+			return receiver, nil
+		}
+		return loadAndSetCache(ctx, receiver, m, param, key, keyRaw)
+	}
+
+	if waitForCache(ctx, receiver, param, key, keyRaw) {
+		return receiver, nil
+	}
+	log.Warnf("addCache single flight wait for cache timeout, load directly|key=%s, wait_timeout=%d[ms]", key, param.singleFlightWaitTimeout.Milliseconds())
+	return loadAndSetCache(ctx, receiver, m, param, key, keyRaw)
+}
+
+// waitForCache 在singleFlightWaitTimeout内轮询cache，直到获取到值或ctx结束
+func waitForCache(ctx context.Context, receiver interface{}, param *AddCacheParam, key string, keyRaw string) bool {
+	c := GetCacheManager()
+	timer := time.NewTimer(param.singleFlightWaitTimeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(singleFlightPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return false
+		case <-ticker.C:
+			if err := c.Get(ctx, key, receiver, param.getKeyCallOptions(key, keyRaw)...); err == nil {
+				return true
+			}
+		}
+	}
+}
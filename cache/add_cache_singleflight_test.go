@@ -0,0 +1,84 @@
+// Package cache @Author  wangjian    2023/8/24 5:20 PM
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddCacheHandleWithSingleFlight(t *testing.T) {
+	newTestManager(t)
+
+	var calls int32
+	f := func(ctx context.Context, receiver interface{}) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return map[string]string{"name": "cat"}, nil
+	}
+
+	for _, storage := range []Storage{Local, Main} {
+		atomic.StoreInt32(&calls, 0)
+		wg := sync.WaitGroup{}
+		for n := 0; n < 10; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result := new(map[string]string)
+				err := AddCacheHandle(context.TODO(), result, f, NewAddCacheParam("single_flight_key", WithCacheStore(storage), WithSingleFlight(true)))
+				require.NoError(t, err)
+				assert.Equal(t, "cat", (*result)["name"])
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls), storage)
+	}
+}
+
+func TestAddCacheHandleWithDistributedSingleFlight(t *testing.T) {
+	server := newTestManager(t)
+	ctx := context.TODO()
+	lockKey := "user:1" + singleFlightLockKeySuffix
+
+	var calls int32
+	f := func(ctx context.Context, receiver interface{}) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return "cat", nil
+	}
+	newParam := func(key string) *AddCacheParam {
+		return NewAddCacheParam(key, WithDistributedSingleFlight(time.Second, 200*time.Millisecond))
+	}
+
+	// 获得锁的调用者回源，完成后释放锁
+	result := new(string)
+	require.NoError(t, AddCacheHandle(ctx, result, f, newParam("user:1")))
+	assert.Equal(t, "cat", *result)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.False(t, server.Exists(lockKey))
+
+	// 其他实例持有锁时等待其写入的缓存结果，不再回源
+	require.NoError(t, GetCacheManager().Delete(ctx, "user:1"))
+	require.NoError(t, server.Set(lockKey, "other"))
+	go func() {
+		time.Sleep(2 * singleFlightPollInterval)
+		_ = GetCacheManager().Set(ctx, "user:1", "dog", time.Minute)
+	}()
+	result = new(string)
+	require.NoError(t, AddCacheHandle(ctx, result, f, newParam("user:1")))
+	assert.Equal(t, "dog", *result)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	// 不释放其他实例的锁
+	assert.True(t, server.Exists(lockKey))
+
+	// 等待超时后自行回源
+	require.NoError(t, GetCacheManager().Delete(ctx, "user:1"))
+	result = new(string)
+	require.NoError(t, AddCacheHandle(ctx, result, f, newParam("user:1")))
+	assert.Equal(t, "cat", *result)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
@@ -5,38 +5,53 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAddCache(t *testing.T) {
-	//path := "./config/redis_config.toml"
-	//err := config.InitCacheTomlConfig(path)
-	//if err != nil {
-	//	panic(err)
-	//}
-	//err = Init()
-	//if err != nil {
-	//	panic(err)
-	//}
-	//
-	//key := uuid.NewString()
-	//fmt.Println(key)
-	//d1 := NewAddCacheParam(
-	//	key,
-	//	WithCacheStore(Local),
-	//	WithEncodeKeyType(Md5),
-	//)
-	//d2 := NewAddCacheParam(
-	//	key,
-	//	WithCacheStore(Main),
-	//	WithEncodeKeyType(Md5),
-	//)
-	//result := new(map[string]string)
-	//err = AddCacheHandle(context.TODO(), result, testFunc, d1, d2)
-	//if err != nil {
-	//	panic(err)
-	//}
-	//fmt.Println("success")
-	//fmt.Println(result)
+	server := newTestManager(t)
+
+	key := uuid.NewString()
+	d1 := NewAddCacheParam(
+		key,
+		WithCacheStore(Local),
+		WithEncodeKeyType(Md5),
+	)
+	d2 := NewAddCacheParam(
+		key,
+		WithCacheStore(Main),
+		WithEncodeKeyType(Md5),
+	)
+	calls := 0
+	f := func(ctx context.Context, receiver interface{}) (interface{}, error) {
+		calls++
+		return testFunc(ctx, receiver)
+	}
+
+	result := new(map[string]string)
+	err := AddCacheHandle(context.TODO(), result, f, d1, d2)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"name": "cat", "age": "1"}, *result)
+	assert.Len(t, server.Keys(), 1)
+
+	// local cache被清空后从redis读取
+	client.localCacheClient.Flush(context.TODO())
+	result = new(map[string]string)
+	err = AddCacheHandle(context.TODO(), result, f, d1, d2)
+	require.NoError(t, err)
+	assert.Equal(t, "cat", (*result)["name"])
+	assert.Equal(t, 1, calls)
+
+	// redis中的key过期后重新回源
+	client.localCacheClient.Flush(context.TODO())
+	server.FastForward(defaultCacheTimeoutSecond + time.Second)
+	err = AddCacheHandle(context.TODO(), result, f, d1, d2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
 }
 
 func testFunc(ctx context.Context, testValue interface{}) (interface{}, error) {
@@ -3,8 +3,8 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
 	"github.com/JianWangEx/commonService/constant"
+	logger "github.com/JianWangEx/commonService/log"
 	"github.com/JianWangEx/commonService/util"
 	"github.com/pkg/errors"
 	"github.com/redis/go-redis/v9"
@@ -13,100 +13,326 @@ import (
 	"time"
 )
 
-func (c *cacheManager) Get(ctx context.Context, key string, receiver interface{}) error {
+func (c *cacheManager) Get(ctx context.Context, key string, receiver interface{}, opts ...CallOption) error {
 	// 校验receiver类型
 	rv := reflect.ValueOf(receiver)
 	if rv.Kind() != reflect.Ptr || rv.IsNil() {
 		return constant.ErrorNilReceiverOrNotPtr
 	}
 
-	storage := getStorage(key)
-
-	switch storage {
+	opt := c.newCallOption(opts...)
+	key = c.buildKey(key)
+	switch opt.getStorage(key) {
 	case Local:
-		val, found := c.localCacheClient.Get(ctx, key)
-		if !found {
+		return c.getLocal(ctx, key, receiver, opt)
+	case Tiered:
+		// 先读L1，未命中再读redis并回填L1，空值标记同样回填
+		err := c.getLocal(ctx, key, receiver, opt)
+		if err == nil || err == constant.ErrorNegativeCacheHit {
+			return err
+		}
+		err = c.getMain(ctx, key, receiver, opt)
+		if c.degraded(err) {
+			// L1已经未命中
 			return constant.ErrorCacheMiss
 		}
-		return util.SetValue(val, receiver)
+		if err == constant.ErrorNegativeCacheHit {
+			c.localCacheClient.Set(ctx, key, newLocalTombstone(opt.metaReceiver), c.tieredLocalTimeout)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		c.setLocal(ctx, key, receiver, c.tieredLocalTimeout, &callOption{meta: opt.metaReceiver})
+		return nil
 	default: // default is main
-		result := c.redisClient.Get(ctx, key)
-		if err := result.Err(); err != nil {
-			if err == redis.Nil {
-				return constant.ErrorCacheMiss
-			}
-			return errors.Wrap(err, "redis cache error")
+		if c.isHotKey(key) {
+			return c.getHotKey(ctx, key, receiver, opt)
 		}
-
-		if result.Val() == "null" {
-			return constant.ErrorCacheMiss
+		err := c.getMain(ctx, key, receiver, opt)
+		if c.degraded(err) {
+			return c.getLocal(ctx, key, receiver, opt)
 		}
+		return err
+	}
+}
 
-		data, err := result.Bytes()
-		if err != nil {
-			return errors.Wrap(err, "redis cache error")
+func (c *cacheManager) Set(ctx context.Context, key string, value interface{}, expired time.Duration, opts ...CallOption) error {
+	opt := c.newCallOption(opts...)
+	expired = c.getTTL(key, expired, opt)
+	key = c.buildKey(key)
+	switch opt.getStorage(key) {
+	case Local:
+		c.setLocal(ctx, key, value, expired, opt)
+		c.publishInvalidation(ctx, key)
+	case Tiered:
+		// write through，redis写入成功后再写L1
+		if err := c.setMain(ctx, key, value, expired, opt); err != nil && !c.degraded(err) {
+			return err
+		}
+		c.setLocal(ctx, key, value, c.getTieredLocalTimeout(expired), opt)
+		c.publishInvalidation(ctx, key)
+	default: // default is main
+		if err := c.setMain(ctx, key, value, expired, opt); err != nil {
+			if !c.degraded(err) {
+				return err
+			}
+			c.setLocal(ctx, key, value, c.getTieredLocalTimeout(expired), opt)
+		} else {
+			c.invalidateHotKeys(ctx, key)
 		}
-		// only json code type
-		return json.Unmarshal(data, receiver)
 	}
+	return c.addTagsOrDegrade(ctx, expired, opt, key)
 }
 
-func (c *cacheManager) Set(ctx context.Context, key string, value interface{}, expired time.Duration) error {
-	storage := getStorage(key)
-	switch storage {
+func (c *cacheManager) Add(ctx context.Context, key string, value interface{}, expired time.Duration, opts ...CallOption) error {
+	opt := c.newCallOption(opts...)
+	expired = c.getTTL(key, expired, opt)
+	key = c.buildKey(key)
+	switch opt.getStorage(key) {
 	case Local:
-		c.localCacheClient.Set(ctx, key, util.GetValue(value), expired)
-		return nil
-	default: // default is main
-		data, err := json.Marshal(value)
-		if err != nil {
-			return errors.Wrap(err, "json marshal error")
+		if err := c.addLocal(ctx, key, value, expired, opt); err != nil {
+			return err
 		}
-		result := c.redisClient.Set(ctx, key, data, expired)
-		if err := result.Err(); err != nil {
-			return errors.Wrap(err, "redis cache error")
+		c.publishInvalidation(ctx, key)
+	case Tiered:
+		// 以redis的结果为准，L1中的值可能已经过期
+		if err := c.addMain(ctx, key, value, expired, opt); err != nil {
+			if !c.degraded(err) {
+				return err
+			}
+			return c.addLocal(ctx, key, value, c.getTieredLocalTimeout(expired), opt)
 		}
-		return nil
+		c.setLocal(ctx, key, value, c.getTieredLocalTimeout(expired), opt)
+		c.publishInvalidation(ctx, key)
+	default: // default is main
+		if err := c.addMain(ctx, key, value, expired, opt); err != nil {
+			if !c.degraded(err) {
+				return err
+			}
+			return c.addLocal(ctx, key, value, c.getTieredLocalTimeout(expired), opt)
+		}
+		// 热点key在local cache中的空值标记需要删除
+		c.invalidateHotKeys(ctx, key)
 	}
+	return c.addTagsOrDegrade(ctx, expired, opt, key)
 }
 
-func (c *cacheManager) Add(ctx context.Context, key string, value interface{}, expired time.Duration) error {
-	storage := getStorage(key)
-	switch storage {
+func (c *cacheManager) Delete(ctx context.Context, key string, opts ...CallOption) error {
+	opt := c.newCallOption(opts...)
+	key = c.buildKey(key)
+	switch opt.getStorage(key) {
 	case Local:
-		if err := c.localCacheClient.Add(ctx, key, util.GetValue(value), expired); err != nil {
-			return constant.ErrorFailedOperation
+		c.deleteLocal(ctx, key)
+		c.publishInvalidation(ctx, key)
+	case Tiered:
+		c.deleteLocal(ctx, key)
+		if err := c.deleteMain(ctx, key, opt); err != nil && !c.degraded(err) {
+			return err
 		}
+		c.publishInvalidation(ctx, key)
 	default: // default is main
-		data, err := json.Marshal(value)
-		if err != nil {
-			return errors.Wrap(err, "json marshal error")
+		err := c.deleteMain(ctx, key, opt)
+		if c.degraded(err) {
+			// 降级期间写入local cache的值也需要删除
+			c.deleteLocal(ctx, key)
+			return nil
+		}
+		if err == nil {
+			c.invalidateHotKeys(ctx, key)
 		}
-		result := c.redisClient.SetNX(ctx, key, data, expired)
-		if err := result.Err(); err != nil {
-			return errors.Wrap(err, "redis cache error")
+		return err
+	}
+	return nil
+}
+
+func (c *cacheManager) getLocal(ctx context.Context, key string, receiver interface{}, opt *callOption) error {
+	val, err := c.getLocalValue(ctx, key, opt)
+	if err != nil {
+		return err
+	}
+	// local cache中保存的是解引用后的值，receiver为指向nil指针的指针时需要重新取地址
+	if rv, ok := convertLocalValue(val, reflect.TypeOf(receiver).Elem()); ok {
+		reflect.ValueOf(receiver).Elem().Set(rv)
+		return nil
+	}
+	return util.SetValue(val, receiver)
+}
+
+// getLocalValue 返回local cache中保存的值本身
+func (c *cacheManager) getLocalValue(ctx context.Context, key string, opt *callOption) (val interface{}, err error) {
+	defer func(startTime time.Time) {
+		c.recordOp(Local, "", metricsOpGet, key, err, startTime)
+	}(time.Now())
+	val, found := c.localCacheClient.Get(ctx, key)
+	if !found {
+		return nil, constant.ErrorCacheMiss
+	}
+	if entry, ok := val.(*localEntry); ok {
+		if opt.metaReceiver != nil {
+			*opt.metaReceiver = entry.meta
 		}
-		if !result.Val() {
-			return constant.ErrorFailedOperation
+		if entry.tombstone {
+			return nil, constant.ErrorNegativeCacheHit
 		}
+		val = entry.value
 	}
+	return val, nil
+}
+
+func (c *cacheManager) setLocal(ctx context.Context, key string, value interface{}, expired time.Duration, opt *callOption) {
+	defer c.recordOp(Local, "", metricsOpSet, key, nil, time.Now())
+	if opt.tombstone {
+		c.localCacheClient.Set(ctx, key, newLocalTombstone(opt.meta), expired)
+		return
+	}
+	c.localCacheClient.Set(ctx, key, newLocalValue(value, opt.meta), expired)
+}
+
+func (c *cacheManager) addLocal(ctx context.Context, key string, value interface{}, expired time.Duration, opt *callOption) error {
+	startTime := time.Now()
+	if err := c.localCacheClient.Add(ctx, key, newLocalValue(value, opt.meta), expired); err != nil {
+		c.recordOp(Local, "", metricsOpAdd, key, constant.ErrorFailedOperation, startTime)
+		return constant.ErrorFailedOperation
+	}
+	c.recordOp(Local, "", metricsOpAdd, key, nil, startTime)
 	return nil
 }
 
-func (c *cacheManager) Delete(ctx context.Context, key string) error {
-	storage := getStorage(key)
-	switch storage {
-	case Local:
-		c.localCacheClient.Delete(ctx, key)
-	default: // default is main
-		result := c.redisClient.Del(ctx, key)
-		if err := result.Err(); err != nil {
-			return errors.Wrapf(err, "redis cache err")
+func (c *cacheManager) deleteLocal(ctx context.Context, key string) {
+	defer c.recordOp(Local, "", metricsOpDelete, key, nil, time.Now())
+	c.localCacheClient.Delete(ctx, key)
+}
+
+func (c *cacheManager) getMain(ctx context.Context, key string, receiver interface{}, opt *callOption) (err error) {
+	clusterName := opt.cluster
+	defer func(startTime time.Time) {
+		c.recordOp(Main, clusterName, metricsOpGet, key, err, startTime)
+	}(time.Now())
+	cluster, err := c.getCluster(opt.cluster, key)
+	if err != nil {
+		return err
+	}
+	clusterName = cluster.name
+	var result *redis.StringCmd
+	if ttl, ok := c.getSlidingTTL(key, opt); ok {
+		// 读取的同时重置过期时间
+		result = cluster.client.GetEx(ctx, key, ttl)
+	} else {
+		result = cluster.client.Get(ctx, key)
+	}
+	if err := result.Err(); err != nil {
+		if err == redis.Nil {
+			return constant.ErrorCacheMiss
 		}
+		return errors.Wrap(err, "redis cache error")
+	}
+
+	data, err := result.Bytes()
+	if err != nil {
+		return errors.Wrap(err, "redis cache error")
+	}
+	// 根据值中记录的codec解码，与写入时的配置无关
+	return c.decodeValue(key, data, receiver, opt.metaReceiver, opt)
+}
+
+func (c *cacheManager) setMain(ctx context.Context, key string, value interface{}, expired time.Duration, opt *callOption) (err error) {
+	clusterName := opt.cluster
+	defer func(startTime time.Time) {
+		c.recordOp(Main, clusterName, metricsOpSet, key, err, startTime)
+	}(time.Now())
+	cluster, err := c.getCluster(opt.cluster, key)
+	if err != nil {
+		return err
+	}
+	clusterName = cluster.name
+	data, err := c.encodeValue(key, value, opt)
+	if err != nil {
+		return err
+	}
+	result := cluster.client.Set(ctx, key, data, expired)
+	if err := result.Err(); err != nil {
+		return errors.Wrap(err, "redis cache error")
 	}
 	return nil
 }
 
+func (c *cacheManager) addMain(ctx context.Context, key string, value interface{}, expired time.Duration, opt *callOption) (err error) {
+	clusterName := opt.cluster
+	defer func(startTime time.Time) {
+		c.recordOp(Main, clusterName, metricsOpAdd, key, err, startTime)
+	}(time.Now())
+	cluster, err := c.getCluster(opt.cluster, key)
+	if err != nil {
+		return err
+	}
+	clusterName = cluster.name
+	data, err := c.encodeValue(key, value, opt)
+	if err != nil {
+		return err
+	}
+	result := cluster.client.SetNX(ctx, key, data, expired)
+	if err := result.Err(); err != nil {
+		return errors.Wrap(err, "redis cache error")
+	}
+	if !result.Val() {
+		return constant.ErrorFailedOperation
+	}
+	return nil
+}
+
+func (c *cacheManager) deleteMain(ctx context.Context, key string, opt *callOption) (err error) {
+	clusterName := opt.cluster
+	defer func(startTime time.Time) {
+		c.recordOp(Main, clusterName, metricsOpDelete, key, err, startTime)
+	}(time.Now())
+	cluster, err := c.getCluster(opt.cluster, key)
+	if err != nil {
+		return err
+	}
+	clusterName = cluster.name
+	result := cluster.client.Del(ctx, key)
+	if err := result.Err(); err != nil {
+		return errors.Wrapf(err, "redis cache err")
+	}
+	return nil
+}
+
+// getTieredLocalTimeout L1的过期时间不超过redis中的过期时间
+func (c *cacheManager) getTieredLocalTimeout(expired time.Duration) time.Duration {
+	if expired > 0 && expired < c.tieredLocalTimeout {
+		return expired
+	}
+	return c.tieredLocalTimeout
+}
+
+// localEntry 需要存储元数据或空值标记时，local cache中保存的值
+type localEntry struct {
+	value     interface{}
+	meta      valueMeta
+	tombstone bool
+}
+
+func newLocalTombstone(meta *valueMeta) *localEntry {
+	entry := &localEntry{tombstone: true}
+	if meta != nil {
+		entry.meta = *meta
+	}
+	return entry
+}
+
+// newLocalValue local cache保存值本身，有元数据时使用localEntry包装
+func newLocalValue(value interface{}, meta *valueMeta) interface{} {
+	if meta.isZero() {
+		return util.GetValue(value)
+	}
+	return &localEntry{value: util.GetValue(value), meta: *meta}
+}
+
+// getStorage 根据key的后缀判断storage，以".local"结尾时为Local
+//
+// Deprecated: 使用CallWithStorage或WithCacheStore显式指定storage，
+// 并通过配置DisableStorageSuffix关闭后缀判断
 func getStorage(key string) Storage {
 	storage := Main
 	splits := strings.Split(key, ".")
@@ -117,7 +343,58 @@ func getStorage(key string) Storage {
 	return storage
 }
 
+// FlushCache
+//
+//	@Description: 清空local cache，并删除每个redis连接中当前namespace下的所有key(锁的fencing计数器除外)，不会影响共用同一个redis的其他服务，
+//	未配置namespace时只清空local cache并返回ErrorFlushWithoutNamespace
+//	@param ctx
+//	@return string
+//	@return error
 func (c *cacheManager) FlushCache(ctx context.Context) (string, error) {
-	c.localCacheClient.Cache.Flush()
-	return c.redisClient.FlushDB(ctx).Result()
+	c.localCacheClient.Flush(ctx)
+	if c.invalidator != nil {
+		c.invalidator.publish(ctx, &invalidationMessage{Flush: true})
+	}
+	if c.keyPrefix == "" {
+		return "", constant.ErrorFlushWithoutNamespace
+	}
+	// 锁的等待队列等key以"{"+namespace开头，见getLockSubKey
+	prefixes := []string{c.keyPrefix, "{" + c.keyPrefix}
+	for _, cluster := range c.getClusters() {
+		for _, prefix := range prefixes {
+			deleted, err := c.unlinkByPrefix(ctx, cluster.client, prefix)
+			if err != nil {
+				return "", errors.Wrapf(err, "cluster=%s", cluster.name)
+			}
+			logger.CtxSugar(ctx).Infof("cache flushed|cluster=%s, prefix=%s, deleted=%d", cluster.name, prefix, deleted)
+		}
+	}
+	return "OK", nil
+}
+
+// publishInvalidation 通知其他实例删除local cache中的key，未开启时不做任何操作
+func (c *cacheManager) publishInvalidation(ctx context.Context, keys ...string) {
+	if c.invalidator == nil || len(keys) == 0 {
+		return
+	}
+	c.invalidator.publish(ctx, &invalidationMessage{Keys: keys})
+}
+
+func (c *cacheManager) newCallOption(opts ...CallOption) *callOption {
+	opt := &callOption{
+		codec:             c.codec,
+		compressType:      c.compressType,
+		compressThreshold: c.compressThreshold,
+		writeValueHeader:  c.writeValueHeader,
+
+		defaultStorage:       c.defaultStorage,
+		disableStorageSuffix: c.disableStorageSuffix,
+	}
+	for _, f := range opts {
+		f(opt)
+	}
+	if opt.codec == nil {
+		opt.codec = JSONCodec{}
+	}
+	return opt
 }
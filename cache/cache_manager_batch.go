@@ -0,0 +1,302 @@
+// Package cache @Author  wangjian    2023/8/28 2:15 PM
+package cache
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	logger "github.com/JianWangEx/commonService/log"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// MGet
+//
+//	@Description: 批量读取，Main storage按redis连接分组，每个连接使用一个pipeline，Local storage逐个读取，
+//	Tiered storage先读L1，未命中的key再通过pipeline读取redis并回填L1。
+//	未命中、空值标记及解码失败的key不会写入receiver
+//	@param ctx
+//	@param keys
+//	@param receiver 指向key为string的map的指针，map为nil时会自动创建
+//	@param opts
+//	@return error
+func (c *cacheManager) MGet(ctx context.Context, keys []string, receiver interface{}, opts ...CallOption) error {
+	mv, err := getMapReceiver(receiver)
+	if err != nil {
+		return err
+	}
+	opt := c.newCallOption(opts...)
+	elemType := mv.Type().Elem()
+
+	// mainKeys为添加namespace后的key，写入receiver时使用调用方传入的key
+	mainKeys := make([]string, 0, len(keys))
+	rawKeys := make(map[string]string, len(keys))
+	// 从redis读取后需要回填local cache的key(Tiered storage及热点key)及其过期时间
+	backfill := make(map[string]time.Duration)
+	for _, rawKey := range keys {
+		key := c.buildKey(rawKey)
+		rawKeys[key] = rawKey
+		storage := opt.getStorage(key)
+		hot := storage == Main && c.isHotKey(key)
+		if storage == Local || storage == Tiered || hot {
+			if val, err := c.getLocalValue(ctx, key, opt); err == nil {
+				if ev, ok := convertLocalValue(val, elemType); ok {
+					setMapValue(mv, rawKey, ev)
+					continue
+				}
+			}
+			if storage == Local {
+				continue
+			}
+			backfill[key] = c.tieredLocalTimeout
+			if hot {
+				backfill[key] = c.hotKeys.localTimeout
+			}
+		}
+		mainKeys = append(mainKeys, key)
+	}
+	if len(mainKeys) == 0 {
+		return nil
+	}
+
+	groups, err := c.groupByCluster(opt.cluster, mainKeys)
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		if err := c.mGetMain(ctx, group, backfill, rawKeys, mv, opt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mGetMain 通过一个pipeline读取同一个redis连接中的key
+func (c *cacheManager) mGetMain(ctx context.Context, group *clusterKeys, backfill map[string]time.Duration, rawKeys map[string]string, mv reflect.Value, opt *callOption) error {
+	elemType := mv.Type().Elem()
+	cluster, mainKeys := group.cluster, group.keys
+	startTime := time.Now()
+	cmds := make([]*redis.StringCmd, len(mainKeys))
+	_, err := cluster.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for idx, key := range mainKeys {
+			cmds[idx] = pipe.Get(ctx, key)
+		}
+		return nil
+	})
+	GetMetrics().ObserveLatency(Main, cluster.name, metricsOpMGet, time.Since(startTime))
+	if err != nil && err != redis.Nil {
+		c.recordBatch(Main, cluster.name, metricsOpGet, mainKeys, err)
+		if c.degraded(err) {
+			c.mGetDegraded(ctx, mainKeys, backfill, rawKeys, mv, opt)
+			return nil
+		}
+		return errors.Wrapf(err, "redis cache error|cluster=%s", cluster.name)
+	}
+
+	log := logger.CtxSugar(ctx)
+	for idx, key := range mainKeys {
+		data, err := cmds[idx].Bytes()
+		if err == redis.Nil {
+			err = constant.ErrorCacheMiss
+		}
+		if err != nil {
+			c.recordBatch(Main, cluster.name, metricsOpGet, []string{key}, err)
+			continue
+		}
+		ev := reflect.New(elemType)
+		err = c.decodeValue(key, data, ev.Interface(), nil, opt)
+		c.recordBatch(Main, cluster.name, metricsOpGet, []string{key}, err)
+		if err != nil {
+			if err != constant.ErrorCacheMiss && err != constant.ErrorNegativeCacheHit {
+				log.Warnf("cache MGet decode failed|key=%s, err=%+v", key, err)
+			}
+			continue
+		}
+		setMapValue(mv, rawKeys[key], ev.Elem())
+		if timeout, ok := backfill[key]; ok {
+			c.localCacheClient.Set(ctx, key, newLocalValue(ev.Interface(), nil), timeout)
+		}
+	}
+	return nil
+}
+
+// MSet 批量写入，Main/Tiered storage的key按redis连接分组，每个连接使用一个pipeline写入
+func (c *cacheManager) MSet(ctx context.Context, values map[string]interface{}, expired time.Duration, opts ...CallOption) error {
+	opt := c.newCallOption(opts...)
+
+	mainValues := make(map[string][]byte, len(values))
+	mainKeys := make([]string, 0, len(values))
+	localKeys := make([]string, 0)
+	keys := make([]string, 0, len(values))
+	rawKeys := make(map[string]string, len(values))
+	// 每个key单独计算过期时间，tag的过期时间为其中最大的
+	ttls := make(map[string]time.Duration, len(values))
+	var maxTTL time.Duration
+	for rawKey, value := range values {
+		key := c.buildKey(rawKey)
+		keys = append(keys, key)
+		rawKeys[key] = rawKey
+		ttls[key] = c.getTTL(rawKey, expired, opt)
+		if ttls[key] > maxTTL {
+			maxTTL = ttls[key]
+		}
+		storage := opt.getStorage(key)
+		if storage == Local {
+			c.setLocal(ctx, key, value, ttls[key], opt)
+			localKeys = append(localKeys, key)
+			continue
+		}
+		data, err := c.encodeValue(key, value, opt)
+		if err != nil {
+			return errors.Wrapf(err, "key=%s", key)
+		}
+		mainValues[key] = data
+		mainKeys = append(mainKeys, key)
+	}
+
+	groups, err := c.groupByCluster(opt.cluster, mainKeys)
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		startTime := time.Now()
+		_, err := group.cluster.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range group.keys {
+				pipe.Set(ctx, key, mainValues[key], ttls[key])
+			}
+			return nil
+		})
+		GetMetrics().ObserveLatency(Main, group.cluster.name, metricsOpMSet, time.Since(startTime))
+		c.recordBatch(Main, group.cluster.name, metricsOpSet, group.keys, err)
+		if err != nil && !c.degraded(err) {
+			return errors.Wrapf(err, "redis cache error|cluster=%s", group.cluster.name)
+		}
+		// write through，redis写入成功后再写L1，降级时Main storage的key同样写入local cache
+		for _, key := range group.keys {
+			if err != nil || opt.getStorage(key) == Tiered {
+				c.setLocal(ctx, key, values[rawKeys[key]], c.getTieredLocalTimeout(ttls[key]), opt)
+				localKeys = append(localKeys, key)
+			}
+		}
+		if err == nil {
+			c.invalidateHotKeys(ctx, filterStorage(opt, group.keys, Main)...)
+		}
+	}
+	c.publishInvalidation(ctx, localKeys...)
+	return c.addTagsOrDegrade(ctx, maxTTL, opt, keys...)
+}
+
+// MDelete 批量删除，Main/Tiered storage的key按redis连接分组，每个连接使用一个pipeline删除
+func (c *cacheManager) MDelete(ctx context.Context, keys []string, opts ...CallOption) error {
+	opt := c.newCallOption(opts...)
+
+	mainKeys := make([]string, 0, len(keys))
+	localKeys := make([]string, 0)
+	for _, rawKey := range keys {
+		key := c.buildKey(rawKey)
+		storage := opt.getStorage(key)
+		if storage == Local || storage == Tiered {
+			c.deleteLocal(ctx, key)
+			localKeys = append(localKeys, key)
+		}
+		if storage != Local {
+			mainKeys = append(mainKeys, key)
+		}
+	}
+
+	groups, err := c.groupByCluster(opt.cluster, mainKeys)
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		// 集群模式下多个key可能不在同一个slot，逐个DEL
+		startTime := time.Now()
+		_, err := group.cluster.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range group.keys {
+				pipe.Del(ctx, key)
+			}
+			return nil
+		})
+		GetMetrics().ObserveLatency(Main, group.cluster.name, metricsOpMDel, time.Since(startTime))
+		c.recordBatch(Main, group.cluster.name, metricsOpDelete, group.keys, err)
+		if c.degraded(err) {
+			// 降级期间写入local cache的值也需要删除
+			for _, key := range group.keys {
+				c.deleteLocal(ctx, key)
+			}
+		} else if err != nil {
+			return errors.Wrapf(err, "redis cache err|cluster=%s", group.cluster.name)
+		} else {
+			c.invalidateHotKeys(ctx, filterStorage(opt, group.keys, Main)...)
+		}
+	}
+	c.publishInvalidation(ctx, localKeys...)
+	return nil
+}
+
+// mGetDegraded 熔断降级时从local cache读取Main storage的key，需要回填的key已经读过local cache
+func (c *cacheManager) mGetDegraded(ctx context.Context, mainKeys []string, backfill map[string]time.Duration, rawKeys map[string]string, mv reflect.Value, opt *callOption) {
+	elemType := mv.Type().Elem()
+	for _, key := range mainKeys {
+		if _, ok := backfill[key]; ok {
+			continue
+		}
+		val, err := c.getLocalValue(ctx, key, opt)
+		if err != nil {
+			continue
+		}
+		if ev, ok := convertLocalValue(val, elemType); ok {
+			setMapValue(mv, rawKeys[key], ev)
+		}
+	}
+}
+
+// filterStorage 返回keys中使用storage的key
+func filterStorage(opt *callOption, keys []string, storage Storage) []string {
+	filtered := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if opt.getStorage(key) == storage {
+			filtered = append(filtered, key)
+		}
+	}
+	return filtered
+}
+
+// getMapReceiver 校验receiver为指向key为string的map的指针，map为nil时创建
+func getMapReceiver(receiver interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(receiver)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return reflect.Value{}, constant.ErrorReceiverNotMapPtr
+	}
+	mv := rv.Elem()
+	if mv.Kind() != reflect.Map || mv.Type().Key().Kind() != reflect.String {
+		return reflect.Value{}, constant.ErrorReceiverNotMapPtr
+	}
+	if mv.IsNil() {
+		mv.Set(reflect.MakeMap(mv.Type()))
+	}
+	return mv, nil
+}
+
+// convertLocalValue local cache中保存的是解引用后的值，elemType为指针时需要重新取地址
+func convertLocalValue(val interface{}, elemType reflect.Type) (reflect.Value, bool) {
+	if val == nil {
+		return reflect.Value{}, false
+	}
+	rv := reflect.ValueOf(val)
+	if rv.Type().AssignableTo(elemType) {
+		return rv, true
+	}
+	if elemType.Kind() == reflect.Ptr && rv.Type().AssignableTo(elemType.Elem()) {
+		pv := reflect.New(elemType.Elem())
+		pv.Elem().Set(rv)
+		return pv, true
+	}
+	return reflect.Value{}, false
+}
+
+func setMapValue(mv reflect.Value, key string, value reflect.Value) {
+	mv.SetMapIndex(reflect.ValueOf(key).Convert(mv.Type().Key()), value)
+}
@@ -0,0 +1,38 @@
+// Package cachetest @Author  wangjian    2023/9/20 10:30 AM
+package cachetest
+
+import (
+	"testing"
+
+	"github.com/JianWangEx/commonService/cache"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// NewTestManager
+//
+//	@Description: 启动内存中的redis(miniredis，支持GET、SET、DEL、EXPIRE、pub/sub及EVAL等命令)，
+//	并用其初始化cache包，测试结束时关闭。除redis连接外使用当前的cache配置。
+//	miniredis中的key不会随时间过期，需要通过返回的Miniredis.FastForward推进时间
+//	@param tb
+//	@return *miniredis.Miniredis
+//	@return cache.Client
+func NewTestManager(tb testing.TB) (*miniredis.Miniredis, cache.Client) {
+	tb.Helper()
+	server := miniredis.RunT(tb)
+	redisClient := NewRedisClient(server)
+	if err := cache.InitWithClient(redisClient); err != nil {
+		tb.Fatalf("init cache with miniredis failed: %+v", err)
+	}
+	tb.Cleanup(func() {
+		_ = cache.Close()
+	})
+	return server, cache.GetCacheManager()
+}
+
+// NewRedisClient 创建连接到miniredis的redis client，用于同时需要直接操作redis的测试
+func NewRedisClient(server *miniredis.Miniredis) redis.UniversalClient {
+	return redis.NewClient(&redis.Options{
+		Addr: server.Addr(),
+	})
+}
@@ -0,0 +1,366 @@
+// Package cache @Author  wangjian    2023/9/22 11:00 AM
+package cache
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cacheConfig "github.com/JianWangEx/commonService/cache/config"
+	"github.com/JianWangEx/commonService/constant"
+	logger "github.com/JianWangEx/commonService/log"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+type RedisConnectMode string
+
+const (
+	// RedisConnectEager Init时Ping redis，失败时返回错误
+	RedisConnectEager RedisConnectMode = "eager"
+	// RedisConnectLazy Init时不Ping，第一次使用时再建立连接
+	RedisConnectLazy RedisConnectMode = "lazy"
+	// RedisConnectBackground Init时不Ping，在后台重试连接直到成功
+	RedisConnectBackground RedisConnectMode = "background"
+)
+
+// ParseRedisConnectMode 根据配置中的名称获取连接方式，名称为空时为eager
+func ParseRedisConnectMode(name string) (RedisConnectMode, error) {
+	if name == "" {
+		return RedisConnectEager, nil
+	}
+	for _, m := range []RedisConnectMode{RedisConnectEager, RedisConnectLazy, RedisConnectBackground} {
+		if strings.EqualFold(string(m), name) {
+			return m, nil
+		}
+	}
+	return RedisConnectEager, errors.Wrapf(constant.ErrorUnknownRedisConnectMode, "redis_connect_mode=%s", name)
+}
+
+type circuitState int32
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half_open"
+	}
+	return "closed"
+}
+
+// circuitBreaker
+//
+//	@Description: redis的熔断器。连续失败threshold次后打开，打开期间所有命令直接返回ErrorCircuitOpen；
+//	打开openTimeout后进入半开状态，只放行一个命令探测，成功则关闭，失败则重新打开
+type circuitBreaker struct {
+	threshold   int
+	openTimeout time.Duration
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	// 半开状态下是否已有探测中的命令
+	probing bool
+}
+
+func newCircuitBreaker(threshold int, openTimeout time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		threshold = defaultCircuitBreakerFailureThreshold
+	}
+	if openTimeout <= 0 {
+		openTimeout = defaultCircuitBreakerOpenTimeout
+	}
+	return &circuitBreaker{
+		threshold:   threshold,
+		openTimeout: openTimeout,
+	}
+}
+
+// getCircuitBreaker 未开启熔断时返回nil
+func getCircuitBreaker() *circuitBreaker {
+	config := cacheConfig.GetCacheConfig()
+	if !config.CircuitBreakerEnabled {
+		return nil
+	}
+	return newCircuitBreaker(config.CircuitBreakerFailureThreshold, time.Duration(config.CircuitBreakerOpenTimeout)*time.Millisecond)
+}
+
+// allow 返回nil时调用方需要在命令结束后调用onResult
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return constant.ErrorCircuitOpen
+		}
+		b.setState(circuitHalfOpen)
+		b.probing = true
+		return nil
+	case circuitHalfOpen:
+		if b.probing {
+			return constant.ErrorCircuitOpen
+		}
+		b.probing = true
+		return nil
+	}
+	return nil
+}
+
+// onResult ctx为执行命令使用的ctx，调用方取消或超时导致的错误既不算失败也不算成功
+func (b *circuitBreaker) onResult(ctx context.Context, err error) {
+	failed := isRedisFailure(err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil && ctx.Err() != nil {
+		// 无法判断redis是否可用，半开状态下允许下一次探测
+		if b.state == circuitHalfOpen {
+			b.probing = false
+		}
+		return
+	}
+	switch b.state {
+	case circuitHalfOpen:
+		b.probing = false
+		if failed {
+			b.open()
+		} else {
+			b.failures = 0
+			b.setState(circuitClosed)
+		}
+	case circuitClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.threshold {
+			b.open()
+		}
+	}
+	// 打开状态下收到的是打开之前发出的命令的结果，忽略
+}
+
+// trip 打开熔断，用于后台连接redis成功之前
+func (b *circuitBreaker) trip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.open()
+}
+
+// reset 关闭熔断
+func (b *circuitBreaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+	b.setState(circuitClosed)
+}
+
+func (b *circuitBreaker) getState() circuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *circuitBreaker) open() {
+	b.openedAt = time.Now()
+	b.setState(circuitOpen)
+}
+
+func (b *circuitBreaker) setState(state circuitState) {
+	if b.state == state {
+		return
+	}
+	logger.CtxSugar(context.Background()).Warnf("redis circuit breaker state changed|from=%s, to=%s, failures=%d", b.state, state, b.failures)
+	b.state = state
+}
+
+// isRedisFailure 只有网络错误、连接池耗尽、client已关闭及集群不可用时的错误才算失败，
+// key不存在、其他redis返回的错误及调用方取消或超时都不算
+func isRedisFailure(err error) bool {
+	if err == nil || err == redis.Nil {
+		return false
+	}
+	if errors.Is(err, constant.ErrorCircuitOpen) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		// 集群切换主从时的错误说明redis不可用
+		msg := redisErr.Error()
+		return strings.HasPrefix(msg, "LOADING") || strings.HasPrefix(msg, "READONLY") || strings.HasPrefix(msg, "CLUSTERDOWN")
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		// 连接失败、读写超时
+		return true
+	}
+	// go-redis未导出连接池超时的错误
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, redis.ErrClosed) ||
+		err.Error() == redisPoolTimeoutMsg
+}
+
+// circuitBreakerHook 在redis client的所有命令及pipeline上应用熔断，不影响pub/sub；
+// go-redis无法移除hook，每个client只添加一次，重新Init时替换其中的熔断器，见setCircuitBreaker
+type circuitBreakerHook struct {
+	// *circuitBreaker，为nil时不熔断
+	breaker atomic.Value
+}
+
+// circuitBreakerHooks redis.UniversalClient -> *circuitBreakerHook
+var circuitBreakerHooks sync.Map
+
+// setCircuitBreaker 设置redisClient当前使用的熔断器，breaker为nil时关闭熔断，
+// 同一个client被多次Init时只保留一个hook，之前的熔断器不再生效
+func setCircuitBreaker(redisClient redis.UniversalClient, breaker *circuitBreaker) {
+	if hook, ok := circuitBreakerHooks.Load(redisClient); ok {
+		hook.(*circuitBreakerHook).breaker.Store(breaker)
+		return
+	}
+	if breaker == nil {
+		return
+	}
+	hook := &circuitBreakerHook{}
+	hook.breaker.Store(breaker)
+	circuitBreakerHooks.Store(redisClient, hook)
+	redisClient.AddHook(hook)
+}
+
+// removeCircuitBreaker redisClient关闭后调用
+func removeCircuitBreaker(redisClient redis.UniversalClient) {
+	circuitBreakerHooks.Delete(redisClient)
+}
+
+func (h *circuitBreakerHook) getBreaker(ctx context.Context) *circuitBreaker {
+	if bypassCircuitBreaker(ctx) {
+		return nil
+	}
+	return h.breaker.Load().(*circuitBreaker)
+}
+
+type circuitBypassCtxKey struct{}
+
+// withoutCircuitBreaker 使用返回的ctx执行的命令不经过熔断，也不影响熔断的状态
+func withoutCircuitBreaker(ctx context.Context) context.Context {
+	return context.WithValue(ctx, circuitBypassCtxKey{}, true)
+}
+
+func bypassCircuitBreaker(ctx context.Context) bool {
+	bypass, _ := ctx.Value(circuitBypassCtxKey{}).(bool)
+	return bypass
+}
+
+func (h *circuitBreakerHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *circuitBreakerHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		breaker := h.getBreaker(ctx)
+		if breaker == nil {
+			return next(ctx, cmd)
+		}
+		if err := breaker.allow(); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		err := next(ctx, cmd)
+		breaker.onResult(ctx, err)
+		return err
+	}
+}
+
+func (h *circuitBreakerHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		breaker := h.getBreaker(ctx)
+		if breaker == nil {
+			return next(ctx, cmds)
+		}
+		if err := breaker.allow(); err != nil {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		err := next(ctx, cmds)
+		breaker.onResult(ctx, err)
+		return err
+	}
+}
+
+// degraded 熔断打开且开启降级时，Main storage的读写改为使用local cache
+func (c *cacheManager) degraded(err error) bool {
+	return c.degradeToLocal && errors.Is(err, constant.ErrorCircuitOpen)
+}
+
+// connectInBackground 后台重试Ping每个redis连接直到可用，期间该连接的熔断保持打开，
+// 返回的channel在所有连接可用后关闭，close后不再关闭
+func (c *cacheManager) connectInBackground() <-chan struct{} {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancelConnect = cancel
+	var wg sync.WaitGroup
+	for _, cluster := range c.getClusters() {
+		if cluster.client == nil {
+			continue
+		}
+		wg.Add(1)
+		cluster.connectInBackground(ctx, wg.Done)
+	}
+	connected := make(chan struct{})
+	go func() {
+		wg.Wait()
+		if ctx.Err() == nil {
+			close(connected)
+		}
+	}()
+	return connected
+}
+
+// connectInBackground 连接可用或ctx结束后调用done
+func (r *redisCluster) connectInBackground(ctx context.Context, done func()) {
+	if r.breaker != nil {
+		r.breaker.trip()
+	}
+	go func() {
+		defer done()
+		log := logger.CtxSugar(ctx)
+		for retry := 0; ; retry++ {
+			pingCtx, pingCancel := context.WithTimeout(ctx, redisConnectPingTimeout)
+			err := r.ping(pingCtx)
+			pingCancel()
+			if err == nil {
+				if r.breaker != nil {
+					r.breaker.reset()
+				}
+				log.Infof("redis connected in background|cluster=%s, retry=%d", r.name, retry)
+				return
+			}
+			log.Warnf("redis connect in background failed|cluster=%s, retry=%d, err=%+v", r.name, retry, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(redisConnectRetryInterval(retry)):
+			}
+		}
+	}()
+}
+
+func redisConnectRetryInterval(retry int) time.Duration {
+	if retry > redisConnectMaxRetryShift {
+		retry = redisConnectMaxRetryShift
+	}
+	return (1 << retry) * redisConnectRetryBaseInterval
+}
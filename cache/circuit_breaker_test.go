@@ -0,0 +1,224 @@
+// Package cache @Author  wangjian    2023/9/22 4:20 PM
+package cache
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	cacheConfig "github.com/JianWangEx/commonService/cache/config"
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRedisConnectMode(t *testing.T) {
+	mode, err := ParseRedisConnectMode("")
+	require.NoError(t, err)
+	assert.Equal(t, RedisConnectEager, mode)
+	mode, err = ParseRedisConnectMode("Background")
+	require.NoError(t, err)
+	assert.Equal(t, RedisConnectBackground, mode)
+	_, err = ParseRedisConnectMode("never")
+	assert.ErrorIs(t, err, constant.ErrorUnknownRedisConnectMode)
+}
+
+func TestIsRedisFailure(t *testing.T) {
+	assert.False(t, isRedisFailure(nil))
+	assert.False(t, isRedisFailure(redis.Nil))
+	assert.False(t, isRedisFailure(constant.ErrorCircuitOpen))
+	assert.False(t, isRedisFailure(errors.Wrap(context.Canceled, "redis")))
+	assert.False(t, isRedisFailure(context.DeadlineExceeded))
+	assert.False(t, isRedisFailure(errors.New("redis: unexpected type")))
+	assert.True(t, isRedisFailure(io.EOF))
+	assert.True(t, isRedisFailure(redis.ErrClosed))
+	assert.True(t, isRedisFailure(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}))
+	assert.True(t, isRedisFailure(errors.New(redisPoolTimeoutMsg)))
+}
+
+func TestCircuitBreakerIgnoreCallerTimeout(t *testing.T) {
+	b := newCircuitBreaker(1, 20*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	// 调用方的ctx超时导致的读超时不算失败
+	b.onResult(ctx, &net.OpError{Op: "read", Net: "tcp", Err: context.DeadlineExceeded})
+	b.onResult(ctx, context.DeadlineExceeded)
+	assert.Equal(t, circuitClosed, b.getState())
+
+	// 半开状态下调用方超时的探测不关闭也不打开熔断，允许下一次探测
+	b.onResult(context.TODO(), io.EOF)
+	assert.Equal(t, circuitOpen, b.getState())
+	time.Sleep(25 * time.Millisecond)
+	require.NoError(t, b.allow())
+	b.onResult(ctx, context.DeadlineExceeded)
+	assert.Equal(t, circuitHalfOpen, b.getState())
+	require.NoError(t, b.allow())
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(2, 20*time.Millisecond)
+	require.NoError(t, b.allow())
+	b.onResult(context.TODO(), io.EOF)
+	b.onResult(context.TODO(), nil)
+	b.onResult(context.TODO(), io.EOF)
+	assert.Equal(t, circuitClosed, b.getState())
+	b.onResult(context.TODO(), io.EOF)
+	assert.Equal(t, circuitOpen, b.getState())
+	assert.Equal(t, constant.ErrorCircuitOpen, b.allow())
+
+	// 半开状态只放行一个探测
+	time.Sleep(25 * time.Millisecond)
+	require.NoError(t, b.allow())
+	assert.Equal(t, circuitHalfOpen, b.getState())
+	assert.Equal(t, constant.ErrorCircuitOpen, b.allow())
+	b.onResult(context.TODO(), io.EOF)
+	assert.Equal(t, circuitOpen, b.getState())
+
+	time.Sleep(25 * time.Millisecond)
+	require.NoError(t, b.allow())
+	b.onResult(context.TODO(), redis.Nil)
+	assert.Equal(t, circuitClosed, b.getState())
+	require.NoError(t, b.allow())
+}
+
+// setCircuitBreakerConfig 修改全局配置并在测试结束时恢复
+func setCircuitBreakerConfig(t *testing.T, c cacheConfig.CircuitBreakerConfig) {
+	config := cacheConfig.GetCacheConfig()
+	old := config.CircuitBreakerConfig
+	config.CircuitBreakerConfig = c
+	t.Cleanup(func() {
+		config.CircuitBreakerConfig = old
+	})
+}
+
+func TestCacheDegradeToLocal(t *testing.T) {
+	setCircuitBreakerConfig(t, cacheConfig.CircuitBreakerConfig{
+		CircuitBreakerEnabled:          true,
+		CircuitBreakerFailureThreshold: 1,
+		CircuitBreakerOpenTimeout:      50,
+		CircuitBreakerDegradeToLocal:   true,
+	})
+	server := newTestManager(t)
+	manager := GetCacheManager()
+	ctx := context.TODO()
+
+	server.Close()
+	assert.Error(t, manager.Set(ctx, "degrade_key", "v1", time.Minute))
+	assert.Equal(t, circuitOpen, client.defaultCluster().breaker.getState())
+
+	// 熔断打开期间读写local cache，不等待redis
+	require.NoError(t, manager.Set(ctx, "degrade_key", "v2", time.Minute))
+	val := new(string)
+	require.NoError(t, manager.Get(ctx, "degrade_key", val))
+	assert.Equal(t, "v2", *val)
+	result := make(map[string]string)
+	require.NoError(t, manager.MGet(ctx, []string{"degrade_key"}, &result))
+	assert.Equal(t, map[string]string{"degrade_key": "v2"}, result)
+	require.NoError(t, manager.Delete(ctx, "degrade_key"))
+	assert.Equal(t, constant.ErrorCacheMiss, manager.Get(ctx, "degrade_key", val))
+
+	// redis恢复后半开探测成功，熔断关闭
+	require.NoError(t, server.Restart())
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, manager.Set(ctx, "degrade_key", "v3", time.Minute))
+	assert.Equal(t, circuitClosed, client.defaultCluster().breaker.getState())
+	got, err := server.Get("degrade_key")
+	require.NoError(t, err)
+	assert.Contains(t, got, "v3")
+}
+
+func TestCacheCircuitOpenWithoutDegrade(t *testing.T) {
+	setCircuitBreakerConfig(t, cacheConfig.CircuitBreakerConfig{
+		CircuitBreakerEnabled:          true,
+		CircuitBreakerFailureThreshold: 1,
+	})
+	server := newTestManager(t)
+	server.Close()
+
+	val := new(string)
+	assert.Error(t, GetCacheManager().Get(context.TODO(), "key", val))
+	assert.ErrorIs(t, GetCacheManager().Get(context.TODO(), "key", val), constant.ErrorCircuitOpen)
+}
+
+func TestConnectInBackground(t *testing.T) {
+	setCircuitBreakerConfig(t, cacheConfig.CircuitBreakerConfig{
+		CircuitBreakerEnabled:     true,
+		CircuitBreakerOpenTimeout: 60000,
+	})
+	server := newTestManager(t)
+	server.Close()
+
+	client.connectInBackground()
+	assert.Equal(t, circuitOpen, client.defaultCluster().breaker.getState())
+	require.NoError(t, server.Restart())
+	assert.Eventually(t, func() bool {
+		return client.defaultCluster().breaker.getState() == circuitClosed
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestInitEagerPingFailed(t *testing.T) {
+	setCircuitBreakerConfig(t, cacheConfig.CircuitBreakerConfig{
+		CircuitBreakerEnabled:          true,
+		CircuitBreakerFailureThreshold: 1,
+		CircuitBreakerOpenTimeout:      50,
+	})
+	server := newTestManager(t)
+	config := cacheConfig.GetCacheConfig()
+	oldRedisConfig := config.RedisConfig
+	config.RedisConfig = cacheConfig.RedisConfig{Addrs: []string{server.Addr()}, MaxRetries: -1}
+	t.Cleanup(func() {
+		config.RedisConfig = oldRedisConfig
+	})
+	server.Close()
+
+	// 与RedisClusters中的连接相同，Ping失败时保留client，由熔断降级
+	// newTestManager的清理会关闭新的client
+	require.Error(t, initClient(getRedisConn))
+	require.NotNil(t, client.defaultCluster().client)
+	val := new(string)
+	assert.Error(t, GetCacheManager().Get(context.TODO(), "key", val))
+
+	require.NoError(t, server.Restart())
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, GetCacheManager().Set(context.TODO(), "key", "v", time.Minute))
+	require.NoError(t, GetCacheManager().Get(context.TODO(), "key", val))
+	assert.Equal(t, "v", *val)
+}
+
+func TestCircuitBreakerReInitWithSameClient(t *testing.T) {
+	setCircuitBreakerConfig(t, cacheConfig.CircuitBreakerConfig{
+		CircuitBreakerEnabled:          true,
+		CircuitBreakerFailureThreshold: 1,
+		CircuitBreakerOpenTimeout:      60000,
+	})
+	server := newTestManager(t)
+	rdb := GetRedisClient()
+	oldBreaker := client.defaultCluster().breaker
+	require.NoError(t, InitWithClient(rdb))
+	newBreaker := client.defaultCluster().breaker
+	require.NotSame(t, oldBreaker, newBreaker)
+	ctx := context.TODO()
+
+	// 之前的熔断器不再生效
+	oldBreaker.trip()
+	require.NoError(t, rdb.Set(ctx, "reinit_key", "v", 0).Err())
+
+	newBreaker.trip()
+	assert.ErrorIs(t, rdb.Get(ctx, "reinit_key").Err(), constant.ErrorCircuitOpen)
+	newBreaker.reset()
+
+	// 关闭熔断后重新Init，已添加的hook不再熔断
+	setCircuitBreakerConfig(t, cacheConfig.CircuitBreakerConfig{})
+	require.NoError(t, InitWithClient(rdb))
+	require.Nil(t, client.defaultCluster().breaker)
+	newBreaker.trip()
+	got, err := rdb.Get(ctx, "reinit_key").Result()
+	require.NoError(t, err)
+	assert.Equal(t, "v", got)
+	assert.True(t, server.Exists("reinit_key"))
+}
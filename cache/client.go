@@ -9,21 +9,164 @@ import (
 
 // GetRedisClient
 //
-//	@Description: 返回一个使用redis的connection
+//	@Description: 返回default(RedisConfig)的redis连接，未Init时返回nil，其他连接见GetRedisClusterClient
 //	@return redis.UniversalClient
 func GetRedisClient() redis.UniversalClient {
-	return client.redisClient
+	return GetRedisClusterClient(DefaultCluster)
 }
 
 type Client interface {
 	// Get receiver should be ptr and not nil
-	Get(ctx context.Context, key string, receiver interface{}) error
+	Get(ctx context.Context, key string, receiver interface{}, opts ...CallOption) error
 
-	Set(ctx context.Context, key string, value interface{}, expired time.Duration) error
+	Set(ctx context.Context, key string, value interface{}, expired time.Duration, opts ...CallOption) error
 
-	Add(ctx context.Context, key string, value interface{}, expired time.Duration) error
+	Add(ctx context.Context, key string, value interface{}, expired time.Duration, opts ...CallOption) error
 
-	Delete(ctx context.Context, key string) error
+	Delete(ctx context.Context, key string, opts ...CallOption) error
+
+	// MGet receiver should be ptr to map with string key, like new(map[string]*User),
+	// only hit keys will be set to receiver
+	MGet(ctx context.Context, keys []string, receiver interface{}, opts ...CallOption) error
+
+	// MSet values key is cache key
+	MSet(ctx context.Context, values map[string]interface{}, expired time.Duration, opts ...CallOption) error
+
+	MDelete(ctx context.Context, keys []string, opts ...CallOption) error
+
+	// InvalidateTags delete all keys set with any of the tags, see CallWithTags
+	InvalidateTags(ctx context.Context, tags ...string) error
 
 	FlushCache(ctx context.Context) (string, error)
 }
+
+// callOption 单次调用的参数，未设置的项使用cacheManager的默认配置
+type callOption struct {
+	codec             Codec
+	compressType      CompressType
+	compressThreshold int
+	writeValueHeader  bool
+	storage           Storage // 为空时根据key的后缀判断，后缀不匹配时使用defaultStorage
+
+	defaultStorage       Storage
+	disableStorageSuffix bool
+
+	meta         *valueMeta // 写入时与值一起存储的元数据
+	metaReceiver *valueMeta // 读取时接收值中存储的元数据
+	tombstone    bool       // 写入空值标记(negative cache)，忽略value
+
+	tags []string // 写入时为key添加的tag
+
+	cluster string // Main storage使用的redis连接，为空时按key的前缀路由
+
+	ttlJitter int // 写入时过期时间随机浮动的百分比，expired为DefaultTTL时使用TTLRules的配置
+
+	encrypt bool // 写入Main storage时加密，未设置时根据EncryptionKeyPrefixes判断
+
+	// 不含namespace的key到其编码前key的映射，TTLRules及EncryptionKeyPrefixes按编码前的key匹配
+	ruleKeys map[string]string
+}
+
+// ruleKey key为不含namespace的key，返回匹配TTLRules及EncryptionKeyPrefixes使用的key
+func (opt *callOption) ruleKey(key string) string {
+	if raw, ok := opt.ruleKeys[key]; ok {
+		return raw
+	}
+	return key
+}
+
+// getStorage 显式指定的storage优先，其次根据key的后缀判断(deprecated)，最后使用配置的默认storage
+func (opt *callOption) getStorage(key string) Storage {
+	if opt.storage != "" {
+		return opt.storage
+	}
+	if !opt.disableStorageSuffix && getStorage(key) == Local {
+		return Local
+	}
+	if opt.defaultStorage != "" {
+		return opt.defaultStorage
+	}
+	return Main
+}
+
+type CallOption func(opt *callOption)
+
+// CallWithCodec 使用指定的编解码器写入Main storage，读取时总是根据值中记录的编解码器解码
+func CallWithCodec(codec Codec) CallOption {
+	return func(opt *callOption) {
+		opt.codec = codec
+	}
+}
+
+// CallWithCompression 编码后的值达到threshold(字节)时使用t压缩，t为CompressNone时不压缩
+func CallWithCompression(t CompressType, threshold int) CallOption {
+	return func(opt *callOption) {
+		opt.compressType = t
+		opt.compressThreshold = threshold
+	}
+}
+
+// CallWithStorage 显式指定storage，优先于key的后缀
+func CallWithStorage(storage Storage) CallOption {
+	return func(opt *callOption) {
+		opt.storage = storage
+	}
+}
+
+// callWithMeta 写入时与值一起存储元数据
+func callWithMeta(meta *valueMeta) CallOption {
+	return func(opt *callOption) {
+		opt.meta = meta
+	}
+}
+
+// callWithMetaReceiver 读取时接收值中存储的元数据，值中没有元数据时meta保持零值
+func callWithMetaReceiver(meta *valueMeta) CallOption {
+	return func(opt *callOption) {
+		opt.metaReceiver = meta
+	}
+}
+
+// callWithTombstone 写入空值标记，之后的Get返回constant.ErrorNegativeCacheHit
+func callWithTombstone() CallOption {
+	return func(opt *callOption) {
+		opt.tombstone = true
+	}
+}
+
+// callWithRuleKeys keys为经过WithEncodeKeyType编码的key到编码前key的映射，使用编码前的key匹配TTLRules及EncryptionKeyPrefixes
+func callWithRuleKeys(keys map[string]string) CallOption {
+	return func(opt *callOption) {
+		opt.ruleKeys = keys
+	}
+}
+
+// CallWithTags 写入时为key添加tag，之后可以通过InvalidateTags删除带有该tag的所有key
+func CallWithTags(tags ...string) CallOption {
+	return func(opt *callOption) {
+		opt.tags = append(opt.tags, tags...)
+	}
+}
+
+// CallWithCluster 使用指定名称的redis连接，优先于按key前缀的路由，批量操作中的所有key都使用该连接
+func CallWithCluster(name string) CallOption {
+	return func(opt *callOption) {
+		opt.cluster = name
+	}
+}
+
+// CallWithTTLJitter 写入时过期时间在expired上随机浮动percent%，避免批量写入的key同时过期，
+// expired为DefaultTTL时使用TTLRules中配置的浮动百分比
+func CallWithTTLJitter(percent int) CallOption {
+	return func(opt *callOption) {
+		opt.ttlJitter = percent
+	}
+}
+
+// CallWithEncryption 为true时写入Main storage的值使用EncryptionKeyProvider的当前密钥加密，
+// 匹配EncryptionKeyPrefixes的key总是加密，读取时根据值中记录的密钥ID解密
+func CallWithEncryption(encrypt bool) CallOption {
+	return func(opt *callOption) {
+		opt.encrypt = encrypt
+	}
+}
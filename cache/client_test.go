@@ -2,28 +2,120 @@
 package cache
 
 import (
+	"context"
 	"testing"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// newTestManager 使用miniredis初始化client，测试结束时关闭并恢复之前的client，
+// cachetest依赖cache包，包内的测试不能使用
+func newTestManager(t *testing.T) *miniredis.Miniredis {
+	server := miniredis.RunT(t)
+	oldClient, oldHandler := client, handler
+	require.NoError(t, InitWithClient(redis.NewClient(&redis.Options{Addr: server.Addr()})))
+	t.Cleanup(func() {
+		_ = Close()
+		client, handler = oldClient, oldHandler
+	})
+	return server
+}
+
 func TestCache(t *testing.T) {
-	//path := "./config/redis_config.toml"
-	//err := config.InitCacheTomlConfig(path)
-	//if err != nil {
-	//	panic(err)
-	//}
-	//err = Init()
-	//if err != nil {
-	//	panic(err)
-	//}
-	//manager := GetCacheManager()
-	//err = manager.Add(context.TODO(), "test_redis_key.main", "123", 300000000000)
-	//if err != nil {
-	//	panic(err)
-	//}
-	//val := util.StringToPtr("")
-	//err = manager.Get(context.TODO(), "test_redis_key.main", val)
-	//if err != nil {
-	//	panic(err)
-	//}
-	//fmt.Println(val)
+	server := newTestManager(t)
+	manager := GetCacheManager()
+	ctx := context.TODO()
+
+	err := manager.Add(ctx, "test_redis_key", "123", 5*time.Minute)
+	require.NoError(t, err)
+	err = manager.Add(ctx, "test_redis_key", "456", 5*time.Minute)
+	assert.Equal(t, constant.ErrorFailedOperation, err)
+
+	val := new(string)
+	require.NoError(t, manager.Get(ctx, "test_redis_key", val))
+	assert.Equal(t, "123", *val)
+
+	require.NoError(t, manager.Set(ctx, "test_redis_key", "789", time.Minute))
+	require.NoError(t, manager.Get(ctx, "test_redis_key", val))
+	assert.Equal(t, "789", *val)
+
+	server.FastForward(2 * time.Minute)
+	assert.Equal(t, constant.ErrorCacheMiss, manager.Get(ctx, "test_redis_key", val))
+
+	require.NoError(t, manager.Set(ctx, "test_redis_key", "123", time.Minute))
+	require.NoError(t, manager.Delete(ctx, "test_redis_key"))
+	assert.Equal(t, constant.ErrorCacheMiss, manager.Get(ctx, "test_redis_key", val))
+}
+
+func TestCacheTiered(t *testing.T) {
+	server := newTestManager(t)
+	manager := GetCacheManager()
+	ctx := context.TODO()
+
+	require.NoError(t, manager.Set(ctx, "tiered_key", "v1", time.Minute, CallWithStorage(Tiered)))
+	// redis中的值被删除后，L1仍可读到
+	server.Del("tiered_key")
+	val := new(string)
+	require.NoError(t, manager.Get(ctx, "tiered_key", val, CallWithStorage(Tiered)))
+	assert.Equal(t, "v1", *val)
+
+	require.NoError(t, manager.Delete(ctx, "tiered_key", CallWithStorage(Tiered)))
+	assert.Equal(t, constant.ErrorCacheMiss, manager.Get(ctx, "tiered_key", val, CallWithStorage(Tiered)))
+}
+
+func TestCacheBatch(t *testing.T) {
+	newTestManager(t)
+	manager := GetCacheManager()
+	ctx := context.TODO()
+
+	require.NoError(t, manager.MSet(ctx, map[string]interface{}{"a": 1, "b": 2}, time.Minute))
+	result := make(map[string]int)
+	require.NoError(t, manager.MGet(ctx, []string{"a", "b", "c"}, &result))
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, result)
+
+	require.NoError(t, manager.MDelete(ctx, []string{"a"}))
+	result = make(map[string]int)
+	require.NoError(t, manager.MGet(ctx, []string{"a", "b"}, &result))
+	assert.Equal(t, map[string]int{"b": 2}, result)
+}
+
+func TestCacheTags(t *testing.T) {
+	server := newTestManager(t)
+	manager := GetCacheManager()
+	ctx := context.TODO()
+
+	require.NoError(t, manager.Set(ctx, "user:1", "a", time.Minute, CallWithTags("user")))
+	require.NoError(t, manager.Set(ctx, "user:2", "b", time.Minute, CallWithTags("user")))
+	require.NoError(t, manager.Set(ctx, "order:1", "c", time.Minute))
+
+	require.NoError(t, manager.InvalidateTags(ctx, "user"))
+	assert.False(t, server.Exists("user:1"))
+	assert.False(t, server.Exists("user:2"))
+	assert.True(t, server.Exists("order:1"))
+}
+
+func TestCacheBatchTags(t *testing.T) {
+	server := newTestManager(t)
+	manager := GetCacheManager()
+	ctx := context.TODO()
+
+	values := map[string]interface{}{"user:1": "a", "user:2": "b", "user:3": "c"}
+	require.NoError(t, manager.MSet(ctx, values, time.Minute, CallWithTags("user", "vip")))
+	for _, tag := range []string{"user", "vip"} {
+		tagKey := manager.(*cacheManager).getTagKey(tag)
+		members, err := server.Members(tagKey)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"user:1", "user:2", "user:3"}, members)
+		assert.Equal(t, time.Minute, server.TTL(tagKey))
+	}
+
+	require.NoError(t, manager.InvalidateTags(ctx, "vip"))
+	for key := range values {
+		assert.False(t, server.Exists(key))
+	}
 }
@@ -0,0 +1,191 @@
+// Package cache @Author  wangjian    2023/9/26 11:00 AM
+package cache
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	cacheConfig "github.com/JianWangEx/commonService/cache/config"
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultCluster RedisConfig对应的redis连接名称，没有匹配任何KeyPrefixes的key使用该连接
+const DefaultCluster = "default"
+
+// redisCluster 一个命名的redis连接，每个连接有独立的熔断及锁释放通知的订阅
+type redisCluster struct {
+	name   string
+	client redis.UniversalClient
+	// 为nil时表示未开启熔断
+	breaker *circuitBreaker
+	// 等待锁时订阅锁的释放消息，为nil时只能轮询
+	lockNotifier *lockNotifier
+}
+
+// newRedisCluster redisClient为nil时(连接失败)不添加熔断及订阅
+func newRedisCluster(name string, redisClient redis.UniversalClient, breaker *circuitBreaker) *redisCluster {
+	cluster := &redisCluster{
+		name:    name,
+		client:  redisClient,
+		breaker: breaker,
+	}
+	if redisClient == nil {
+		return cluster
+	}
+	setCircuitBreaker(redisClient, breaker)
+	cluster.lockNotifier = newLockNotifier(redisClient)
+	return cluster
+}
+
+// ping 不经过熔断的Ping，熔断打开时也能探测redis是否可用
+func (r *redisCluster) ping(ctx context.Context) error {
+	return r.client.Do(withoutCircuitBreaker(ctx), "ping").Err()
+}
+
+// clusterRoute 去掉namespace后以prefix开头的key路由到cluster
+type clusterRoute struct {
+	prefix  string
+	cluster string
+}
+
+// getClusterConfig
+//
+//	@Description: 校验RedisClusters及LockCluster的配置，返回按前缀长度从长到短排序的路由，
+//	多个连接配置了相同的前缀时返回错误
+//	@return []cacheConfig.RedisClusterConfig
+//	@return []clusterRoute
+//	@return error
+func getClusterConfig() ([]cacheConfig.RedisClusterConfig, []clusterRoute, error) {
+	config := cacheConfig.GetCacheConfig()
+	names := map[string]bool{DefaultCluster: true}
+	prefixes := make(map[string]string)
+	routes := make([]clusterRoute, 0)
+	for _, cc := range config.RedisClusters {
+		if cc.Name == "" || names[cc.Name] {
+			return nil, nil, errors.Wrapf(constant.ErrorInvalidRedisCluster, "cluster=%s", cc.Name)
+		}
+		names[cc.Name] = true
+		for _, prefix := range cc.KeyPrefixes {
+			if other, ok := prefixes[prefix]; ok || prefix == "" {
+				return nil, nil, errors.Wrapf(constant.ErrorInvalidRedisCluster, "duplicated key prefix|cluster=%s, other=%s, prefix=%s", cc.Name, other, prefix)
+			}
+			prefixes[prefix] = cc.Name
+			routes = append(routes, clusterRoute{prefix: prefix, cluster: cc.Name})
+		}
+	}
+	if config.LockCluster != "" && !names[config.LockCluster] {
+		return nil, nil, errors.Wrapf(constant.ErrorUnknownRedisCluster, "lock_cluster=%s", config.LockCluster)
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].prefix) > len(routes[j].prefix)
+	})
+	return config.RedisClusters, routes, nil
+}
+
+// connectCluster eager模式下Ping失败时仍返回client，go-redis会在之后的命令中重新建立连接
+func connectCluster(cc cacheConfig.RedisClusterConfig, mode RedisConnectMode) (redis.UniversalClient, error) {
+	redisClient := newRedisClient(cc.RedisConfig)
+	if mode != RedisConnectEager {
+		return redisClient, nil
+	}
+	if err := redisClient.Ping(context.TODO()).Err(); err != nil {
+		return redisClient, errors.Wrapf(err, "redis cluster ping error|cluster=%s", cc.Name)
+	}
+	return redisClient, nil
+}
+
+// routeCluster key为添加namespace后的key，使用匹配的最长前缀对应的连接
+func (c *cacheManager) routeCluster(key string) string {
+	key = strings.TrimPrefix(key, c.keyPrefix)
+	for _, route := range c.clusterRoutes {
+		if strings.HasPrefix(key, route.prefix) {
+			return route.cluster
+		}
+	}
+	return DefaultCluster
+}
+
+// getCluster name不为空时使用指定的连接，否则按key路由
+func (c *cacheManager) getCluster(name string, key string) (*redisCluster, error) {
+	if name == "" {
+		name = c.routeCluster(key)
+	}
+	cluster, ok := c.clusters[name]
+	if !ok {
+		return nil, errors.Wrapf(constant.ErrorUnknownRedisCluster, "cluster=%s", name)
+	}
+	return cluster, nil
+}
+
+// getLockCluster 锁使用的连接，依次为显式指定的连接、配置的LockCluster、按key路由
+func (c *cacheManager) getLockCluster(name string, key string) (*redisCluster, error) {
+	if name == "" {
+		name = c.lockCluster
+	}
+	return c.getCluster(name, key)
+}
+
+func (c *cacheManager) defaultCluster() *redisCluster {
+	return c.clusters[DefaultCluster]
+}
+
+// getClusters 返回所有连接，default在最前，其余按名称排序
+func (c *cacheManager) getClusters() []*redisCluster {
+	clusters := make([]*redisCluster, 0, len(c.clusters))
+	for _, cluster := range c.clusters {
+		clusters = append(clusters, cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].name == DefaultCluster || clusters[j].name == DefaultCluster {
+			return clusters[i].name == DefaultCluster
+		}
+		return clusters[i].name < clusters[j].name
+	})
+	return clusters
+}
+
+// clusterKeys 批量操作中属于同一个连接的key
+type clusterKeys struct {
+	cluster *redisCluster
+	keys    []string
+}
+
+// groupByCluster 按连接对key分组，组的顺序为每个连接第一次出现的顺序，组内保持key的顺序
+func (c *cacheManager) groupByCluster(name string, keys []string) ([]*clusterKeys, error) {
+	groups := make([]*clusterKeys, 0, 1)
+	index := make(map[*redisCluster]*clusterKeys)
+	for _, key := range keys {
+		cluster, err := c.getCluster(name, key)
+		if err != nil {
+			return nil, err
+		}
+		group, ok := index[cluster]
+		if !ok {
+			group = &clusterKeys{cluster: cluster}
+			index[cluster] = group
+			groups = append(groups, group)
+		}
+		group.keys = append(group.keys, key)
+	}
+	return groups, nil
+}
+
+// GetRedisClusterClient
+//
+//	@Description: 返回指定名称的redis连接，name为DefaultCluster时与GetRedisClient相同，
+//	未Init或未配置该连接时返回nil
+//	@param name
+//	@return redis.UniversalClient
+func GetRedisClusterClient(name string) redis.UniversalClient {
+	if client == nil {
+		return nil
+	}
+	cluster, ok := client.clusters[name]
+	if !ok {
+		return nil
+	}
+	return cluster.client
+}
@@ -0,0 +1,176 @@
+// Package cache @Author  wangjian    2023/9/27 10:20 AM
+package cache
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	cacheConfig "github.com/JianWangEx/commonService/cache/config"
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setClusterConfig(t *testing.T, c cacheConfig.ClusterConfig) {
+	config := cacheConfig.GetCacheConfig()
+	old := config.ClusterConfig
+	config.ClusterConfig = c
+	t.Cleanup(func() {
+		config.ClusterConfig = old
+	})
+}
+
+// newTestClusters default及session两个连接，以"session:"开头的key路由到session
+func newTestClusters(t *testing.T, lockCluster string) (*miniredis.Miniredis, *miniredis.Miniredis) {
+	session := miniredis.RunT(t)
+	setClusterConfig(t, cacheConfig.ClusterConfig{
+		RedisClusters: []cacheConfig.RedisClusterConfig{
+			{Name: "session", KeyPrefixes: []string{"session:"}, RedisConfig: cacheConfig.RedisConfig{Addrs: []string{session.Addr()}}},
+		},
+		LockCluster: lockCluster,
+	})
+	return newTestManager(t), session
+}
+
+func TestGetClusterConfig(t *testing.T) {
+	setClusterConfig(t, cacheConfig.ClusterConfig{
+		RedisClusters: []cacheConfig.RedisClusterConfig{
+			{Name: "a", KeyPrefixes: []string{"user:"}},
+			{Name: "b", KeyPrefixes: []string{"user:vip:"}},
+		},
+	})
+	_, routes, err := getClusterConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []clusterRoute{{prefix: "user:vip:", cluster: "b"}, {prefix: "user:", cluster: "a"}}, routes)
+
+	c := &cacheManager{keyPrefix: "svc:", clusterRoutes: routes}
+	assert.Equal(t, "b", c.routeCluster("svc:user:vip:1"))
+	assert.Equal(t, "a", c.routeCluster("svc:user:1"))
+	assert.Equal(t, DefaultCluster, c.routeCluster("svc:order:1"))
+
+	for _, clusters := range [][]cacheConfig.RedisClusterConfig{
+		{{Name: ""}},
+		{{Name: DefaultCluster}},
+		{{Name: "a"}, {Name: "a"}},
+		{{Name: "a", KeyPrefixes: []string{"user:"}}, {Name: "b", KeyPrefixes: []string{"user:"}}},
+	} {
+		setClusterConfig(t, cacheConfig.ClusterConfig{RedisClusters: clusters})
+		_, _, err := getClusterConfig()
+		assert.True(t, errors.Is(err, constant.ErrorInvalidRedisCluster), "%+v", clusters)
+	}
+
+	setClusterConfig(t, cacheConfig.ClusterConfig{LockCluster: "lock"})
+	_, _, err = getClusterConfig()
+	assert.True(t, errors.Is(err, constant.ErrorUnknownRedisCluster))
+}
+
+func TestCacheClusterRouting(t *testing.T) {
+	main, session := newTestClusters(t, "")
+	manager := GetCacheManager()
+	ctx := context.TODO()
+
+	require.NoError(t, manager.Set(ctx, "session:1", "s", time.Minute))
+	require.NoError(t, manager.Set(ctx, "user:1", "u", time.Minute))
+	assert.True(t, session.Exists("session:1"))
+	assert.False(t, main.Exists("session:1"))
+	assert.True(t, main.Exists("user:1"))
+	assert.False(t, session.Exists("user:1"))
+
+	// 显式指定的连接优先于前缀路由
+	require.NoError(t, manager.Set(ctx, "user:2", "u", time.Minute, CallWithCluster("session")))
+	assert.True(t, session.Exists("user:2"))
+	val := new(string)
+	require.NoError(t, manager.Get(ctx, "user:2", val, CallWithCluster("session")))
+	assert.Equal(t, "u", *val)
+	assert.Equal(t, constant.ErrorCacheMiss, manager.Get(ctx, "user:2", val))
+
+	err := manager.Get(ctx, "user:1", val, CallWithCluster("unknown"))
+	assert.True(t, errors.Is(err, constant.ErrorUnknownRedisCluster))
+
+	assert.NotNil(t, GetRedisClusterClient("session"))
+	assert.Equal(t, GetRedisClient(), GetRedisClusterClient(DefaultCluster))
+	assert.Nil(t, GetRedisClusterClient("unknown"))
+}
+
+func TestCacheClusterBatch(t *testing.T) {
+	main, session := newTestClusters(t, "")
+	manager := GetCacheManager()
+	ctx := context.TODO()
+
+	require.NoError(t, manager.MSet(ctx, map[string]interface{}{"session:1": "s1", "user:1": "u1"}, time.Minute, CallWithTags("t")))
+	assert.True(t, session.Exists("session:1"))
+	assert.True(t, main.Exists("user:1"))
+
+	receiver := make(map[string]string)
+	require.NoError(t, manager.MGet(ctx, []string{"session:1", "user:1", "user:2"}, &receiver))
+	assert.Equal(t, map[string]string{"session:1": "s1", "user:1": "u1"}, receiver)
+
+	// tag集合与key在同一个连接中，InvalidateTags删除所有连接中的key
+	require.NoError(t, manager.InvalidateTags(ctx, "t"))
+	assert.False(t, session.Exists("session:1"))
+	assert.False(t, main.Exists("user:1"))
+
+	require.NoError(t, manager.MSet(ctx, map[string]interface{}{"session:1": "s1", "user:1": "u1"}, time.Minute))
+	require.NoError(t, manager.MDelete(ctx, []string{"session:1", "user:1"}))
+	assert.False(t, session.Exists("session:1"))
+	assert.False(t, main.Exists("user:1"))
+}
+
+func TestCacheClusterLock(t *testing.T) {
+	main, session := newTestClusters(t, "session")
+	ctx := context.TODO()
+
+	var lockInMain, lockInSession bool
+	_, err := AddCacheLockHandler(ctx, func(ctx context.Context) (interface{}, error) {
+		lockInMain, lockInSession = main.Exists("order:1"), session.Exists("order:1")
+		return nil, nil
+	}, NewAddCacheLockParam("order:1"))
+	require.NoError(t, err)
+	assert.False(t, lockInMain)
+	assert.True(t, lockInSession)
+
+	_, err = SemaphoreHandler(ctx, func(ctx context.Context) (interface{}, error) {
+		lockInMain, lockInSession = main.Exists("order:2"), session.Exists("order:2")
+		return nil, nil
+	}, NewSemaphoreParam("order:2", 1, SemaphoreWithCluster(DefaultCluster)))
+	require.NoError(t, err)
+	assert.True(t, lockInMain)
+	assert.False(t, lockInSession)
+
+	_, err = WriteLockHandler(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, nil
+	}, NewRWLockParam("order:3", RWLockWithCluster("unknown")))
+	assert.True(t, errors.Is(err, constant.ErrorUnknownRedisCluster))
+}
+
+func TestConnectClusterOptions(t *testing.T) {
+	server := miniredis.RunT(t)
+	cc := cacheConfig.RedisClusterConfig{Name: "session", RedisConfig: cacheConfig.RedisConfig{
+		Addrs:           []string{server.Addr()},
+		Protocol:        2,
+		MinRetryBackoff: time.Millisecond,
+		MaxRetryBackoff: time.Second,
+		PoolTimeout:     2 * time.Second,
+		ConnMaxIdleTime: time.Minute,
+		ConnMaxLifetime: time.Hour,
+		TLSConfig:       &tls.Config{ServerName: "session"},
+	}}
+	// 配置了TLS，miniredis不支持，lazy模式下不Ping
+	redisClient, err := connectCluster(cc, RedisConnectLazy)
+	require.NoError(t, err)
+	defer redisClient.Close()
+	// RedisClusters中的连接与RedisConfig使用相同的配置项
+	opt := redisClient.(*redis.Client).Options()
+	assert.Equal(t, 2, opt.Protocol)
+	assert.Equal(t, time.Millisecond, opt.MinRetryBackoff)
+	assert.Equal(t, time.Second, opt.MaxRetryBackoff)
+	assert.Equal(t, 2*time.Second, opt.PoolTimeout)
+	assert.Equal(t, time.Minute, opt.ConnMaxIdleTime)
+	assert.Equal(t, time.Hour, opt.ConnMaxLifetime)
+	assert.Equal(t, "session", opt.TLSConfig.ServerName)
+}
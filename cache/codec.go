@@ -0,0 +1,170 @@
+// Package cache @Author  wangjian    2023/8/20 3:12 PM
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// CodecType 编码类型，会被写入缓存值的header中，因此已定义的值不可修改
+type CodecType uint8
+
+const (
+	CodecJSON     CodecType = 1
+	CodecMsgpack  CodecType = 2
+	CodecGob      CodecType = 3
+	CodecProtobuf CodecType = 4
+)
+
+func (t CodecType) String() string {
+	switch t {
+	case CodecJSON:
+		return "json"
+	case CodecMsgpack:
+		return "msgpack"
+	case CodecGob:
+		return "gob"
+	case CodecProtobuf:
+		return "protobuf"
+	}
+	return "unknown"
+}
+
+// Codec
+//
+//	@Description: Main storage(redis)中缓存值的编解码器
+type Codec interface {
+	Type() CodecType
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal receiver should be ptr and not nil
+	Unmarshal(data []byte, receiver interface{}) error
+}
+
+var (
+	codecs   = map[CodecType]Codec{}
+	codecsMu sync.RWMutex
+)
+
+func init() {
+	RegisterCodec(JSONCodec{})
+	RegisterCodec(MsgpackCodec{})
+	RegisterCodec(GobCodec{})
+	RegisterCodec(ProtobufCodec{})
+}
+
+// RegisterCodec
+//
+//	@Description: 注册编解码器，读取缓存时会根据header中的CodecType找到对应的Codec，
+//	同一个CodecType重复注册时后者覆盖前者
+//	@param c
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Type()] = c
+}
+
+// GetCodec 根据CodecType获取已注册的编解码器
+func GetCodec(t CodecType) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[t]
+	if !ok {
+		return nil, errors.Wrapf(constant.ErrorUnknownCodec, "codec_type=%d", t)
+	}
+	return c, nil
+}
+
+// GetCodecByName 根据名称获取已注册的编解码器，名称不区分大小写，空字符串返回JSONCodec
+func GetCodecByName(name string) (Codec, error) {
+	if name == "" {
+		return JSONCodec{}, nil
+	}
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	for t, c := range codecs {
+		if strings.EqualFold(t.String(), name) {
+			return c, nil
+		}
+	}
+	return nil, errors.Wrapf(constant.ErrorUnknownCodec, "codec_name=%s", name)
+}
+
+// JSONCodec 使用encoding/json，与历史缓存值的格式相同
+type JSONCodec struct{}
+
+func (JSONCodec) Type() CodecType {
+	return CodecJSON
+}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, receiver interface{}) error {
+	return json.Unmarshal(data, receiver)
+}
+
+// MsgpackCodec 体积更小，并且能够保留time.Time等类型的精度
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Type() CodecType {
+	return CodecMsgpack
+}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, receiver interface{}) error {
+	return msgpack.Unmarshal(data, receiver)
+}
+
+// GobCodec 值中如果包含interface类型的字段，需要提前使用gob.Register注册具体类型
+type GobCodec struct{}
+
+func (GobCodec) Type() CodecType {
+	return CodecGob
+}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, receiver interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(receiver)
+}
+
+// ProtobufCodec value和receiver都必须实现proto.Message
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Type() CodecType {
+	return CodecProtobuf
+}
+
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, errors.WithStack(constant.ErrorNotProtoMessage)
+	}
+	return proto.Marshal(m)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, receiver interface{}) error {
+	m, ok := receiver.(proto.Message)
+	if !ok {
+		return errors.WithStack(constant.ErrorNotProtoMessage)
+	}
+	return proto.Unmarshal(data, m)
+}
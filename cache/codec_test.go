@@ -0,0 +1,132 @@
+// Package cache @Author  wangjian    2023/8/20 6:02 PM
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecTestValue struct {
+	Name      string
+	Count     int
+	CreatedAt time.Time
+}
+
+func TestEncodeDecodeValue(t *testing.T) {
+	value := codecTestValue{Name: strings.Repeat("cat", 1000), Count: 3, CreatedAt: time.Now()}
+	for _, codec := range []Codec{JSONCodec{}, MsgpackCodec{}, GobCodec{}} {
+		for _, compressType := range []CompressType{CompressNone, CompressSnappy, CompressZstd} {
+			opt := &callOption{codec: codec, compressType: compressType, compressThreshold: 16, writeValueHeader: true}
+			data, err := encodeValue(value, opt, valueCipher{})
+			require.NoError(t, err)
+
+			h, _, ok, err := parseValueHeader(data)
+			require.NoError(t, err)
+			require.True(t, ok)
+			assert.Equal(t, codec.Type(), h.codec)
+			assert.Equal(t, compressType, h.compress)
+
+			receiver := new(codecTestValue)
+			require.NoError(t, decodeValue(data, receiver, nil, valueCipher{}))
+			assert.Equal(t, value.Name, receiver.Name)
+			assert.Equal(t, value.Count, receiver.Count)
+			assert.True(t, value.CreatedAt.Equal(receiver.CreatedAt), "codec=%s", codec.Type())
+		}
+	}
+}
+
+func TestEncodeValueBelowThreshold(t *testing.T) {
+	opt := &callOption{codec: JSONCodec{}, compressType: CompressZstd, compressThreshold: 1024}
+	data, err := encodeValue("short", opt, valueCipher{})
+	require.NoError(t, err)
+	h, _, _, err := parseValueHeader(data)
+	require.NoError(t, err)
+	assert.Equal(t, CompressNone, h.compress)
+}
+
+func TestDecodeLegacyJSONValue(t *testing.T) {
+	receiver := new(map[string]string)
+	require.NoError(t, decodeValue([]byte(`{"name":"cat"}`), receiver, nil, valueCipher{}))
+	assert.Equal(t, "cat", (*receiver)["name"])
+
+	assert.Equal(t, constant.ErrorCacheMiss, decodeValue([]byte("null"), receiver, nil, valueCipher{}))
+}
+
+func TestEncodeLegacyJSONValue(t *testing.T) {
+	// 未开启WriteValueHeader时，json、未压缩且没有元数据的值以历史版本的格式写入
+	opt := &callOption{codec: JSONCodec{}, compressType: CompressZstd, compressThreshold: 1024}
+	data, err := encodeValue(map[string]string{"name": "cat"}, opt, valueCipher{})
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"cat"}`, string(data))
+
+	// 压缩、带元数据及空值仍需要header
+	opt.compressThreshold = 1
+	data, err = encodeValue(strings.Repeat("cat", 100), opt, valueCipher{})
+	require.NoError(t, err)
+	h, _, ok, err := parseValueHeader(data)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, CompressZstd, h.compress)
+
+	opt = &callOption{codec: JSONCodec{}, meta: &valueMeta{softExpireAt: 1}}
+	data, err = encodeValue("cat", opt, valueCipher{})
+	require.NoError(t, err)
+	_, _, ok, err = parseValueHeader(data)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	receiver := new(string)
+	require.NoError(t, decodeValue(data, receiver, nil, valueCipher{}))
+	assert.Equal(t, "cat", *receiver)
+
+	opt = &callOption{codec: JSONCodec{}, tombstone: true}
+	data, err = encodeValue(nil, opt, valueCipher{})
+	require.NoError(t, err)
+	assert.Equal(t, constant.ErrorNegativeCacheHit, decodeValue(data, receiver, nil, valueCipher{}))
+
+	opt = &callOption{codec: JSONCodec{}, writeValueHeader: true}
+	data, err = encodeValue("cat", opt, valueCipher{})
+	require.NoError(t, err)
+	_, _, ok, err = parseValueHeader(data)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestProtobufCodec(t *testing.T) {
+	opt := &callOption{codec: ProtobufCodec{}}
+	data, err := encodeValue(wrapperspb.String("cat"), opt, valueCipher{})
+	require.NoError(t, err)
+
+	receiver := new(wrapperspb.StringValue)
+	require.NoError(t, decodeValue(data, receiver, nil, valueCipher{}))
+	assert.Equal(t, "cat", receiver.GetValue())
+
+	_, err = encodeValue("cat", opt, valueCipher{})
+	assert.ErrorIs(t, err, constant.ErrorNotProtoMessage)
+}
+
+func TestGetCodecByName(t *testing.T) {
+	codec, err := GetCodecByName("MsgPack")
+	require.NoError(t, err)
+	assert.Equal(t, CodecMsgpack, codec.Type())
+
+	_, err = GetCodecByName("xml")
+	assert.ErrorIs(t, err, constant.ErrorUnknownCodec)
+}
+
+func TestEncodeDecodeValueMeta(t *testing.T) {
+	meta := &valueMeta{softExpireAt: 1, hardExpireAt: 2, delta: 3}
+	data, err := encodeValue("cat", &callOption{codec: MsgpackCodec{}, meta: meta}, valueCipher{})
+	require.NoError(t, err)
+
+	receiver := new(string)
+	got := new(valueMeta)
+	require.NoError(t, decodeValue(data, receiver, got, valueCipher{}))
+	assert.Equal(t, "cat", *receiver)
+	assert.Equal(t, *meta, *got)
+}
@@ -0,0 +1,95 @@
+// Package cache @Author  wangjian    2023/8/20 4:05 PM
+package cache
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// CompressType 压缩类型，会被写入缓存值的header中，因此已定义的值不可修改
+type CompressType uint8
+
+const (
+	CompressNone   CompressType = 0
+	CompressSnappy CompressType = 1
+	CompressZstd   CompressType = 2
+)
+
+func (t CompressType) String() string {
+	switch t {
+	case CompressNone:
+		return "none"
+	case CompressSnappy:
+		return "snappy"
+	case CompressZstd:
+		return "zstd"
+	}
+	return "unknown"
+}
+
+// ParseCompressType 根据名称获取压缩类型，名称不区分大小写，空字符串返回CompressNone
+func ParseCompressType(name string) (CompressType, error) {
+	for _, t := range []CompressType{CompressNone, CompressSnappy, CompressZstd} {
+		if strings.EqualFold(t.String(), name) {
+			return t, nil
+		}
+	}
+	if name == "" {
+		return CompressNone, nil
+	}
+	return CompressNone, errors.Wrapf(constant.ErrorUnknownCompressType, "compress_name=%s", name)
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdInitErr error
+)
+
+// initZstd encoder和decoder的EncodeAll/DecodeAll是并发安全的，全局只创建一次
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdInitErr = zstd.NewWriter(nil)
+		if zstdInitErr != nil {
+			return
+		}
+		zstdDecoder, zstdInitErr = zstd.NewReader(nil)
+	})
+	return zstdInitErr
+}
+
+func compress(t CompressType, data []byte) ([]byte, error) {
+	switch t {
+	case CompressNone:
+		return data, nil
+	case CompressSnappy:
+		return snappy.Encode(nil, data), nil
+	case CompressZstd:
+		if err := initZstd(); err != nil {
+			return nil, errors.Wrap(err, "zstd init error")
+		}
+		return zstdEncoder.EncodeAll(data, nil), nil
+	}
+	return nil, errors.Wrapf(constant.ErrorUnknownCompressType, "compress_type=%d", t)
+}
+
+func decompress(t CompressType, data []byte) ([]byte, error) {
+	switch t {
+	case CompressNone:
+		return data, nil
+	case CompressSnappy:
+		return snappy.Decode(nil, data)
+	case CompressZstd:
+		if err := initZstd(); err != nil {
+			return nil, errors.Wrap(err, "zstd init error")
+		}
+		return zstdDecoder.DecodeAll(data, nil)
+	}
+	return nil, errors.Wrapf(constant.ErrorUnknownCompressType, "compress_type=%d", t)
+}
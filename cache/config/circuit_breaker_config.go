@@ -0,0 +1,27 @@
+// Package config @Author  wangjian    2023/9/22 10:15 AM
+package config
+
+type CircuitBreakerConfig struct {
+	// Init连接redis的方式
+	// eager: Init时Ping redis，失败时返回错误，默认
+	// lazy: Init时不Ping，第一次使用时再建立连接
+	// background: Init时不Ping，在后台重试连接直到成功，连接成功前熔断保持打开(需要开启熔断)
+	RedisConnectMode string
+
+	// 为true时在redis client上开启熔断，连续失败达到阈值后直接返回错误，不再等待redis超时
+	// 默认为false
+	CircuitBreakerEnabled bool
+
+	// 连续失败多少次后打开熔断，redis返回的错误(如WRONGTYPE)及key不存在不算失败
+	// 默认为5
+	CircuitBreakerFailureThreshold int
+
+	// 熔断打开后经过该时间进入半开状态，放行一个请求探测redis是否恢复
+	// 默认为5000
+	CircuitBreakerOpenTimeout int // time.Millisecond
+
+	// 为true时熔断打开期间Main storage的读写改为使用local cache，写入的过期时间不超过TieredExpiration，
+	// Tiered storage只使用L1
+	// 默认为false，熔断打开时返回ErrorCircuitOpen
+	CircuitBreakerDegradeToLocal bool
+}
@@ -0,0 +1,26 @@
+// Package config @Author  wangjian    2023/9/26 10:30 AM
+package config
+
+type ClusterConfig struct {
+	// 除RedisConfig(名为default)之外的其他redis连接，key按KeyPrefixes路由到对应的连接，
+	// 也可以通过CallWithCluster、WithCluster显式指定
+	// 默认为空，所有key使用RedisConfig
+	RedisClusters []RedisClusterConfig
+
+	// 锁默认使用的连接名称，未设置时锁与缓存一样按key路由，
+	// 可通过CacheLockWithCluster、SemaphoreWithCluster、RWLockWithCluster覆盖
+	// 默认为空
+	LockCluster string
+}
+
+// RedisClusterConfig 一个命名的redis连接，连接配置与RedisConfig相同，未设置的配置使用go-redis的默认值
+type RedisClusterConfig struct {
+	// 连接名称，不能为空、重复或为default
+	Name string
+
+	// 去掉Namespace及Version后以这些前缀开头的key路由到该连接，多个连接都匹配时使用最长的前缀
+	// 例如：[]string{"session:"}
+	KeyPrefixes []string
+
+	RedisConfig
+}
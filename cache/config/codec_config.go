@@ -0,0 +1,22 @@
+// Package config @Author  wangjian    2023/8/20 5:10 PM
+package config
+
+type CodecConfig struct {
+	// Main storage(redis)默认使用的编解码器: json, msgpack, gob, protobuf
+	// 默认为json
+	Codec string
+
+	// Main storage(redis)默认使用的压缩算法: none, snappy, zstd
+	// 默认为none，不压缩
+	Compression string
+
+	// 编码后的值达到该长度(字节)时才进行压缩
+	// 默认为1024
+	CompressThreshold int
+
+	// 为false时，codec为json、未压缩、没有元数据且未加密的值仍以历史版本的json格式写入，不带header，
+	// 未升级的实例可以继续读取；为true时所有值都带header写入，未升级的实例无法读取。
+	// 升级顺序：先将所有读取同一个redis的实例升级到当前版本(可以读取两种格式)，再开启该配置。
+	// 默认为false，下一个版本将改为默认开启
+	WriteValueHeader bool
+}
@@ -0,0 +1,21 @@
+// Package config @Author  wangjian    2023/10/16 10:20 AM
+package config
+
+type EncryptionConfig struct {
+	// 加密密钥的来源: env, file 或通过cache.RegisterKeyProvider注册的名称
+	// 默认为空，不能加密
+	EncryptionKeyProvider string
+
+	// env时为环境变量名称，file时为文件路径，内容为逗号或换行分隔的"id:base64(key)"，
+	// key长度为16、24或32字节，分别对应AES-128、AES-192、AES-256
+	EncryptionKeySource string
+
+	// 加密新值使用的密钥ID，轮换密钥时在EncryptionKeySource中添加新密钥并修改该配置，
+	// 旧密钥需要保留到使用它加密的值全部过期
+	// 默认为EncryptionKeySource中的第一个，注册的provider忽略该配置
+	EncryptionKeyID string
+
+	// 写入Main storage时需要加密的key前缀(不含namespace)，其他key可以通过CallWithEncryption加密
+	// 读取时拒绝这些key中未加密的值，对已有明文数据的前缀开启加密时，旧值在重新写入前无法读取
+	EncryptionKeyPrefixes []string
+}
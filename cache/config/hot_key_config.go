@@ -0,0 +1,26 @@
+// Package config @Author  wangjian    2023/10/9 10:20 AM
+package config
+
+type HotKeyConfig struct {
+	// 为true时统计Main storage中key的读取频率，热点key自动提升到local cache，
+	// 热点key的写入及删除会通过InvalidationChannel通知所有实例删除local cache中的副本，
+	// 未配置InvalidationChannel时其他实例最多读到HotKeyLocalExpiration之前的值
+	// 默认为false
+	HotKeyEnabled bool
+
+	// 统计读取次数的滑动窗口
+	// 默认为10
+	HotKeyWindow int // time.Second
+
+	// 窗口内读取次数达到该值的key为热点key
+	// 默认为1000
+	HotKeyThreshold int
+
+	// 热点key在local cache中的过期时间，过期后重新从redis读取
+	// 默认为1000
+	HotKeyLocalExpiration int // time.Millisecond
+
+	// 同时存在的热点key数量上限，达到后不再提升新的key
+	// 默认为100
+	HotKeyMaxKeys int
+}
@@ -15,6 +15,16 @@ var (
 type CacheConfig struct {
 	RedisConfig
 	LocalCacheConfig
+	CodecConfig
+	KeyConfig
+	RedlockConfig
+	MetricsConfig
+	CircuitBreakerConfig
+	ClusterConfig
+	HotKeyConfig
+	WarmUpConfig
+	TTLConfig
+	EncryptionConfig
 }
 
 func InitCacheTomlConfig(path string) (err error) {
@@ -0,0 +1,21 @@
+// Package config @Author  wangjian    2023/9/4 10:05 AM
+package config
+
+type KeyConfig struct {
+	// 服务命名空间，作为所有key的前缀，多个服务共用同一个redis时用于隔离，
+	// FlushCache只删除该命名空间下的key，未设置时FlushCache返回错误
+	// 默认为空，不添加前缀
+	Namespace string
+
+	// 缓存版本，作为Namespace之后的前缀，修改后所有旧版本的key不再被读取
+	// 默认为空
+	Version string
+
+	// 未显式指定storage(CallWithStorage, WithCacheStore)时使用的storage: main, local, tiered
+	// 默认为main
+	DefaultStorage string
+
+	// 为true时不再根据key的后缀".local"选择storage
+	// 默认为false，兼容历史使用后缀的key
+	DisableStorageSuffix bool
+}
@@ -4,4 +4,21 @@ package config
 type LocalCacheConfig struct {
 	DefaultExpiration int // time.Minute
 	CleanupInterval   int // time.Minute
+
+	// Tiered storage中L1(local)的过期时间，应短于redis中的过期时间
+	// 默认为10秒
+	TieredExpiration int // time.Second
+
+	// 用于在实例间广播local cache失效消息的redis channel，
+	// 设置后每个实例在Init时订阅该channel，对Local/Tiered storage的写入和删除会让其他实例删除对应的local key
+	// 默认为空，不开启
+	InvalidationChannel string
+
+	// local cache的淘汰策略，可选lru、tinylfu
+	// 为空且未设置LocalMaxItems和LocalMaxBytes时不限制大小，只设置了容量时使用lru
+	LocalStoreType string
+	// 最多保存的key数量，0表示不限制，tinylfu需要大于0
+	LocalMaxItems int
+	// 最多占用的字节数，按值编码后的大小估算，0表示不限制
+	LocalMaxBytes int64
 }
@@ -0,0 +1,12 @@
+// Package config @Author  wangjian    2023/9/18 4:30 PM
+package config
+
+type MetricsConfig struct {
+	// 缓存读写及加锁耗时超过该值时打印warning日志
+	// 默认为1000
+	SlowOpThreshold int // time.Millisecond
+
+	// 为true时不记录缓存指标
+	// 默认为false
+	DisableMetrics bool
+}
@@ -0,0 +1,22 @@
+// Package config @Author  wangjian    2023/9/11 10:10 AM
+package config
+
+type RedlockConfig struct {
+	// Redlock使用的相互独立的redis节点(不能是同一个集群或主从中的节点)，建议为奇数个
+	// 例如：[]string{"192.168.1.11:6379", "192.168.1.12:6379", "192.168.1.13:6379"}
+	// 默认为空，此时不能使用CacheLockWithRedlock
+	RedlockAddrs []string
+
+	// 所有节点使用相同的用户名、密码和DB
+	RedlockUsername string
+	RedlockPassword string
+	RedlockDB       int
+
+	// 单个节点操作的超时时间，需要远小于锁的timeout，避免在不可用的节点上等待过久
+	// 默认为50毫秒
+	RedlockNodeTimeout int // time.Millisecond
+
+	// 时钟漂移系数，锁的有效时间为 timeout - 获取锁的耗时 - timeout*RedlockClockDriftFactor - 2ms
+	// 默认为0.01
+	RedlockClockDriftFactor float64
+}
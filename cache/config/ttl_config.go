@@ -0,0 +1,30 @@
+// Package config @Author  wangjian    2023/10/13 10:40 AM
+package config
+
+type TTLConfig struct {
+	// expired为cache.DefaultTTL(包括未设置WithTimeout的AddCacheHandle)且没有匹配的TTLRules时使用的过期时间
+	// 默认为30
+	DefaultCacheTTL int // time.Second
+
+	// 使用DefaultCacheTTL时过期时间随机浮动的百分比，取值0-100
+	// 默认为0，不浮动
+	DefaultCacheTTLJitter int
+
+	// 按key前缀(不含namespace)配置的默认过期时间，多个前缀匹配时使用最长的前缀
+	TTLRules []TTLRule
+}
+
+type TTLRule struct {
+	KeyPrefix string
+
+	// expired为cache.DefaultTTL时使用的过期时间，必须大于0
+	Expiration int // time.Second
+
+	// 过期时间在[Expiration*(100-JitterPercent)/100, Expiration*(100+JitterPercent)/100]内随机，
+	// 避免同时写入的key同时过期，取值0-100
+	JitterPercent int
+
+	// 为true时每次从redis读取命中后将过期时间重置为Expiration(同样随机浮动)，
+	// 只对Main及Tiered storage的Get生效，MGet及local cache中的值不刷新
+	SlidingExpiration bool
+}
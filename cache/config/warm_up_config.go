@@ -0,0 +1,17 @@
+// Package config @Author  wangjian    2023/10/12 10:30 AM
+package config
+
+type WarmUpConfig struct {
+	// 为true时Init成功后在后台执行所有通过RegisterWarmer注册的预热
+	// redis连接失败时不执行，RedisConnectMode为background时在所有redis连接成功后执行
+	// 默认为false，只能通过WarmUp手动执行
+	WarmUpOnInit bool
+
+	// 同时执行的预热数量
+	// 默认为4
+	WarmUpConcurrency int
+
+	// Init时后台预热的整体超时时间，同时也是WaitReady在ctx未设置deadline时的等待时间
+	// 默认为60
+	WarmUpTimeout int // time.Second
+}
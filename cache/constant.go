@@ -11,6 +11,8 @@ type Storage string
 const (
 	Main  Storage = "main"
 	Local Storage = "local"
+	// Tiered 两级缓存，先读local(L1)再读redis(L2)，写入和删除同时作用于两级
+	Tiered Storage = "tiered"
 )
 
 func (s Storage) name() string {
@@ -23,18 +25,94 @@ const (
 	cacheBust                 = false
 
 	maxLogValueLength = 1000
+
+	defaultCompressThreshold = 1024 // unit byte
+
+	defaultTieredLocalTimeout = 10 * time.Second
+
+	// 估算local store每个key占用的字节数时，在key及编码后的值之外固定增加的开销
+	localStoreEntryOverhead = 64
+
+	tagKeyPrefix = "cache_tag:"
+
+	// namespace、version与key之间的分隔符
+	keySeparator = ":"
+	// FlushCache每次SCAN的数量
+	flushScanCount = 1000
+
+	// local cache失效消息订阅断开后的重试间隔，指数退避，最大为 1<<5 * 100ms
+	invalidationRetryBaseInterval = 100 * time.Millisecond
+	invalidationMaxRetryShift     = 5
+
+	defaultHotKeyWindow       = 10 * time.Second
+	defaultHotKeyThreshold    = 1000
+	defaultHotKeyLocalTimeout = time.Second
+	defaultHotKeyMaxKeys      = 100
+	// 滑动窗口划分的桶数，每经过window/hotKeyWindowBuckets丢弃最旧的一个桶
+	hotKeyWindowBuckets = 10
+	// 每个桶中count-min sketch的行数及每行的计数数量，计数平均分布在各分片中
+	hotKeySketchDepth = 4
+	hotKeySketchWidth = 2048
+	// 按key的hash分片统计，每个分片使用独立的锁，避免所有读取竞争同一把锁
+	hotKeyShards           = 16
+	hotKeyShardSketchWidth = hotKeySketchWidth / hotKeyShards
+
+	defaultWarmUpConcurrency = 4
+	defaultWarmUpTimeout     = 60 * time.Second
+
+	defaultCircuitBreakerFailureThreshold = 5
+	defaultCircuitBreakerOpenTimeout      = 5 * time.Second
+	// go-redis等待连接池中的连接超时时返回的错误信息，该错误未导出
+	redisPoolTimeoutMsg = "redis: connection pool timeout"
+	// 后台连接redis的重试间隔，指数退避，最大为 1<<6 * 500ms
+	redisConnectRetryBaseInterval = 500 * time.Millisecond
+	redisConnectMaxRetryShift     = 6
+	redisConnectPingTimeout       = 3 * time.Second
 )
 
 const (
 	defaultCacheLockTimeout = 300 * time.Second
 	defaultLockingTimeout   = 0
 
-	warningThreshold = 1000 // unit milliseconds
+	// 缓存读写及加锁的默认慢操作阈值，可通过配置SlowOpThreshold修改
+	defaultSlowOpThreshold = time.Second
 
 	// 锁轮训间隔划分
 	lockPollingIntervalDiv = 100
 
 	addCacheLockCtxKey = "addCacheLockCtxKey"
+
+	// watchdog每隔timeout/lockWatchdogIntervalDiv续期一次
+	lockWatchdogIntervalDiv = 3
+	// watchdog续期的最小间隔，timeout过小时避免间隔为0
+	lockWatchdogMinInterval = time.Millisecond
+
+	defaultRedlockNodeTimeout      = 50 * time.Millisecond
+	defaultRedlockClockDriftFactor = 0.01
+	// 时钟漂移中固定增加的部分，见redis官方Redlock实现
+	redlockClockDriftConstant = 2 * time.Millisecond
+
+	// 锁的fencing计数器key后缀，计数器不过期以保证token单调递增
+	fencingKeySuffix = ":fencing"
+	// 公平锁的等待队列及等待者过期时间的key后缀
+	lockQueueKeySuffix        = ":queue"
+	lockQueueTimeoutKeySuffix = ":queue_timeout"
+	// 读写锁的读者集合、写锁及等待中的写者的key后缀
+	rwLockReadersKeySuffix       = ":readers"
+	rwLockWriterKeySuffix        = ":writer"
+	rwLockWriterWaitingKeySuffix = ":writer_waiting"
+	// 锁释放时发布消息的channel后缀
+	lockReleasedChannelSuffix = ":released"
+
+	// 等待锁时轮询的最大间隔，锁释放的通知丢失时最多等待该时间后重试
+	lockPollingMaxInterval = time.Second
+	// 公平锁等待者在队列中的有效时间，等待者每次重试时刷新，需要大于lockPollingMaxInterval，
+	// 超过该时间未刷新的等待者(已放弃或已退出)会被移出队列
+	lockFairWaiterTimeout = 5 * time.Second
+
+	// 分布式合并回源时使用的锁key后缀及等待缓存结果的轮询间隔
+	singleFlightLockKeySuffix = ":single_flight_lock"
+	singleFlightPollInterval  = 50 * time.Millisecond
 )
 
 var defaultCacheValueFunc = func() string {
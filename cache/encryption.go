@@ -0,0 +1,311 @@
+// Package cache @Author  wangjian    2023/10/16 11:00 AM
+package cache
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"strings"
+	"sync"
+
+	cacheConfig "github.com/JianWangEx/commonService/cache/config"
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/pkg/errors"
+)
+
+const (
+	KeyProviderEnv  = "env"
+	KeyProviderFile = "file"
+
+	// 密钥ID在header中使用1个字节记录长度
+	maxEncryptionKeyIDLen = 255
+)
+
+// KeyProvider 提供加密Main storage中缓存值的AES密钥，实现需要并发安全
+type KeyProvider interface {
+	// CurrentKey 返回加密新值使用的密钥ID及密钥
+	CurrentKey() (id string, key []byte, err error)
+	// GetKey 根据值中记录的密钥ID返回解密使用的密钥，ID不存在时返回constant.ErrorUnknownEncryptionKey
+	GetKey(id string) ([]byte, error)
+}
+
+var (
+	keyProvidersMu sync.RWMutex
+	keyProviders   = make(map[string]KeyProvider)
+)
+
+// RegisterKeyProvider
+//
+//	@Description: 注册自定义的密钥来源，配置EncryptionKeyProvider为name时使用，需要在Init之前注册，
+//	name不能为env及file，同一个name重复注册时后者覆盖前者
+//	@param name
+//	@param p
+func RegisterKeyProvider(name string, p KeyProvider) {
+	keyProvidersMu.Lock()
+	defer keyProvidersMu.Unlock()
+	keyProviders[name] = p
+}
+
+// getKeyProvider 未配置EncryptionKeyProvider时返回nil，此时不能配置EncryptionKeyPrefixes
+func getKeyProvider() (KeyProvider, error) {
+	config := cacheConfig.GetCacheConfig()
+	switch config.EncryptionKeyProvider {
+	case "":
+		if len(config.EncryptionKeyPrefixes) > 0 {
+			return nil, errors.Wrap(constant.ErrorEncryptionNotConfigured, "encryption key prefixes without key provider")
+		}
+		return nil, nil
+	case KeyProviderEnv:
+		return NewEnvKeyProvider(config.EncryptionKeySource, config.EncryptionKeyID)
+	case KeyProviderFile:
+		return NewFileKeyProvider(config.EncryptionKeySource, config.EncryptionKeyID)
+	}
+	keyProvidersMu.RLock()
+	defer keyProvidersMu.RUnlock()
+	p, ok := keyProviders[config.EncryptionKeyProvider]
+	if !ok {
+		return nil, errors.Wrapf(constant.ErrorUnknownKeyProvider, "name=%s", config.EncryptionKeyProvider)
+	}
+	return p, nil
+}
+
+// StaticKeyProvider 使用固定的一组密钥，env、file及KMS的密钥加载后均使用该实现
+type StaticKeyProvider struct {
+	currentID string
+	keys      map[string][]byte
+}
+
+// NewStaticKeyProvider
+//
+//	@Description: 校验密钥ID及密钥长度，currentID必须在keys中
+//	@param currentID 加密新值使用的密钥ID
+//	@param keys 所有可用于解密的密钥，key为密钥ID
+//	@return *StaticKeyProvider
+//	@return error
+func NewStaticKeyProvider(currentID string, keys map[string][]byte) (*StaticKeyProvider, error) {
+	copied := make(map[string][]byte, len(keys))
+	for id, key := range keys {
+		if id == "" || len(id) > maxEncryptionKeyIDLen {
+			return nil, errors.Wrapf(constant.ErrorInvalidEncryptionKey, "key_id=%s", id)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, errors.Wrapf(constant.ErrorInvalidEncryptionKey, "key_id=%s, key_len=%d", id, len(key))
+		}
+		copied[id] = append([]byte(nil), key...)
+	}
+	if _, ok := copied[currentID]; !ok {
+		return nil, errors.Wrapf(constant.ErrorUnknownEncryptionKey, "current_key_id=%s", currentID)
+	}
+	return &StaticKeyProvider{currentID: currentID, keys: copied}, nil
+}
+
+func (p *StaticKeyProvider) CurrentKey() (string, []byte, error) {
+	return p.currentID, p.keys[p.currentID], nil
+}
+
+func (p *StaticKeyProvider) GetKey(id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, errors.Wrapf(constant.ErrorUnknownEncryptionKey, "key_id=%s", id)
+	}
+	return key, nil
+}
+
+// parseKeys 解析逗号或换行分隔的"id:base64(key)"，返回密钥及第一个密钥ID
+func parseKeys(s string) (map[string][]byte, string, error) {
+	keys := make(map[string][]byte)
+	first := ""
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, "", errors.Wrap(constant.ErrorInvalidEncryptionKey, "key should be id:base64(key)")
+		}
+		id = strings.TrimSpace(id)
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, "", errors.Wrapf(constant.ErrorInvalidEncryptionKey, "key_id=%s, err=%v", id, err)
+		}
+		if _, ok := keys[id]; ok {
+			return nil, "", errors.Wrapf(constant.ErrorInvalidEncryptionKey, "duplicated key_id=%s", id)
+		}
+		keys[id] = key
+		if first == "" {
+			first = id
+		}
+	}
+	return keys, first, nil
+}
+
+// newKeyProviderFromString currentID为空时使用第一个密钥
+func newKeyProviderFromString(s string, currentID string) (*StaticKeyProvider, error) {
+	keys, first, err := parseKeys(s)
+	if err != nil {
+		return nil, err
+	}
+	if currentID == "" {
+		currentID = first
+	}
+	return NewStaticKeyProvider(currentID, keys)
+}
+
+// NewEnvKeyProvider 从环境变量name中读取逗号分隔的"id:base64(key)"，currentID为空时使用第一个密钥
+func NewEnvKeyProvider(name string, currentID string) (*StaticKeyProvider, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, errors.Wrapf(constant.ErrorEncryptionNotConfigured, "env %s is not set", name)
+	}
+	return newKeyProviderFromString(value, currentID)
+}
+
+// NewFileKeyProvider 从文件中读取逗号或换行分隔的"id:base64(key)"，currentID为空时使用第一个密钥，
+// 只在创建时读取，修改文件后需要重新Init
+func NewFileKeyProvider(path string, currentID string) (*StaticKeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read encryption key file error|path=%s", path)
+	}
+	return newKeyProviderFromString(string(data), currentID)
+}
+
+// KMSDecrypter 解密使用KMS主密钥加密的数据密钥，由使用方对接具体的KMS
+type KMSDecrypter interface {
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// NewKMSKeyProvider
+//
+//	@Description: 使用KMS解密encryptedKeys中的数据密钥(envelope encryption)，解密只在创建时进行，
+//	之后加解密缓存值不再访问KMS
+//	@param ctx
+//	@param kms
+//	@param currentID 加密新值使用的密钥ID
+//	@param encryptedKeys 使用KMS主密钥加密后的数据密钥，key为密钥ID
+//	@return *StaticKeyProvider
+//	@return error
+func NewKMSKeyProvider(ctx context.Context, kms KMSDecrypter, currentID string, encryptedKeys map[string][]byte) (*StaticKeyProvider, error) {
+	keys := make(map[string][]byte, len(encryptedKeys))
+	for id, encrypted := range encryptedKeys {
+		key, err := kms.Decrypt(ctx, encrypted)
+		if err != nil {
+			return nil, errors.Wrapf(err, "kms decrypt data key error|key_id=%s", id)
+		}
+		keys[id] = key
+	}
+	return NewStaticKeyProvider(currentID, keys)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(constant.ErrorInvalidEncryptionKey, err.Error())
+	}
+	return cipher.NewGCM(block)
+}
+
+// valueCipher 加解密Main storage中的值，零值表示不加密且无法解密
+type valueCipher struct {
+	kp KeyProvider
+	// 添加namespace后的key，与header一起作为附加数据，密文复制到其他key后无法解密
+	key string
+	// 为true时拒绝未加密的值，防止能写入redis的一方为需要加密的key写入明文，空值标记除外
+	required bool
+}
+
+// additionalData header与key拼接，key的长度由header之后的全部数据决定，不会与header产生歧义
+func (vc valueCipher) additionalData(header []byte) []byte {
+	ad := make([]byte, 0, len(header)+len(vc.key))
+	ad = append(ad, header...)
+	return append(ad, vc.key...)
+}
+
+// sealValue 使用kp的当前密钥加密payload，h中记录密钥ID，header及key作为附加数据防止被替换
+func (vc valueCipher) sealValue(h valueHeader, payload []byte) ([]byte, error) {
+	id, key, err := vc.kp.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if id == "" || len(id) > maxEncryptionKeyIDLen {
+		return nil, errors.Wrapf(constant.ErrorInvalidEncryptionKey, "key_id=%s", id)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "key_id=%s", id)
+	}
+	h.flags |= valueFlagEncrypted
+	h.keyID = id
+	header := h.marshal()
+	data := make([]byte, len(header), len(header)+gcm.NonceSize()+len(payload)+gcm.Overhead())
+	copy(data, header)
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "generate nonce error")
+	}
+	data = append(data, nonce...)
+	return gcm.Seal(data, nonce, payload, vc.additionalData(header)), nil
+}
+
+// openValue 使用header中记录的密钥ID解密payload
+func (vc valueCipher) openValue(h valueHeader, header []byte, payload []byte) ([]byte, error) {
+	if vc.kp == nil {
+		return nil, errors.Wrapf(constant.ErrorEncryptionNotConfigured, "key_id=%s", h.keyID)
+	}
+	key, err := vc.kp.GetKey(h.keyID)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "key_id=%s", h.keyID)
+	}
+	if len(payload) < gcm.NonceSize() {
+		return nil, errors.Wrapf(constant.ErrorValueDecryptFailed, "value is truncated|key_id=%s", h.keyID)
+	}
+	plain, err := gcm.Open(nil, payload[:gcm.NonceSize()], payload[gcm.NonceSize():], vc.additionalData(header))
+	if err != nil {
+		return nil, errors.Wrapf(constant.ErrorValueDecryptFailed, "key_id=%s", h.keyID)
+	}
+	return plain, nil
+}
+
+// getKeyProvider key为添加namespace后的key，不需要加密时返回nil
+func (c *cacheManager) getKeyProvider(key string, opt *callOption) (KeyProvider, error) {
+	if opt.tombstone || (!opt.encrypt && !c.matchEncryptionPrefix(key, opt)) {
+		return nil, nil
+	}
+	if c.keyProvider == nil {
+		return nil, errors.Wrapf(constant.ErrorEncryptionNotConfigured, "key=%s", key)
+	}
+	return c.keyProvider, nil
+}
+
+// matchEncryptionPrefix key为添加namespace后的key，经过WithEncodeKeyType编码的key按编码前的key匹配
+func (c *cacheManager) matchEncryptionPrefix(key string, opt *callOption) bool {
+	key = c.getRuleKey(key, opt)
+	for _, prefix := range c.encryptionPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeValue 按key的前缀及CallWithEncryption决定是否加密
+func (c *cacheManager) encodeValue(key string, value interface{}, opt *callOption) ([]byte, error) {
+	kp, err := c.getKeyProvider(key, opt)
+	if err != nil {
+		return nil, err
+	}
+	return encodeValue(value, opt, valueCipher{kp: kp, key: key})
+}
+
+// decodeValue 加密的值使用c.keyProvider解密，key匹配EncryptionKeyPrefixes时拒绝未加密的值
+func (c *cacheManager) decodeValue(key string, data []byte, receiver interface{}, meta *valueMeta, opt *callOption) error {
+	return decodeValue(data, receiver, meta, valueCipher{kp: c.keyProvider, key: key, required: c.matchEncryptionPrefix(key, opt)})
+}
@@ -0,0 +1,268 @@
+// Package cache @Author  wangjian    2023/10/16 4:10 PM
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	cacheConfig "github.com/JianWangEx/commonService/cache/config"
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testKey1 = bytes.Repeat([]byte{1}, 32)
+	testKey2 = bytes.Repeat([]byte{2}, 16)
+)
+
+func setEncryptionConfig(t *testing.T, c cacheConfig.EncryptionConfig) {
+	config := cacheConfig.GetCacheConfig()
+	old := config.EncryptionConfig
+	config.EncryptionConfig = c
+	t.Cleanup(func() {
+		config.EncryptionConfig = old
+	})
+}
+
+func testKeys() string {
+	keys := []string{"k1:" + base64.StdEncoding.EncodeToString(testKey1), "k2:" + base64.StdEncoding.EncodeToString(testKey2)}
+	return strings.Join(keys, ",")
+}
+
+// xorKMS 测试用的KMS，使用固定字节异或加解密
+type xorKMS struct{}
+
+func (xorKMS) Decrypt(_ context.Context, ciphertext []byte) ([]byte, error) {
+	plain := make([]byte, len(ciphertext))
+	for i, b := range ciphertext {
+		plain[i] = b ^ 0x5A
+	}
+	return plain, nil
+}
+
+func TestKeyProvider(t *testing.T) {
+	t.Setenv("TEST_CACHE_KEYS", testKeys())
+	p, err := NewEnvKeyProvider("TEST_CACHE_KEYS", "")
+	require.NoError(t, err)
+	id, key, err := p.CurrentKey()
+	require.NoError(t, err)
+	assert.Equal(t, "k1", id)
+	assert.Equal(t, testKey1, key)
+	key, err = p.GetKey("k2")
+	require.NoError(t, err)
+	assert.Equal(t, testKey2, key)
+	_, err = p.GetKey("k3")
+	assert.True(t, errors.Is(err, constant.ErrorUnknownEncryptionKey))
+
+	path := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(path, []byte(strings.ReplaceAll(testKeys(), ",", "\n")+"\n"), 0600))
+	p, err = NewFileKeyProvider(path, "k2")
+	require.NoError(t, err)
+	id, _, err = p.CurrentKey()
+	require.NoError(t, err)
+	assert.Equal(t, "k2", id)
+
+	wrapped, _ := xorKMS{}.Decrypt(context.TODO(), testKey1)
+	p, err = NewKMSKeyProvider(context.TODO(), xorKMS{}, "k1", map[string][]byte{"k1": wrapped})
+	require.NoError(t, err)
+	_, key, _ = p.CurrentKey()
+	assert.Equal(t, testKey1, key)
+
+	_, err = NewEnvKeyProvider("TEST_CACHE_KEYS_NOT_SET", "")
+	assert.True(t, errors.Is(err, constant.ErrorEncryptionNotConfigured))
+	_, err = NewStaticKeyProvider("k1", map[string][]byte{"k1": []byte("short")})
+	assert.True(t, errors.Is(err, constant.ErrorInvalidEncryptionKey))
+	_, err = NewStaticKeyProvider("k3", map[string][]byte{"k1": testKey1})
+	assert.True(t, errors.Is(err, constant.ErrorUnknownEncryptionKey))
+	_, err = newKeyProviderFromString("k1:"+base64.StdEncoding.EncodeToString(testKey1)+",k1:"+base64.StdEncoding.EncodeToString(testKey2), "")
+	assert.True(t, errors.Is(err, constant.ErrorInvalidEncryptionKey))
+}
+
+func TestEncryptValue(t *testing.T) {
+	p1, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": testKey1})
+	require.NoError(t, err)
+	// 轮换后的当前密钥为k2，k1仍可用于解密
+	p2, err := NewStaticKeyProvider("k2", map[string][]byte{"k1": testKey1, "k2": testKey2})
+	require.NoError(t, err)
+
+	value := strings.Repeat("secret", 100)
+	meta := &valueMeta{softExpireAt: 1, hardExpireAt: 2, delta: 3}
+	opt := &callOption{codec: JSONCodec{}, compressType: CompressSnappy, compressThreshold: 10, meta: meta}
+	data, err := encodeValue(value, opt, valueCipher{kp: p1, key: "token:1"})
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(data, []byte("secret")))
+	h, _, ok, err := parseValueHeader(data)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "k1", h.keyID)
+	assert.Equal(t, CompressSnappy, h.compress)
+
+	receiver, got := new(string), new(valueMeta)
+	require.NoError(t, decodeValue(data, receiver, got, valueCipher{kp: p2, key: "token:1"}))
+	assert.Equal(t, value, *receiver)
+	assert.Equal(t, *meta, *got)
+
+	err = decodeValue(data, receiver, nil, valueCipher{key: "token:1"})
+	assert.True(t, errors.Is(err, constant.ErrorEncryptionNotConfigured))
+	// key作为附加数据，复制到其他key后无法解密
+	err = decodeValue(data, receiver, nil, valueCipher{kp: p2, key: "token:2"})
+	assert.True(t, errors.Is(err, constant.ErrorValueDecryptFailed))
+	// header作为附加数据，修改后无法解密
+	tampered := append([]byte(nil), data...)
+	tampered[3] = byte(CompressNone)
+	err = decodeValue(tampered, receiver, nil, valueCipher{kp: p2, key: "token:1"})
+	assert.True(t, errors.Is(err, constant.ErrorValueDecryptFailed))
+	p3, err := NewStaticKeyProvider("k2", map[string][]byte{"k2": testKey2})
+	require.NoError(t, err)
+	err = decodeValue(data, receiver, nil, valueCipher{kp: p3, key: "token:1"})
+	assert.True(t, errors.Is(err, constant.ErrorUnknownEncryptionKey))
+}
+
+func TestCacheEncryption(t *testing.T) {
+	t.Setenv("TEST_CACHE_KEYS", testKeys())
+	setEncryptionConfig(t, cacheConfig.EncryptionConfig{
+		EncryptionKeyProvider: KeyProviderEnv,
+		EncryptionKeySource:   "TEST_CACHE_KEYS",
+		EncryptionKeyPrefixes: []string{"pii:"},
+	})
+	server := newTestManager(t)
+	manager := GetCacheManager()
+	ctx := context.TODO()
+
+	require.NoError(t, manager.Set(ctx, "pii:1", "alice@example.com", time.Minute))
+	require.NoError(t, manager.MSet(ctx, map[string]interface{}{"pii:2": "bob@example.com", "user:1": "carol"}, time.Minute))
+	require.NoError(t, manager.Set(ctx, "token:1", "secret-token", time.Minute, CallWithEncryption(true)))
+	for key, plain := range map[string]string{"pii:1": "alice", "pii:2": "bob", "token:1": "secret-token"} {
+		raw, err := server.Get(key)
+		require.NoError(t, err)
+		assert.NotContains(t, raw, plain)
+	}
+	raw, err := server.Get("user:1")
+	require.NoError(t, err)
+	assert.Contains(t, raw, "carol")
+
+	val := new(string)
+	require.NoError(t, manager.Get(ctx, "token:1", val))
+	assert.Equal(t, "secret-token", *val)
+	receiver := make(map[string]string)
+	require.NoError(t, manager.MGet(ctx, []string{"pii:1", "pii:2", "user:1"}, &receiver))
+	assert.Equal(t, map[string]string{"pii:1": "alice@example.com", "pii:2": "bob@example.com", "user:1": "carol"}, receiver)
+
+	// 复制到其他key的密文无法解密
+	raw, err = server.Get("pii:1")
+	require.NoError(t, err)
+	require.NoError(t, server.Set("pii:3", raw))
+	err = manager.Get(ctx, "pii:3", val)
+	assert.True(t, errors.Is(err, constant.ErrorValueDecryptFailed))
+	// 需要加密的key拒绝明文及历史版本的值
+	plain, err := encodeValue("mallory@example.com", client.newCallOption(), valueCipher{})
+	require.NoError(t, err)
+	require.NoError(t, server.Set("pii:4", string(plain)))
+	require.NoError(t, server.Set("pii:5", `"mallory@example.com"`))
+	for _, key := range []string{"pii:4", "pii:5"} {
+		err = manager.Get(ctx, key, val)
+		assert.True(t, errors.Is(err, constant.ErrorValueNotEncrypted), key)
+	}
+	receiver = make(map[string]string)
+	require.NoError(t, manager.MGet(ctx, []string{"pii:1", "pii:3", "pii:4"}, &receiver))
+	assert.Equal(t, map[string]string{"pii:1": "alice@example.com"}, receiver)
+
+	result := new(string)
+	require.NoError(t, AddCacheHandle(ctx, result, func(ctx context.Context, receiver interface{}) (interface{}, error) {
+		return "dave", nil
+	}, NewAddCacheParam("profile:1", WithEncryption(true))))
+	raw, err = server.Get("profile:1")
+	require.NoError(t, err)
+	assert.NotContains(t, raw, "dave")
+
+	// 未配置密钥的实例无法读取加密的值
+	setEncryptionConfig(t, cacheConfig.EncryptionConfig{})
+	require.NoError(t, InitWithClient(GetRedisClient()))
+	err = GetCacheManager().Get(ctx, "pii:1", val)
+	assert.True(t, errors.Is(err, constant.ErrorEncryptionNotConfigured))
+	err = GetCacheManager().Set(ctx, "pii:1", "v", time.Minute, CallWithEncryption(true))
+	assert.True(t, errors.Is(err, constant.ErrorEncryptionNotConfigured))
+}
+
+func TestCacheEncryptionEncodedKey(t *testing.T) {
+	t.Setenv("TEST_CACHE_KEYS", testKeys())
+	setEncryptionConfig(t, cacheConfig.EncryptionConfig{
+		EncryptionKeyProvider: KeyProviderEnv,
+		EncryptionKeySource:   "TEST_CACHE_KEYS",
+		EncryptionKeyPrefixes: []string{"pii:"},
+	})
+	server := newTestManager(t)
+	ctx := context.TODO()
+
+	// WithEncodeKeyType编码后的key按编码前的key匹配EncryptionKeyPrefixes
+	loads := 0
+	f := func(ctx context.Context, receiver interface{}) (interface{}, error) {
+		loads++
+		return "alice@example.com", nil
+	}
+	result := new(string)
+	require.NoError(t, AddCacheHandle(ctx, result, f, NewAddCacheParam("pii:1", WithEncodeKeyType(Md5))))
+	raw, err := server.Get(md5Key("pii:1"))
+	require.NoError(t, err)
+	assert.NotContains(t, raw, "alice")
+	require.NoError(t, AddCacheHandle(ctx, result, f, NewAddCacheParam("pii:1", WithEncodeKeyType(Md5))))
+	assert.Equal(t, "alice@example.com", *result)
+	assert.Equal(t, 1, loads)
+
+	// 写入编码后key的明文被拒绝，重新回源
+	plain, err := encodeValue("mallory@example.com", client.newCallOption(), valueCipher{})
+	require.NoError(t, err)
+	require.NoError(t, server.Set(md5Key("pii:1"), string(plain)))
+	err = GetCacheManager().Get(ctx, md5Key("pii:1"), result, callWithRuleKeys(map[string]string{md5Key("pii:1"): "pii:1"}))
+	assert.True(t, errors.Is(err, constant.ErrorValueNotEncrypted))
+	require.NoError(t, AddCacheHandle(ctx, result, f, NewAddCacheParam("pii:1", WithEncodeKeyType(Md5))))
+	assert.Equal(t, "alice@example.com", *result)
+	assert.Equal(t, 2, loads)
+
+	// AddCacheBatchHandle通过MSet写入编码后的key
+	receiver := make(map[string]string)
+	require.NoError(t, AddCacheBatchHandle(ctx, []string{"2", "3"}, &receiver, func(ctx context.Context, ids []string) (map[string]interface{}, error) {
+		values := make(map[string]interface{}, len(ids))
+		for _, id := range ids {
+			values[id] = "user-" + id + "@example.com"
+		}
+		return values, nil
+	}, NewAddCacheParam("pii:%s", WithEncodeKeyType(Md5))))
+	for _, id := range []string{"2", "3"} {
+		raw, err = server.Get(md5Key("pii:" + id))
+		require.NoError(t, err)
+		assert.NotContains(t, raw, "user-"+id)
+	}
+	require.NoError(t, server.Set(md5Key("pii:3"), string(plain)))
+	receiver = make(map[string]string)
+	require.NoError(t, AddCacheBatchHandle(ctx, []string{"2", "3"}, &receiver, func(ctx context.Context, ids []string) (map[string]interface{}, error) {
+		assert.Equal(t, []string{"3"}, ids)
+		return map[string]interface{}{"3": "user-3@example.com"}, nil
+	}, NewAddCacheParam("pii:%s", WithEncodeKeyType(Md5))))
+	assert.Equal(t, map[string]string{"2": "user-2@example.com", "3": "user-3@example.com"}, receiver)
+}
+
+func TestRegisterKeyProvider(t *testing.T) {
+	p, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": testKey1})
+	require.NoError(t, err)
+	RegisterKeyProvider("test", p)
+	setEncryptionConfig(t, cacheConfig.EncryptionConfig{EncryptionKeyProvider: "test"})
+	got, err := getKeyProvider()
+	require.NoError(t, err)
+	assert.Equal(t, p, got)
+
+	setEncryptionConfig(t, cacheConfig.EncryptionConfig{EncryptionKeyProvider: "unknown"})
+	_, err = getKeyProvider()
+	assert.True(t, errors.Is(err, constant.ErrorUnknownKeyProvider))
+	setEncryptionConfig(t, cacheConfig.EncryptionConfig{EncryptionKeyPrefixes: []string{"pii:"}})
+	_, err = getKeyProvider()
+	assert.True(t, errors.Is(err, constant.ErrorEncryptionNotConfigured))
+}
@@ -0,0 +1,82 @@
+// Package cache @Author  wangjian    2023/8/30 10:30 AM
+package cache
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// 泛型版本的缓存API，底层使用同一个cacheManager，与Client及AddCacheHandle的调用者共享存储
+
+// Get 泛型版本的Client.Get，未命中时返回constant.ErrorCacheMiss
+func Get[T any](ctx context.Context, key string, opts ...CallOption) (T, error) {
+	var value T
+	err := GetCacheManager().Get(ctx, key, &value, opts...)
+	return value, err
+}
+
+// Set 泛型版本的Client.Set
+func Set[T any](ctx context.Context, key string, value T, expired time.Duration, opts ...CallOption) error {
+	return GetCacheManager().Set(ctx, key, value, expired, opts...)
+}
+
+// Add 泛型版本的Client.Add，key已存在时返回constant.ErrorFailedOperation
+func Add[T any](ctx context.Context, key string, value T, expired time.Duration, opts ...CallOption) error {
+	return GetCacheManager().Add(ctx, key, value, expired, opts...)
+}
+
+// MGet 泛型版本的Client.MGet，返回的map只包含命中的key
+func MGet[T any](ctx context.Context, keys []string, opts ...CallOption) (map[string]T, error) {
+	values := make(map[string]T, len(keys))
+	err := GetCacheManager().MGet(ctx, keys, &values, opts...)
+	return values, err
+}
+
+// GetOrLoad
+//
+//	@Description: 泛型版本的AddCacheHandle，命中时直接返回缓存值，否则调用loader并根据params写入缓存
+//	@param ctx
+//	@param key
+//	@param loader
+//	@param params 与NewAddCacheParam的options相同，如WithTimeout、WithCacheStore
+//	@return T
+//	@return error
+func GetOrLoad[T any](ctx context.Context, key string, loader func(ctx context.Context) (T, error), params ...SetParam) (T, error) {
+	var value T
+	// DeepCopy不支持指向nil的指针，T为指针时先创建其指向的值，与AddCacheBatchHandle相同
+	if t := reflect.TypeOf((*T)(nil)).Elem(); t.Kind() == reflect.Ptr {
+		value = reflect.New(t.Elem()).Interface().(T)
+	}
+	err := AddCacheHandle(ctx, &value, func(ctx context.Context, receiver interface{}) (interface{}, error) {
+		v, err := loader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	}, NewAddCacheParam(key, params...))
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return value, nil
+}
+
+// WithLock
+//
+//	@Description: 泛型版本的AddCacheLockHandler，获得锁后执行fn，未获得锁时返回T的零值及错误
+//	@param ctx
+//	@param key
+//	@param fn
+//	@param params 与NewAddCacheLockParam的options相同，如CacheLockWithTimeout
+//	@return T
+//	@return error
+func WithLock[T any](ctx context.Context, key string, fn func(ctx context.Context) (T, error), params ...SetCacheLockParam) (T, error) {
+	var value T
+	_, err := AddCacheLockHandler(ctx, func(ctx context.Context) (interface{}, error) {
+		v, err := fn(ctx)
+		value = v
+		return v, err
+	}, NewAddCacheLockParam(key, params...))
+	return value, err
+}
@@ -0,0 +1,78 @@
+// Package cache @Author  wangjian    2023/8/30 2:40 PM
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type genericTestUser struct {
+	Name string
+}
+
+func TestGenericGetSet(t *testing.T) {
+	newTestManager(t)
+	ctx := context.TODO()
+
+	_, err := Get[*genericTestUser](ctx, "generic_user")
+	assert.Equal(t, constant.ErrorCacheMiss, err)
+
+	require.NoError(t, Set(ctx, "generic_user", &genericTestUser{Name: "cat"}, time.Minute))
+	user, err := Get[*genericTestUser](ctx, "generic_user")
+	require.NoError(t, err)
+	assert.Equal(t, "cat", user.Name)
+
+	users, err := MGet[genericTestUser](ctx, []string{"generic_user", "missing"})
+	require.NoError(t, err)
+	assert.Len(t, users, 1)
+	assert.Equal(t, "cat", users["generic_user"].Name)
+}
+
+func TestGetOrLoad(t *testing.T) {
+	newTestManager(t)
+	ctx := context.TODO()
+
+	calls := 0
+	loader := func(ctx context.Context) ([]string, error) {
+		calls++
+		return []string{"a", "b"}, nil
+	}
+	for n := 0; n < 2; n++ {
+		values, err := GetOrLoad(ctx, "generic_list", loader)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a", "b"}, values)
+	}
+	assert.Equal(t, 1, calls)
+}
+
+func TestGetOrLoadPointer(t *testing.T) {
+	newTestManager(t)
+	ctx := context.TODO()
+
+	for _, storage := range []Storage{Main, Local} {
+		calls := 0
+		loader := func(ctx context.Context) (*genericTestUser, error) {
+			calls++
+			return &genericTestUser{Name: "cat"}, nil
+		}
+		for n := 0; n < 2; n++ {
+			user, err := GetOrLoad(ctx, "generic_pointer_user", loader, WithCacheStore(storage))
+			require.NoError(t, err)
+			require.NotNil(t, user)
+			assert.Equal(t, "cat", user.Name)
+		}
+		assert.Equal(t, 1, calls, storage)
+	}
+
+	// 回源返回nil时返回零值
+	user, err := GetOrLoad(ctx, "generic_nil_user", func(ctx context.Context) (*genericTestUser, error) {
+		return nil, nil
+	})
+	assert.Equal(t, constant.ErrorAddCacheGotNilResult, err)
+	assert.Nil(t, user)
+}
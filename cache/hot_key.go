@@ -0,0 +1,300 @@
+// Package cache @Author  wangjian    2023/10/9 11:05 AM
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cacheConfig "github.com/JianWangEx/commonService/cache/config"
+	"github.com/JianWangEx/commonService/constant"
+	logger "github.com/JianWangEx/commonService/log"
+)
+
+// HotKey 热点key的统计信息
+type HotKey struct {
+	// 不含namespace前缀的key
+	Key string `json:"key"`
+	// 当前窗口内估算的读取次数，由count-min sketch估算，可能偏大
+	Count uint64 `json:"count"`
+	// 成为热点key的时间
+	Since time.Time `json:"since"`
+}
+
+type hotKeyStat struct {
+	count uint64
+	since time.Time
+}
+
+// hotKeyDetector
+//
+//	@Description: 使用count-min sketch统计滑动窗口内每个key的读取次数，窗口划分为hotKeyWindowBuckets个桶，
+//	每经过一个桶的时长清空最旧的桶，窗口内读取次数达到threshold的key为热点key，
+//	热点key的读取次数低于threshold后不再是热点key。key按hash分为hotKeyShards个分片，
+//	每个分片有独立的sketch及锁，热点key数量的上限由所有分片共享
+type hotKeyDetector struct {
+	threshold      uint64
+	maxKeys        int32
+	bucketDuration time.Duration
+	// 热点key在local cache中的过期时间
+	localTimeout time.Duration
+	now          func() time.Time
+
+	shards []*hotKeyShard
+	// 所有分片中热点key的数量
+	hotCount int32
+}
+
+type hotKeyShard struct {
+	mu        sync.Mutex
+	buckets   []*windowSketch
+	current   int
+	rotatedAt time.Time
+	hot       map[string]*hotKeyStat
+}
+
+func newHotKeyDetector(window time.Duration, threshold int, maxKeys int, localTimeout time.Duration) *hotKeyDetector {
+	if window <= 0 {
+		window = defaultHotKeyWindow
+	}
+	if threshold <= 0 {
+		threshold = defaultHotKeyThreshold
+	}
+	if maxKeys <= 0 {
+		maxKeys = defaultHotKeyMaxKeys
+	}
+	if localTimeout <= 0 {
+		localTimeout = defaultHotKeyLocalTimeout
+	}
+	d := &hotKeyDetector{
+		threshold:      uint64(threshold),
+		maxKeys:        int32(maxKeys),
+		bucketDuration: window / hotKeyWindowBuckets,
+		localTimeout:   localTimeout,
+		now:            time.Now,
+		shards:         make([]*hotKeyShard, hotKeyShards),
+	}
+	now := d.now()
+	for i := range d.shards {
+		shard := &hotKeyShard{
+			buckets:   make([]*windowSketch, hotKeyWindowBuckets),
+			rotatedAt: now,
+			hot:       make(map[string]*hotKeyStat),
+		}
+		for j := range shard.buckets {
+			shard.buckets[j] = newWindowSketch()
+		}
+		d.shards[i] = shard
+	}
+	return d
+}
+
+// getHotKeyDetector 未开启热点key统计时返回nil
+func getHotKeyDetector() *hotKeyDetector {
+	config := cacheConfig.GetCacheConfig()
+	if !config.HotKeyEnabled {
+		return nil
+	}
+	return newHotKeyDetector(time.Duration(config.HotKeyWindow)*time.Second, config.HotKeyThreshold,
+		config.HotKeyMaxKeys, time.Duration(config.HotKeyLocalExpiration)*time.Millisecond)
+}
+
+// getShard 使用hash的高位选择分片，sketch的下标使用低位，避免同一分片中的key集中在部分计数上
+func (d *hotKeyDetector) getShard(h uint64) *hotKeyShard {
+	return d.shards[(h>>32)%uint64(len(d.shards))]
+}
+
+// record 记录一次读取，返回key是否为热点key
+func (d *hotKeyDetector) record(key string) bool {
+	h := sketchHash(key)
+	shard := d.getShard(h)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	now := d.now()
+	d.rotate(shard, now)
+	shard.buckets[shard.current].increment(h)
+	if stat, ok := shard.hot[key]; ok {
+		stat.count++
+		return true
+	}
+	count := shard.estimate(h)
+	if count < d.threshold || !d.acquireHotSlot() {
+		return false
+	}
+	shard.hot[key] = &hotKeyStat{count: count, since: now}
+	logger.CtxSugar(context.Background()).Infof("cache hot key detected|key=%s, count=%d", key, count)
+	return true
+}
+
+// acquireHotSlot 热点key数量未达到maxKeys时占用一个名额
+func (d *hotKeyDetector) acquireHotSlot() bool {
+	for {
+		n := atomic.LoadInt32(&d.hotCount)
+		if n >= d.maxKeys {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&d.hotCount, n, n+1) {
+			return true
+		}
+	}
+}
+
+// hotKeys 返回当前的热点key，按读取次数从多到少排序
+func (d *hotKeyDetector) hotKeys() []HotKey {
+	now := d.now()
+	keys := make([]HotKey, 0, d.count())
+	for _, shard := range d.shards {
+		shard.mu.Lock()
+		d.rotate(shard, now)
+		for key, stat := range shard.hot {
+			keys = append(keys, HotKey{Key: key, Count: stat.count, Since: stat.since})
+		}
+		shard.mu.Unlock()
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	return keys
+}
+
+func (d *hotKeyDetector) count() int {
+	return int(atomic.LoadInt32(&d.hotCount))
+}
+
+// rotate 清空分片中已经移出窗口的桶，并重新估算热点key的读取次数，需要在分片的锁内调用
+func (d *hotKeyDetector) rotate(shard *hotKeyShard, now time.Time) {
+	steps := int(now.Sub(shard.rotatedAt) / d.bucketDuration)
+	if steps <= 0 {
+		return
+	}
+	shard.rotatedAt = shard.rotatedAt.Add(time.Duration(steps) * d.bucketDuration)
+	if steps > len(shard.buckets) {
+		steps = len(shard.buckets)
+	}
+	for i := 0; i < steps; i++ {
+		shard.current = (shard.current + 1) % len(shard.buckets)
+		shard.buckets[shard.current].reset()
+	}
+	for key, stat := range shard.hot {
+		stat.count = shard.estimate(sketchHash(key))
+		if stat.count < d.threshold {
+			delete(shard.hot, key)
+			atomic.AddInt32(&d.hotCount, -1)
+		}
+	}
+}
+
+// estimate 窗口内所有桶的估算值之和
+func (shard *hotKeyShard) estimate(h uint64) uint64 {
+	var count uint64
+	for _, bucket := range shard.buckets {
+		count += uint64(bucket.estimate(h))
+	}
+	return count
+}
+
+// windowSketch 滑动窗口中一个桶的count-min sketch，与TinyLFU的sketch不同，计数不设上限
+type windowSketch struct {
+	rows [hotKeySketchDepth][]uint32
+}
+
+func newWindowSketch() *windowSketch {
+	s := &windowSketch{}
+	for i := range s.rows {
+		s.rows[i] = make([]uint32, hotKeyShardSketchWidth)
+	}
+	return s
+}
+
+func (s *windowSketch) increment(h uint64) {
+	for i := range s.rows {
+		s.rows[i][s.index(h, i)]++
+	}
+}
+
+func (s *windowSketch) estimate(h uint64) uint32 {
+	min := s.rows[0][s.index(h, 0)]
+	for i := 1; i < len(s.rows); i++ {
+		if v := s.rows[i][s.index(h, i)]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+func (s *windowSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] = 0
+		}
+	}
+}
+
+func (s *windowSketch) index(h uint64, row int) uint64 {
+	h1, h2 := h, h>>32|h<<32
+	return (h1 + uint64(row)*h2 + uint64(row*row)) % hotKeyShardSketchWidth
+}
+
+// isHotKey 记录Main storage中key的一次读取，未开启热点key统计时返回false
+func (c *cacheManager) isHotKey(key string) bool {
+	return c.hotKeys != nil && c.hotKeys.record(strings.TrimPrefix(key, c.keyPrefix))
+}
+
+// getHotKey 热点key先读local cache，未命中时读取redis并写入local cache，空值标记同样写入
+func (c *cacheManager) getHotKey(ctx context.Context, key string, receiver interface{}, opt *callOption) error {
+	err := c.getLocal(ctx, key, receiver, opt)
+	if err == nil || err == constant.ErrorNegativeCacheHit {
+		return err
+	}
+	err = c.getMain(ctx, key, receiver, opt)
+	switch {
+	case c.degraded(err):
+		// local cache已经未命中
+		return constant.ErrorCacheMiss
+	case err == constant.ErrorNegativeCacheHit:
+		c.localCacheClient.Set(ctx, key, newLocalTombstone(opt.metaReceiver), c.hotKeys.localTimeout)
+	case err == nil:
+		c.setLocal(ctx, key, receiver, c.hotKeys.localTimeout, &callOption{meta: opt.metaReceiver})
+	}
+	return err
+}
+
+// invalidateHotKeys 开启热点key统计时，Main storage中的key可能已被任意实例提升到local cache，
+// 写入及删除redis后需要删除本实例及其他实例中的副本
+func (c *cacheManager) invalidateHotKeys(ctx context.Context, keys ...string) {
+	if c.hotKeys == nil || len(keys) == 0 {
+		return
+	}
+	for _, key := range keys {
+		c.localCacheClient.Delete(ctx, key)
+	}
+	c.publishInvalidation(ctx, keys...)
+}
+
+// GetHotKeys 返回当前实例统计的热点key，按读取次数从多到少排序，未Init或未开启热点key统计时返回nil
+func GetHotKeys() []HotKey {
+	if client == nil || client.hotKeys == nil {
+		return nil
+	}
+	return client.hotKeys.hotKeys()
+}
+
+// HotKeyHandler 以JSON格式输出GetHotKeys的http handler
+func HotKeyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		keys := GetHotKeys()
+		if keys == nil {
+			keys = []HotKey{}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_ = json.NewEncoder(w).Encode(keys)
+	})
+}
@@ -0,0 +1,127 @@
+// Package cache @Author  wangjian    2023/10/10 3:15 PM
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	cacheConfig "github.com/JianWangEx/commonService/cache/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setHotKeyConfig(t *testing.T, c cacheConfig.HotKeyConfig) {
+	config := cacheConfig.GetCacheConfig()
+	old := config.HotKeyConfig
+	config.HotKeyConfig = c
+	t.Cleanup(func() {
+		config.HotKeyConfig = old
+	})
+}
+
+func TestHotKeyDetector(t *testing.T) {
+	now := time.Now()
+	d := newHotKeyDetector(10*time.Second, 3, 2, 0)
+	d.now = func() time.Time { return now }
+	assert.Equal(t, defaultHotKeyLocalTimeout, d.localTimeout)
+
+	assert.False(t, d.record("a"))
+	assert.False(t, d.record("a"))
+	assert.True(t, d.record("a"))
+	assert.True(t, d.record("a"))
+	assert.False(t, d.record("b"))
+
+	// 窗口内的读取分布在多个桶中
+	now = now.Add(3 * time.Second)
+	for i := 0; i < 3; i++ {
+		d.record("b")
+	}
+	for i := 0; i < 3; i++ {
+		d.record("c")
+	}
+	// 热点key数量达到上限，c和d不再被提升
+	for i := 0; i < 3; i++ {
+		assert.False(t, d.record("d"))
+	}
+	keys := d.hotKeys()
+	require.Len(t, keys, 2)
+	assert.Equal(t, HotKey{Key: "a", Count: 4, Since: keys[0].Since}, keys[0])
+	assert.Equal(t, "b", keys[1].Key)
+	assert.Equal(t, 2, d.count())
+
+	// a的读取移出窗口后不再是热点key
+	now = now.Add(8 * time.Second)
+	keys = d.hotKeys()
+	require.Len(t, keys, 1)
+	assert.Equal(t, "b", keys[0].Key)
+	now = now.Add(time.Minute)
+	assert.Empty(t, d.hotKeys())
+}
+
+func TestHotKeyDetectorConcurrent(t *testing.T) {
+	d := newHotKeyDetector(10*time.Second, 10, 5, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				for k := 0; k < 20; k++ {
+					d.record(fmt.Sprintf("key_%d", k))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// 热点key数量的上限由所有分片共享
+	assert.Equal(t, 5, d.count())
+	assert.Len(t, d.hotKeys(), 5)
+}
+
+func TestCacheHotKeyPromotion(t *testing.T) {
+	setHotKeyConfig(t, cacheConfig.HotKeyConfig{
+		HotKeyEnabled:         true,
+		HotKeyThreshold:       3,
+		HotKeyLocalExpiration: int(time.Minute / time.Millisecond),
+	})
+	server := newTestManager(t)
+	manager := GetCacheManager()
+	ctx := context.TODO()
+
+	require.NoError(t, manager.Set(ctx, "config:global", "v1", time.Minute))
+	val := new(string)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, manager.Get(ctx, "config:global", val))
+	}
+	// 已提升到local cache，redis中的修改在local过期前不可见
+	require.NoError(t, server.Set("config:global", `"v2"`))
+	require.NoError(t, manager.Get(ctx, "config:global", val))
+	assert.Equal(t, "v1", *val)
+
+	// 通过Client写入时删除local cache中的副本
+	require.NoError(t, manager.Set(ctx, "config:global", "v3", time.Minute))
+	require.NoError(t, manager.Get(ctx, "config:global", val))
+	assert.Equal(t, "v3", *val)
+
+	receiver := make(map[string]string)
+	require.NoError(t, manager.MGet(ctx, []string{"config:global"}, &receiver))
+	assert.Equal(t, "v3", receiver["config:global"])
+	require.NoError(t, manager.MDelete(ctx, []string{"config:global"}))
+	assert.Equal(t, 0, client.localCacheClient.Stats().Items)
+
+	keys := GetHotKeys()
+	require.Len(t, keys, 1)
+	assert.Equal(t, "config:global", keys[0].Key)
+
+	rec := httptest.NewRecorder()
+	HotKeyHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/hot_keys", nil))
+	var report []HotKey
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	require.Len(t, report, 1)
+	assert.Equal(t, keys[0].Key, report[0].Key)
+}
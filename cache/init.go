@@ -4,7 +4,9 @@ package cache
 import (
 	"context"
 	cacheConfig "github.com/JianWangEx/commonService/cache/config"
+	logger "github.com/JianWangEx/commonService/log"
 	"github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
 	"github.com/redis/go-redis/v9"
 	"sync"
 	"time"
@@ -16,18 +18,78 @@ var (
 )
 
 type cacheManager struct {
-	redisClient      redis.UniversalClient
+	// 所有redis连接，包含DefaultCluster
+	clusters map[string]*redisCluster
+	// 按前缀长度从长到短排序
+	clusterRoutes []clusterRoute
+	// 锁默认使用的连接，为空时按key路由
+	lockCluster string
+
 	localCacheClient *LocalCacheManager
+
+	// Main storage默认的编解码及压缩配置，可被CallOption覆盖
+	codec             Codec
+	compressType      CompressType
+	compressThreshold int
+	// 为false时满足条件的值以历史版本的json格式写入，见CodecConfig.WriteValueHeader
+	writeValueHeader bool
+
+	// Tiered storage回填L1时使用的过期时间
+	tieredLocalTimeout time.Duration
+	// expired为DefaultTTL时按key前缀使用的过期时间
+	ttlRules *ttlRules
+	// 为nil时表示未配置加密，不能写入或读取加密的值
+	keyProvider KeyProvider
+	// 写入Main storage时需要加密的key前缀
+	encryptionPrefixes []string
+
+	// 为nil时表示未开启实例间local cache失效同步
+	invalidator *invalidator
+	// 为nil时表示未开启热点key统计
+	hotKeys *hotKeyDetector
+
+	// 所有key的namespace前缀，为空时不添加前缀
+	keyPrefix string
+	// 为nil时表示未配置Redlock节点
+	redlock *redlockStore
+
+	// 熔断打开时Main storage的读写改为使用local cache
+	degradeToLocal bool
+	// 停止后台连接redis，为nil时表示未在后台连接
+	cancelConnect context.CancelFunc
+	// 停止Init时的后台预热，为nil时表示未开启WarmUpOnInit
+	cancelWarmUp context.CancelFunc
+
+	// 未显式指定storage时使用的storage
+	defaultStorage Storage
+	// 为true时不再根据key的后缀".local"选择storage
+	disableStorageSuffix bool
 }
 
 func GetCacheManager() Client {
 	return client
 }
 
+// getRedisConn eager模式下Ping失败时仍返回client及错误，与RedisClusters中的连接相同，
+// 之后的命令失败时由熔断降级，go-redis会重新建立连接；其他模式只创建client
 func getRedisConn() (redis.UniversalClient, error) {
-	// 获取redis config
-	config := cacheConfig.GetCacheConfig()
-	redisClient := redis.NewUniversalClient(&redis.UniversalOptions{
+	mode, err := ParseRedisConnectMode(cacheConfig.GetCacheConfig().RedisConnectMode)
+	if err != nil {
+		return nil, err
+	}
+	redisClient := newRedisClient(cacheConfig.GetCacheConfig().RedisConfig)
+	if mode != RedisConnectEager {
+		return redisClient, nil
+	}
+	if err := redisClient.Ping(context.TODO()).Err(); err != nil {
+		return redisClient, errors.Wrap(err, "redis ping error")
+	}
+	return redisClient, nil
+}
+
+// newRedisClient default及RedisClusters中的连接都使用该方法创建
+func newRedisClient(config cacheConfig.RedisConfig) redis.UniversalClient {
+	return redis.NewUniversalClient(&redis.UniversalOptions{
 		Addrs:                 config.Addrs,
 		ClientName:            config.ClientName,
 		DB:                    config.DB,
@@ -59,33 +121,248 @@ func getRedisConn() (redis.UniversalClient, error) {
 		RouteRandomly:         config.RouteRandomly,
 		MasterName:            config.MasterName,
 	})
+}
 
-	if err := redisClient.Ping(context.TODO()).Err(); err != nil {
+func getLocalCache(codec Codec) (*LocalCacheManager, error) {
+	// 获取local cache config
+	config := cacheConfig.GetCacheConfig()
+	storeType, err := ParseLocalStoreType(config.LocalStoreType)
+	if err != nil {
 		return nil, err
 	}
-	return redisClient, nil
+	defaultExpiration := time.Duration(config.DefaultExpiration) * time.Minute
+	if storeType == LocalStoreUnbounded && config.LocalMaxItems <= 0 && config.LocalMaxBytes <= 0 {
+		return NewLocalCacheManager(cache.New(defaultExpiration, time.Duration(config.CleanupInterval)*time.Minute)), nil
+	}
+
+	opts := []LocalStoreOption{
+		LocalStoreWithMaxItems(config.LocalMaxItems),
+		LocalStoreWithMaxBytes(config.LocalMaxBytes),
+		LocalStoreWithDefaultExpiration(defaultExpiration),
+		LocalStoreWithSizer(newEncodedSizer(codec)),
+	}
+	if storeType == LocalStoreTinyLFU {
+		store, err := NewTinyLFUStore(opts...)
+		if err != nil {
+			return nil, err
+		}
+		return NewLocalCacheManager(store), nil
+	}
+	return NewLocalCacheManager(NewLRUStore(opts...)), nil
 }
 
-func getLocalCache() *LocalCacheManager {
-	// 获取local cache config
+// initMetrics 关闭指标时替换为不记录的实现，否则记录local cache的淘汰并输出其大小、每个redis连接的熔断状态及热点key数量
+func initMetrics(disabled bool, lc *LocalCacheManager, clusters []*redisCluster, hotKeys *hotKeyDetector) {
+	if disabled {
+		SetMetrics(nil)
+		return
+	}
+	if hooker, ok := lc.store().(evictionHooker); ok {
+		hooker.setEvictionHook(func(key string) {
+			GetMetrics().IncEviction(Local, client.metricsPrefix(key))
+		})
+	}
+	defaultMetricsRegistry.RegisterGaugeFunc("cache_local_items", "Keys in local cache, including expired keys not yet removed.", func() float64 {
+		return float64(lc.Stats().Items)
+	})
+	for _, cluster := range clusters {
+		if breaker := cluster.breaker; breaker != nil {
+			defaultMetricsRegistry.RegisterGaugeFunc("cache_redis_circuit_state", "Redis circuit breaker state, 0 closed, 1 open, 2 half open.", func() float64 {
+				return float64(breaker.getState())
+			}, "cluster", cluster.name)
+		}
+	}
+	if hotKeys != nil {
+		defaultMetricsRegistry.RegisterGaugeFunc("cache_hot_keys", "Keys currently detected as hot and promoted to local cache.", func() float64 {
+			return float64(hotKeys.count())
+		})
+	}
+	// 未限制字节数时不计算每个key的大小
+	if _, ok := lc.store().(localStoreStatser); ok && cacheConfig.GetCacheConfig().LocalMaxBytes > 0 {
+		defaultMetricsRegistry.RegisterGaugeFunc("cache_local_bytes", "Estimated bytes of local cache computed from encoded values.", func() float64 {
+			return float64(lc.Stats().Bytes)
+		})
+	}
+}
+
+func getTieredLocalTimeout() time.Duration {
 	config := cacheConfig.GetCacheConfig()
-	return &LocalCacheManager{
-		cache.New(time.Duration(config.DefaultExpiration)*time.Minute, time.Duration(config.CleanupInterval)*time.Minute),
+	if config.TieredExpiration <= 0 {
+		return defaultTieredLocalTimeout
+	}
+	return time.Duration(config.TieredExpiration) * time.Second
+}
+
+func getCodecConfig() (Codec, CompressType, int, error) {
+	config := cacheConfig.GetCacheConfig()
+	codec, err := GetCodecByName(config.Codec)
+	if err != nil {
+		return nil, CompressNone, 0, err
+	}
+	compressType, err := ParseCompressType(config.Compression)
+	if err != nil {
+		return nil, CompressNone, 0, err
 	}
+	threshold := config.CompressThreshold
+	if threshold <= 0 {
+		threshold = defaultCompressThreshold
+	}
+	return codec, compressType, threshold, nil
 }
 
 func Init() (initErr error) {
 	once.Do(func() {
-		redisClient, err := getRedisConn()
-		initErr = err
-		lc := getLocalCache()
-		client = &cacheManager{
-			redisClient:      redisClient,
-			localCacheClient: lc,
+		if initErr = initClient(getRedisConn); initErr != nil {
+			return
 		}
-		handler = &BaseHandler{
-			client: client,
+		var connected <-chan struct{}
+		mode, _ := ParseRedisConnectMode(cacheConfig.GetCacheConfig().RedisConnectMode)
+		if mode == RedisConnectBackground {
+			connected = client.connectInBackground()
 		}
+		client.startWarmUp(connected)
 	})
 	return
 }
+
+// InitWithClient
+//
+//	@Description: 使用已创建的redis client初始化，忽略RedisConfig中的连接配置，其他配置与Init相同。
+//	可以多次调用，每次调用会关闭之前的实例并替换，调用后Init不再生效，
+//	测试中可配合cachetest包提供的内存redis使用
+//	@param redisClient
+//	@return error
+func InitWithClient(redisClient redis.UniversalClient) error {
+	once.Do(func() {})
+	if client != nil {
+		// redisClient可能与之前的实例共用，只关闭之前实例自己创建的Redlock节点
+		if err := client.close(); err != nil {
+			logger.CtxSugar(context.Background()).Warnf("cache close previous redlock nodes failed|err=%+v", err)
+		}
+	}
+	err := initClient(func() (redis.UniversalClient, error) {
+		return redisClient, nil
+	})
+	if err != nil {
+		return err
+	}
+	client.startWarmUp(nil)
+	return nil
+}
+
+// Close 停止local cache失效同步及锁释放通知的订阅，并关闭所有redis连接，用于服务退出时
+func Close() error {
+	if client == nil {
+		return nil
+	}
+	closeErr := client.close()
+	for _, cluster := range client.getClusters() {
+		if cluster.client == nil {
+			continue
+		}
+		removeCircuitBreaker(cluster.client)
+		if err := cluster.client.Close(); err != nil && closeErr == nil {
+			closeErr = errors.Wrapf(err, "cluster=%s", cluster.name)
+		}
+	}
+	return closeErr
+}
+
+// initClient 配置错误时不初始化，redis连接失败时仍初始化local cache并返回错误，
+// connect只用于创建default连接，RedisClusters中的连接根据配置创建
+func initClient(connect func() (redis.UniversalClient, error)) error {
+	codec, compressType, compressThreshold, err := getCodecConfig()
+	if err != nil {
+		return err
+	}
+	config := cacheConfig.GetCacheConfig()
+	defaultStorage, err := ParseStorage(config.DefaultStorage)
+	if err != nil {
+		return err
+	}
+	lc, err := getLocalCache(codec)
+	if err != nil {
+		return err
+	}
+	mode, err := ParseRedisConnectMode(config.RedisConnectMode)
+	if err != nil {
+		return err
+	}
+	clusterConfigs, clusterRoutes, err := getClusterConfig()
+	if err != nil {
+		return err
+	}
+	ttlRules, err := getTTLRules()
+	if err != nil {
+		return err
+	}
+	keyProvider, err := getKeyProvider()
+	if err != nil {
+		return err
+	}
+	redisClient, redisErr := connect()
+	client = &cacheManager{
+		clusters:      map[string]*redisCluster{DefaultCluster: newRedisCluster(DefaultCluster, redisClient, getCircuitBreaker())},
+		clusterRoutes: clusterRoutes,
+		lockCluster:   config.LockCluster,
+
+		localCacheClient:   lc,
+		codec:              codec,
+		compressType:       compressType,
+		compressThreshold:  compressThreshold,
+		writeValueHeader:   config.WriteValueHeader,
+		tieredLocalTimeout: getTieredLocalTimeout(),
+		ttlRules:           ttlRules,
+		keyProvider:        keyProvider,
+		encryptionPrefixes: config.EncryptionKeyPrefixes,
+
+		keyPrefix:            getKeyPrefix(config.Namespace, config.Version),
+		defaultStorage:       defaultStorage,
+		disableStorageSuffix: config.DisableStorageSuffix,
+
+		redlock: getRedlockStore(),
+
+		degradeToLocal: config.CircuitBreakerDegradeToLocal,
+
+		hotKeys: getHotKeyDetector(),
+	}
+	for _, cc := range clusterConfigs {
+		clusterClient, err := connectCluster(cc, mode)
+		if err != nil && redisErr == nil {
+			redisErr = err
+		}
+		client.clusters[cc.Name] = newRedisCluster(cc.Name, clusterClient, getCircuitBreaker())
+	}
+	setSlowOpThreshold(time.Duration(config.SlowOpThreshold) * time.Millisecond)
+	initMetrics(config.DisableMetrics, lc, client.getClusters(), client.hotKeys)
+	if channel := config.InvalidationChannel; channel != "" && redisClient != nil {
+		client.invalidator = newInvalidator(channel, redisClient, lc)
+		client.invalidator.start()
+	}
+	handler = &BaseHandler{
+		client: client,
+	}
+	return redisErr
+}
+
+// close 停止后台任务及订阅，并关闭Redlock节点的连接，返回关闭Redlock节点时的错误
+func (c *cacheManager) close() error {
+	if c.cancelConnect != nil {
+		c.cancelConnect()
+	}
+	if c.cancelWarmUp != nil {
+		c.cancelWarmUp()
+	}
+	if c.invalidator != nil {
+		c.invalidator.stop()
+	}
+	for _, cluster := range c.clusters {
+		if cluster.lockNotifier != nil {
+			cluster.lockNotifier.close()
+		}
+	}
+	if c.redlock != nil {
+		return c.redlock.close()
+	}
+	return nil
+}
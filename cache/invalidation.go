@@ -0,0 +1,140 @@
+// Package cache @Author  wangjian    2023/8/22 10:14 AM
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	logger "github.com/JianWangEx/commonService/log"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// invalidationMessage 在实例间广播的local cache失效消息
+type invalidationMessage struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys,omitempty"`
+	Flush  bool     `json:"flush,omitempty"`
+}
+
+// invalidator
+//
+//	@Description: 通过redis pub/sub在实例间同步local cache的失效，
+//	任意实例对Local/Tiered storage的写入和删除都会让其他实例删除对应的local key
+type invalidator struct {
+	channel     string
+	instanceID  string
+	redisClient redis.UniversalClient
+	localCache  *LocalCacheManager
+	cancel      context.CancelFunc
+}
+
+func newInvalidator(channel string, redisClient redis.UniversalClient, localCache *LocalCacheManager) *invalidator {
+	return &invalidator{
+		channel:     channel,
+		instanceID:  uuid.NewString(),
+		redisClient: redisClient,
+		localCache:  localCache,
+	}
+}
+
+// publish 广播失效消息，失败只记录日志，不影响本次缓存操作
+func (i *invalidator) publish(ctx context.Context, msg *invalidationMessage) {
+	msg.Origin = i.instanceID
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logger.CtxSugar(ctx).Warnf("cache invalidation marshal failed|msg=%+v, err=%+v", msg, err)
+		return
+	}
+	// 熔断打开时redis不可用，其他实例也无法收到消息
+	if err := i.redisClient.Publish(ctx, i.channel, data).Err(); err != nil && !errors.Is(err, constant.ErrorCircuitOpen) {
+		logger.CtxSugar(ctx).Warnf("cache invalidation publish failed|channel=%s, msg=%+v, err=%+v", i.channel, msg, err)
+	}
+}
+
+func (i *invalidator) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	i.cancel = cancel
+	go i.run(ctx)
+}
+
+func (i *invalidator) stop() {
+	if i.cancel != nil {
+		i.cancel()
+	}
+}
+
+// run 订阅失效消息直到ctx结束，连接断开后go-redis会在下一次Receive时自动重连并重新订阅，
+// 重新订阅成功时可能已经错过了部分消息，因此清空整个local cache
+func (i *invalidator) run(ctx context.Context) {
+	log := logger.CtxSugar(ctx)
+	pubSub := i.redisClient.Subscribe(ctx, i.channel)
+	defer func() {
+		_ = pubSub.Close()
+	}()
+
+	subscribed := false
+	retry := 0
+	for {
+		msg, err := pubSub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warnf("cache invalidation receive failed|channel=%s, retry=%d, err=%+v", i.channel, retry, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(invalidationRetryInterval(retry)):
+			}
+			retry++
+			continue
+		}
+		retry = 0
+
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			if m.Kind != "subscribe" {
+				continue
+			}
+			if subscribed {
+				log.Warnf("cache invalidation resubscribed, flush local cache|channel=%s", i.channel)
+				i.localCache.Flush(ctx)
+			}
+			subscribed = true
+		case *redis.Message:
+			if err := i.handle(ctx, m.Payload); err != nil {
+				log.Warnf("cache invalidation handle failed|payload=%s, err=%+v", m.Payload, err)
+			}
+		}
+	}
+}
+
+func (i *invalidator) handle(ctx context.Context, payload string) error {
+	msg := new(invalidationMessage)
+	if err := json.Unmarshal([]byte(payload), msg); err != nil {
+		return errors.Wrap(err, "invalidation message unmarshal error")
+	}
+	// 忽略本实例发出的消息
+	if msg.Origin == i.instanceID {
+		return nil
+	}
+	if msg.Flush {
+		i.localCache.Flush(ctx)
+		return nil
+	}
+	for _, key := range msg.Keys {
+		i.localCache.Delete(ctx, key)
+	}
+	return nil
+}
+
+func invalidationRetryInterval(retry int) time.Duration {
+	if retry > invalidationMaxRetryShift {
+		retry = invalidationMaxRetryShift
+	}
+	return (1 << retry) * invalidationRetryBaseInterval
+}
@@ -0,0 +1,98 @@
+// Package cache @Author  wangjian    2023/8/22 3:40 PM
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/patrickmn/go-cache"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvalidatorHandle(t *testing.T) {
+	ctx := context.TODO()
+	lc := &LocalCacheManager{Cache: cache.New(time.Minute, time.Minute)}
+	i := newInvalidator("test_channel", nil, lc)
+
+	lc.Set(ctx, "a.local", 1, time.Minute)
+	lc.Set(ctx, "b.local", 2, time.Minute)
+
+	// 本实例发出的消息被忽略
+	payload, _ := json.Marshal(&invalidationMessage{Origin: i.instanceID, Keys: []string{"a.local"}})
+	require.NoError(t, i.handle(ctx, string(payload)))
+	_, found := lc.Get(ctx, "a.local")
+	assert.True(t, found)
+
+	payload, _ = json.Marshal(&invalidationMessage{Origin: "other", Keys: []string{"a.local"}})
+	require.NoError(t, i.handle(ctx, string(payload)))
+	_, found = lc.Get(ctx, "a.local")
+	assert.False(t, found)
+	_, found = lc.Get(ctx, "b.local")
+	assert.True(t, found)
+
+	payload, _ = json.Marshal(&invalidationMessage{Origin: "other", Flush: true})
+	require.NoError(t, i.handle(ctx, string(payload)))
+	_, found = lc.Get(ctx, "b.local")
+	assert.False(t, found)
+
+	assert.Error(t, i.handle(ctx, "not json"))
+}
+
+// startTestInvalidator 订阅成功后返回，stop及关闭redis client在测试结束时执行
+func startTestInvalidator(t *testing.T, server *miniredis.Miniredis, channel string, subscribers int) (*invalidator, *LocalCacheManager) {
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	lc := &LocalCacheManager{Cache: cache.New(time.Minute, time.Minute)}
+	i := newInvalidator(channel, rdb, lc)
+	i.start()
+	t.Cleanup(func() {
+		i.stop()
+		_ = rdb.Close()
+	})
+	require.Eventually(t, func() bool {
+		return server.PubSubNumSub(channel)[channel] == subscribers
+	}, time.Second, 10*time.Millisecond)
+	return i, lc
+}
+
+func TestInvalidatorPubSub(t *testing.T) {
+	server := miniredis.RunT(t)
+	ctx := context.TODO()
+	a, lcA := startTestInvalidator(t, server, "test_channel", 1)
+	_, lcB := startTestInvalidator(t, server, "test_channel", 2)
+
+	lcA.Set(ctx, "a.local", 1, time.Minute)
+	lcB.Set(ctx, "a.local", 1, time.Minute)
+	lcB.Set(ctx, "b.local", 2, time.Minute)
+
+	a.publish(ctx, &invalidationMessage{Keys: []string{"a.local"}})
+	assert.Eventually(t, func() bool {
+		_, found := lcB.Get(ctx, "a.local")
+		return !found
+	}, time.Second, 10*time.Millisecond)
+	_, found := lcB.Get(ctx, "b.local")
+	assert.True(t, found)
+	// 发出消息的实例不处理自己的消息
+	_, found = lcA.Get(ctx, "a.local")
+	assert.True(t, found)
+}
+
+func TestInvalidatorResubscribeFlush(t *testing.T) {
+	server := miniredis.RunT(t)
+	ctx := context.TODO()
+	_, lc := startTestInvalidator(t, server, "test_channel", 1)
+	lc.Set(ctx, "a.local", 1, time.Minute)
+
+	// 连接断开期间可能错过消息，重新订阅后清空local cache
+	server.Close()
+	require.NoError(t, server.Restart())
+	assert.Eventually(t, func() bool {
+		_, found := lc.Get(ctx, "a.local")
+		return !found
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, 1, server.PubSubNumSub("test_channel")["test_channel"])
+}
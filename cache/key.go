@@ -0,0 +1,143 @@
+// Package cache @Author  wangjian    2023/9/4 10:20 AM
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// ParseStorage 根据名称获取storage，名称不区分大小写，空字符串返回Main
+func ParseStorage(name string) (Storage, error) {
+	for _, s := range []Storage{Main, Local, Tiered} {
+		if strings.EqualFold(s.name(), name) {
+			return s, nil
+		}
+	}
+	if name == "" {
+		return Main, nil
+	}
+	return Main, errors.Wrapf(constant.ErrorUnknownStorage, "storage_name=%s", name)
+}
+
+// getKeyPrefix namespace和version组成所有key的前缀，如"order:v2:"，都为空时不添加前缀
+func getKeyPrefix(namespace, version string) string {
+	parts := make([]string, 0, 2)
+	for _, part := range []string{namespace, version} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return strings.Join(parts, keySeparator) + keySeparator
+}
+
+// buildKey 为key添加namespace前缀，调用方传入的key都需要经过该方法后再访问storage
+func (c *cacheManager) buildKey(key string) string {
+	return c.keyPrefix + key
+}
+
+// getRuleKey key为添加namespace后的key，返回匹配TTLRules及EncryptionKeyPrefixes使用的key
+func (c *cacheManager) getRuleKey(key string, opt *callOption) string {
+	return opt.ruleKey(strings.TrimPrefix(key, c.keyPrefix))
+}
+
+// BuildKey
+//
+//	@Description: 为key添加配置的namespace前缀，直接使用GetRedisClient访问redis时使用，
+//	使其与Client写入的key处于同一个命名空间，未Init时原样返回
+//	@param key
+//	@return string
+func BuildKey(key string) string {
+	if client == nil {
+		return key
+	}
+	return client.buildKey(key)
+}
+
+// escapeKeyPattern 转义SCAN MATCH中的通配符，避免namespace中的特殊字符匹配到其他key
+func escapeKeyPattern(s string) string {
+	var builder strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			builder.WriteRune('\\')
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// unlinkByPrefix
+//
+//	@Description: 通过SCAN遍历prefix下的所有key并分批UNLINK，集群模式下需要遍历每个master节点，
+//	锁的fencing计数器不会被删除，否则之后获得的token会从1重新开始，破坏token的单调递增
+//	@param ctx
+//	@param rdb
+//	@param prefix
+//	@return int64 删除的key数量
+//	@return error
+func (c *cacheManager) unlinkByPrefix(ctx context.Context, rdb redis.UniversalClient, prefix string) (int64, error) {
+	match := escapeKeyPattern(prefix) + "*"
+	if cluster, ok := rdb.(*redis.ClusterClient); ok {
+		var total int64
+		err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			n, err := scanAndUnlink(ctx, node, match)
+			atomic.AddInt64(&total, n)
+			return err
+		})
+		return total, err
+	}
+	return scanAndUnlink(ctx, rdb, match)
+}
+
+// skipFencingKeys 原地过滤掉锁的fencing计数器
+func skipFencingKeys(keys []string) []string {
+	filtered := keys[:0]
+	for _, key := range keys {
+		if !isFencingKey(key) {
+			filtered = append(filtered, key)
+		}
+	}
+	return filtered
+}
+
+func scanAndUnlink(ctx context.Context, rdb redis.Cmdable, match string) (int64, error) {
+	var (
+		cursor uint64
+		total  int64
+	)
+	for {
+		keys, next, err := rdb.Scan(ctx, cursor, match, flushScanCount).Result()
+		if err != nil {
+			return total, errors.Wrap(err, "redis cache scan error")
+		}
+		keys = skipFencingKeys(keys)
+		if len(keys) > 0 {
+			// 集群模式下同一节点上的key也可能不在同一个slot，逐个UNLINK
+			cmds := make([]*redis.IntCmd, 0, len(keys))
+			_, err = rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				for _, key := range keys {
+					cmds = append(cmds, pipe.Unlink(ctx, key))
+				}
+				return nil
+			})
+			for _, cmd := range cmds {
+				total += cmd.Val()
+			}
+			if err != nil {
+				return total, errors.Wrap(err, "redis cache unlink error")
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return total, nil
+		}
+	}
+}
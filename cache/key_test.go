@@ -0,0 +1,90 @@
+// Package cache @Author  wangjian    2023/9/4 3:10 PM
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetKeyPrefix(t *testing.T) {
+	assert.Equal(t, "", getKeyPrefix("", ""))
+	assert.Equal(t, "order:", getKeyPrefix("order", ""))
+	assert.Equal(t, "v2:", getKeyPrefix("", "v2"))
+	assert.Equal(t, "order:v2:", getKeyPrefix("order", "v2"))
+}
+
+func TestEscapeKeyPattern(t *testing.T) {
+	assert.Equal(t, "order:", escapeKeyPattern("order:"))
+	assert.Equal(t, `a\*b\?c\[d\]\\`, escapeKeyPattern(`a*b?c[d]\`))
+}
+
+func TestParseStorage(t *testing.T) {
+	s, err := ParseStorage("")
+	require.NoError(t, err)
+	assert.Equal(t, Main, s)
+	s, err = ParseStorage("Tiered")
+	require.NoError(t, err)
+	assert.Equal(t, Tiered, s)
+	_, err = ParseStorage("disk")
+	assert.ErrorIs(t, err, constant.ErrorUnknownStorage)
+}
+
+func TestCallOptionGetStorage(t *testing.T) {
+	opt := &callOption{}
+	assert.Equal(t, Main, opt.getStorage("user"))
+	assert.Equal(t, Local, opt.getStorage("user.local"))
+
+	opt = &callOption{defaultStorage: Tiered}
+	assert.Equal(t, Tiered, opt.getStorage("user"))
+	assert.Equal(t, Local, opt.getStorage("user.local"))
+
+	opt = &callOption{defaultStorage: Tiered, disableStorageSuffix: true}
+	assert.Equal(t, Tiered, opt.getStorage("user.local"))
+
+	opt = &callOption{storage: Main, defaultStorage: Tiered}
+	assert.Equal(t, Main, opt.getStorage("user.local"))
+}
+
+func TestNamespacedKeys(t *testing.T) {
+	server := newTestManager(t)
+	client.keyPrefix = getKeyPrefix("order", "v2")
+	ctx := context.TODO()
+
+	require.NoError(t, client.Set(ctx, "user", "cat", time.Minute))
+	assert.True(t, server.Exists("order:v2:user"))
+	assert.False(t, server.Exists("user"))
+	require.NoError(t, client.Set(ctx, "user.local", "cat", time.Minute))
+	_, found := client.localCacheClient.Get(ctx, "order:v2:user.local")
+	assert.True(t, found)
+
+	var name string
+	require.NoError(t, client.Get(ctx, "user", &name))
+	assert.Equal(t, "cat", name)
+
+	require.NoError(t, client.MSet(ctx, map[string]interface{}{"a": "1", "b": "2"}, time.Minute))
+	values := make(map[string]string)
+	require.NoError(t, client.MGet(ctx, []string{"a", "b", "c"}, &values))
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, values)
+
+	require.NoError(t, client.MDelete(ctx, []string{"a"}))
+	assert.False(t, server.Exists("order:v2:a"))
+	assert.True(t, server.Exists("order:v2:b"))
+}
+
+func TestFlushCacheWithoutNamespace(t *testing.T) {
+	server := newTestManager(t)
+	ctx := context.TODO()
+
+	require.NoError(t, client.Set(ctx, "user.local", "cat", time.Minute))
+	require.NoError(t, client.Set(ctx, "user", "cat", time.Minute))
+	_, err := client.FlushCache(ctx)
+	assert.Equal(t, constant.ErrorFlushWithoutNamespace, err)
+	// local cache只属于本服务，仍然清空
+	assert.Equal(t, 0, client.localCacheClient.Stats().Items)
+	assert.True(t, server.Exists("user"))
+}
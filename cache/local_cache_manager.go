@@ -3,26 +3,79 @@ package cache
 
 import (
 	"context"
-	"github.com/patrickmn/go-cache"
 	"time"
+
+	"github.com/patrickmn/go-cache"
 )
 
 type LocalCacheManager struct {
+	// 不限制大小的go-cache，Store为nil时使用
 	Cache *cache.Cache
+	// 配置LocalStoreType或容量后为限制大小的store，不为nil时优先于Cache
+	Store LocalStore
+}
+
+// NewLocalCacheManager store为*cache.Cache时设置Cache，否则设置Store
+func NewLocalCacheManager(store LocalStore) *LocalCacheManager {
+	if c, ok := store.(*cache.Cache); ok {
+		return &LocalCacheManager{Cache: c}
+	}
+	return &LocalCacheManager{Store: store}
+}
+
+func (c *LocalCacheManager) store() LocalStore {
+	if c.Store != nil {
+		return c.Store
+	}
+	return c.Cache
 }
 
 func (c *LocalCacheManager) Get(ctx context.Context, key string) (interface{}, bool) {
-	return c.Cache.Get(key)
+	return c.store().Get(key)
 }
 
 func (c *LocalCacheManager) Set(ctx context.Context, key string, value interface{}, d time.Duration) {
-	c.Cache.Set(key, value, d)
+	c.store().Set(key, value, d)
 }
 
 func (c *LocalCacheManager) Delete(ctx context.Context, key string) {
-	c.Cache.Delete(key)
+	c.store().Delete(key)
 }
 
 func (c *LocalCacheManager) Add(ctx context.Context, key string, value interface{}, d time.Duration) error {
-	return c.Cache.Add(key, value, d)
+	return c.store().Add(key, value, d)
+}
+
+func (c *LocalCacheManager) Flush(ctx context.Context) {
+	c.store().Flush()
+}
+
+// OnEvicted 设置key过期、被删除或被淘汰时的回调，回调中不能再调用local cache的写操作
+func (c *LocalCacheManager) OnEvicted(f func(key string, value interface{})) {
+	c.store().OnEvicted(f)
+}
+
+// Stats 获取local cache的统计信息，不支持统计的store只返回key数量
+func (c *LocalCacheManager) Stats() LocalStoreStats {
+	store := c.store()
+	if s, ok := store.(localStoreStatser); ok {
+		return s.Stats()
+	}
+	return LocalStoreStats{Items: store.ItemCount()}
+}
+
+// GetLocalCacheStats 获取全局local cache的统计信息，未Init时返回空
+func GetLocalCacheStats() LocalStoreStats {
+	if client == nil || client.localCacheClient == nil {
+		return LocalStoreStats{}
+	}
+	return client.localCacheClient.Stats()
+}
+
+// OnLocalCacheEvicted 设置全局local cache的淘汰回调，需要在Init之后调用
+func OnLocalCacheEvicted(f func(key string, value interface{})) {
+	if client == nil || client.localCacheClient == nil {
+		return
+	}
+	client.localCacheClient.OnEvicted(f)
 }
@@ -0,0 +1,408 @@
+// Package cache @Author  wangjian    2023/7/24 10:15 AM
+package cache
+
+import (
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/pkg/errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LocalStoreType string
+
+const (
+	// LocalStoreUnbounded 使用go-cache，不限制大小
+	LocalStoreUnbounded LocalStoreType = ""
+	// LocalStoreLRU 超出容量时淘汰最近最少访问的key
+	LocalStoreLRU LocalStoreType = "lru"
+	// LocalStoreTinyLFU 使用W-TinyLFU淘汰，新key需要比被淘汰的key访问更频繁才能进入主区域
+	LocalStoreTinyLFU LocalStoreType = "tinylfu"
+)
+
+// ParseLocalStoreType 根据配置中的名称获取local store类型，名称为空时不限制大小
+func ParseLocalStoreType(name string) (LocalStoreType, error) {
+	for _, t := range []LocalStoreType{LocalStoreUnbounded, LocalStoreLRU, LocalStoreTinyLFU} {
+		if strings.EqualFold(string(t), name) {
+			return t, nil
+		}
+	}
+	return LocalStoreUnbounded, errors.Wrapf(constant.ErrorUnknownLocalStore, "local_store_type=%s", name)
+}
+
+// LocalStore local cache的底层存储，*cache.Cache(go-cache)满足该接口
+// @Description: d为0时使用默认过期时间，小于0时不过期；
+// OnEvicted设置的回调在key过期、被删除或因容量不足被淘汰时调用
+type LocalStore interface {
+	Get(k string) (interface{}, bool)
+	Set(k string, x interface{}, d time.Duration)
+	Add(k string, x interface{}, d time.Duration) error
+	Delete(k string)
+	Flush()
+	ItemCount() int
+	OnEvicted(f func(string, interface{}))
+}
+
+// LocalStoreStats local store的统计信息，不支持统计的store只有Items
+type LocalStoreStats struct {
+	Items int
+	// 按编码后大小估算的占用字节数，未设置LocalStoreWithMaxBytes时不计算，为0
+	Bytes       int64
+	Hits        uint64
+	Misses      uint64
+	Sets        uint64
+	Evictions   uint64 // 因容量不足被淘汰的数量
+	Expirations uint64 // 过期后被删除的数量
+}
+
+type localStoreStatser interface {
+	Stats() LocalStoreStats
+}
+
+// evictionHooker 因容量不足淘汰key时回调，与OnEvicted不同，过期及删除时不回调，用于记录指标
+type evictionHooker interface {
+	setEvictionHook(f func(key string))
+}
+
+type localStoreOption struct {
+	maxItems          int
+	maxBytes          int64
+	defaultExpiration time.Duration
+	sizer             func(key string, value interface{}) int64
+}
+
+type LocalStoreOption func(o *localStoreOption)
+
+// LocalStoreWithMaxItems 最多保存的key数量，0表示不限制
+func LocalStoreWithMaxItems(n int) LocalStoreOption {
+	return func(o *localStoreOption) {
+		o.maxItems = n
+	}
+}
+
+// LocalStoreWithMaxBytes 最多占用的字节数，0表示不限制
+func LocalStoreWithMaxBytes(n int64) LocalStoreOption {
+	return func(o *localStoreOption) {
+		o.maxBytes = n
+	}
+}
+
+// LocalStoreWithDefaultExpiration Set时d为0使用的过期时间，默认不过期
+func LocalStoreWithDefaultExpiration(d time.Duration) LocalStoreOption {
+	return func(o *localStoreOption) {
+		o.defaultExpiration = d
+	}
+}
+
+// LocalStoreWithSizer 计算每个key占用的字节数，默认为key的长度加上值使用JSON编码后的长度，
+// 只在设置了LocalStoreWithMaxBytes时使用
+func LocalStoreWithSizer(sizer func(key string, value interface{}) int64) LocalStoreOption {
+	return func(o *localStoreOption) {
+		o.sizer = sizer
+	}
+}
+
+// NewLRUStore 创建按LRU淘汰的local store
+func NewLRUStore(opts ...LocalStoreOption) LocalStore {
+	o := newLocalStoreOption(opts)
+	return newBoundedStore(o, newLRUPolicy(o.maxItems))
+}
+
+// NewTinyLFUStore 创建按W-TinyLFU淘汰的local store，maxItems需要大于0，用于划分各区域的大小
+func NewTinyLFUStore(opts ...LocalStoreOption) (LocalStore, error) {
+	o := newLocalStoreOption(opts)
+	if o.maxItems <= 0 {
+		return nil, errors.Wrap(constant.ErrorInvalidLocalStoreCapacity, "tinylfu requires max items")
+	}
+	return newBoundedStore(o, newTinyLFUPolicy(o.maxItems)), nil
+}
+
+func newLocalStoreOption(opts []LocalStoreOption) *localStoreOption {
+	o := &localStoreOption{
+		sizer: newEncodedSizer(JSONCodec{}),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// newEncodedSizer 按codec编码后的长度估算占用的字节数，无法编码的值只计算key及固定开销
+func newEncodedSizer(codec Codec) func(key string, value interface{}) int64 {
+	return func(key string, value interface{}) int64 {
+		size := int64(len(key)) + localStoreEntryOverhead
+		if entry, ok := value.(*localEntry); ok {
+			value = entry.value
+		}
+		if value == nil {
+			return size
+		}
+		if data, err := codec.Marshal(value); err == nil {
+			size += int64(len(data))
+		}
+		return size
+	}
+}
+
+type storeEntry struct {
+	key      string
+	value    interface{}
+	expireAt int64 // unix nano，0表示不过期
+	size     int64
+
+	// 由淘汰策略维护
+	prev, next *storeEntry
+	region     int
+}
+
+func (e *storeEntry) expired(now int64) bool {
+	return e.expireAt > 0 && now > e.expireAt
+}
+
+// evictionPolicy 决定key的淘汰顺序，所有方法都在boundedStore的锁内调用
+type evictionPolicy interface {
+	// onInsert 加入新key，返回因数量超限需要淘汰的key
+	onInsert(e *storeEntry) []*storeEntry
+	onAccess(e *storeEntry)
+	onRemove(e *storeEntry)
+	// victim 超出字节数限制时下一个淘汰的key
+	victim() *storeEntry
+}
+
+// boundedStore 限制数量及字节数的local store，淘汰顺序由evictionPolicy决定
+// @Description: 过期的key在访问时或被淘汰时删除，不启动后台清理的goroutine
+type boundedStore struct {
+	mu        sync.Mutex
+	items     map[string]*storeEntry
+	policy    evictionPolicy
+	opt       *localStoreOption
+	bytes     int64
+	stats     LocalStoreStats
+	onEvicted func(string, interface{})
+
+	evictionHook func(key string)
+}
+
+func newBoundedStore(o *localStoreOption, policy evictionPolicy) *boundedStore {
+	return &boundedStore{
+		items:  make(map[string]*storeEntry),
+		policy: policy,
+		opt:    o,
+	}
+}
+
+func (s *boundedStore) Get(k string) (interface{}, bool) {
+	s.mu.Lock()
+	e, ok := s.items[k]
+	if !ok {
+		s.stats.Misses++
+		s.mu.Unlock()
+		return nil, false
+	}
+	if e.expired(time.Now().UnixNano()) {
+		s.remove(e)
+		s.stats.Expirations++
+		s.stats.Misses++
+		f := s.onEvicted
+		s.mu.Unlock()
+		if f != nil {
+			f(e.key, e.value)
+		}
+		return nil, false
+	}
+	s.policy.onAccess(e)
+	s.stats.Hits++
+	s.mu.Unlock()
+	return e.value, true
+}
+
+// size 未限制字节数时不需要编码计算大小
+func (s *boundedStore) size(k string, x interface{}) int64 {
+	if s.opt.maxBytes <= 0 {
+		return 0
+	}
+	return s.opt.sizer(k, x)
+}
+
+func (s *boundedStore) Set(k string, x interface{}, d time.Duration) {
+	size := s.size(k, x)
+	s.mu.Lock()
+	evicted := s.set(k, x, d, size)
+	s.mu.Unlock()
+	s.notify(evicted)
+}
+
+func (s *boundedStore) Add(k string, x interface{}, d time.Duration) error {
+	size := s.size(k, x)
+	s.mu.Lock()
+	if e, ok := s.items[k]; ok && !e.expired(time.Now().UnixNano()) {
+		s.mu.Unlock()
+		return errors.Wrapf(constant.ErrorLocalCacheKeyExists, "key: %s", k)
+	}
+	evicted := s.set(k, x, d, size)
+	s.mu.Unlock()
+	s.notify(evicted)
+	return nil
+}
+
+func (s *boundedStore) Delete(k string) {
+	s.mu.Lock()
+	e, ok := s.items[k]
+	if ok {
+		s.remove(e)
+	}
+	f := s.onEvicted
+	s.mu.Unlock()
+	if ok && f != nil {
+		f(e.key, e.value)
+	}
+}
+
+func (s *boundedStore) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.items {
+		s.policy.onRemove(e)
+	}
+	s.items = make(map[string]*storeEntry)
+	s.bytes = 0
+}
+
+func (s *boundedStore) ItemCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+func (s *boundedStore) OnEvicted(f func(string, interface{})) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onEvicted = f
+}
+
+func (s *boundedStore) setEvictionHook(f func(key string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evictionHook = f
+}
+
+func (s *boundedStore) Stats() LocalStoreStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Items = len(s.items)
+	stats.Bytes = s.bytes
+	return stats
+}
+
+// set 写入key并淘汰超出限制的key，返回被淘汰的key，由调用方在锁外回调
+// @Description: 单个key超过字节数限制时也会被淘汰，即不会被缓存
+func (s *boundedStore) set(k string, x interface{}, d time.Duration, size int64) []*storeEntry {
+	if d == 0 {
+		d = s.opt.defaultExpiration
+	}
+	var expireAt int64
+	if d > 0 {
+		expireAt = time.Now().Add(d).UnixNano()
+	}
+	s.stats.Sets++
+
+	var evicted []*storeEntry
+	if e, ok := s.items[k]; ok {
+		s.bytes += size - e.size
+		e.value, e.expireAt, e.size = x, expireAt, size
+		s.policy.onAccess(e)
+	} else {
+		e = &storeEntry{key: k, value: x, expireAt: expireAt, size: size}
+		s.items[k] = e
+		s.bytes += size
+		for _, victim := range s.policy.onInsert(e) {
+			evicted = append(evicted, s.evict(victim))
+		}
+	}
+	for s.opt.maxBytes > 0 && s.bytes > s.opt.maxBytes {
+		victim := s.policy.victim()
+		if victim == nil {
+			break
+		}
+		s.policy.onRemove(victim)
+		evicted = append(evicted, s.evict(victim))
+	}
+	return evicted
+}
+
+// evict 删除已被淘汰策略移除的key
+func (s *boundedStore) evict(e *storeEntry) *storeEntry {
+	delete(s.items, e.key)
+	s.bytes -= e.size
+	if e.expired(time.Now().UnixNano()) {
+		s.stats.Expirations++
+	} else {
+		s.stats.Evictions++
+	}
+	return e
+}
+
+func (s *boundedStore) remove(e *storeEntry) {
+	s.policy.onRemove(e)
+	delete(s.items, e.key)
+	s.bytes -= e.size
+}
+
+func (s *boundedStore) notify(evicted []*storeEntry) {
+	if len(evicted) == 0 {
+		return
+	}
+	s.mu.Lock()
+	f, hook := s.onEvicted, s.evictionHook
+	s.mu.Unlock()
+	now := time.Now().UnixNano()
+	for _, e := range evicted {
+		if hook != nil && !e.expired(now) {
+			hook(e.key)
+		}
+		if f != nil {
+			f(e.key, e.value)
+		}
+	}
+}
+
+// entryList 侵入式双向链表，front为最近访问的key
+type entryList struct {
+	root storeEntry
+	len  int
+}
+
+func (l *entryList) init() *entryList {
+	l.root.next = &l.root
+	l.root.prev = &l.root
+	l.len = 0
+	return l
+}
+
+func (l *entryList) pushFront(e *storeEntry) {
+	e.prev = &l.root
+	e.next = l.root.next
+	l.root.next.prev = e
+	l.root.next = e
+	l.len++
+}
+
+func (l *entryList) remove(e *storeEntry) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.prev, e.next = nil, nil
+	l.len--
+}
+
+func (l *entryList) moveToFront(e *storeEntry) {
+	l.remove(e)
+	l.pushFront(e)
+}
+
+func (l *entryList) back() *storeEntry {
+	if l.len == 0 {
+		return nil
+	}
+	return l.root.prev
+}
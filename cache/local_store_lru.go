@@ -0,0 +1,37 @@
+// Package cache @Author  wangjian    2023/7/24 11:02 AM
+package cache
+
+// lruPolicy 超出数量限制时淘汰最近最少访问的key
+type lruPolicy struct {
+	maxItems int
+	list     *entryList
+}
+
+func newLRUPolicy(maxItems int) *lruPolicy {
+	return &lruPolicy{
+		maxItems: maxItems,
+		list:     new(entryList).init(),
+	}
+}
+
+func (p *lruPolicy) onInsert(e *storeEntry) []*storeEntry {
+	p.list.pushFront(e)
+	if p.maxItems <= 0 || p.list.len <= p.maxItems {
+		return nil
+	}
+	victim := p.list.back()
+	p.list.remove(victim)
+	return []*storeEntry{victim}
+}
+
+func (p *lruPolicy) onAccess(e *storeEntry) {
+	p.list.moveToFront(e)
+}
+
+func (p *lruPolicy) onRemove(e *storeEntry) {
+	p.list.remove(e)
+}
+
+func (p *lruPolicy) victim() *storeEntry {
+	return p.list.back()
+}
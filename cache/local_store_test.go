@@ -0,0 +1,160 @@
+// Package cache @Author  wangjian    2023/7/25 10:40 AM
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLocalStoreType(t *testing.T) {
+	tp, err := ParseLocalStoreType("")
+	require.NoError(t, err)
+	assert.Equal(t, LocalStoreUnbounded, tp)
+	tp, err = ParseLocalStoreType("TinyLFU")
+	require.NoError(t, err)
+	assert.Equal(t, LocalStoreTinyLFU, tp)
+	_, err = ParseLocalStoreType("arc")
+	assert.ErrorIs(t, err, constant.ErrorUnknownLocalStore)
+}
+
+func TestLRUStoreMaxItems(t *testing.T) {
+	store := NewLRUStore(LocalStoreWithMaxItems(2))
+	var evicted []string
+	store.OnEvicted(func(key string, _ interface{}) {
+		evicted = append(evicted, key)
+	})
+
+	store.Set("a", 1, 0)
+	store.Set("b", 2, 0)
+	_, ok := store.Get("a")
+	require.True(t, ok)
+	store.Set("c", 3, 0)
+
+	_, ok = store.Get("b")
+	assert.False(t, ok)
+	_, ok = store.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"b"}, evicted)
+	assert.Equal(t, 2, store.ItemCount())
+
+	stats := store.(localStoreStatser).Stats()
+	assert.Equal(t, uint64(1), stats.Evictions)
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(3), stats.Sets)
+}
+
+func TestLRUStoreMaxBytes(t *testing.T) {
+	store := NewLRUStore(
+		LocalStoreWithMaxBytes(20),
+		LocalStoreWithSizer(func(key string, value interface{}) int64 {
+			return int64(len(value.(string)))
+		}),
+	)
+	store.Set("a", "0123456789", 0)
+	store.Set("b", "0123456789", 0)
+	store.Set("c", "01234", 0)
+
+	_, ok := store.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, int64(15), store.(localStoreStatser).Stats().Bytes)
+
+	// 单个值超过限制时不缓存
+	store.Set("d", "012345678901234567890", 0)
+	_, ok = store.Get("d")
+	assert.False(t, ok)
+	assert.LessOrEqual(t, store.(localStoreStatser).Stats().Bytes, int64(20))
+}
+
+func TestLRUStoreExpiration(t *testing.T) {
+	store := NewLRUStore(LocalStoreWithDefaultExpiration(20 * time.Millisecond))
+	store.Set("default", 1, 0)
+	store.Set("forever", 1, -1)
+	require.Error(t, store.Add("default", 2, 0))
+	assert.ErrorIs(t, store.Add("forever", 2, 0), constant.ErrorLocalCacheKeyExists)
+
+	time.Sleep(30 * time.Millisecond)
+	_, ok := store.Get("default")
+	assert.False(t, ok)
+	_, ok = store.Get("forever")
+	assert.True(t, ok)
+	assert.NoError(t, store.Add("default", 2, 0))
+	assert.Equal(t, uint64(1), store.(localStoreStatser).Stats().Expirations)
+}
+
+func TestTinyLFUStoreKeepsHotKeys(t *testing.T) {
+	_, err := NewTinyLFUStore()
+	assert.ErrorIs(t, err, constant.ErrorInvalidLocalStoreCapacity)
+
+	store, err := NewTinyLFUStore(LocalStoreWithMaxItems(100))
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		store.Set(fmt.Sprintf("hot_%d", i), i, 0)
+	}
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 50; i++ {
+			store.Get(fmt.Sprintf("hot_%d", i))
+		}
+	}
+	// 大量只访问一次的key不会挤出热点key
+	for i := 0; i < 1000; i++ {
+		store.Set(fmt.Sprintf("scan_%d", i), i, 0)
+	}
+
+	hits := 0
+	for i := 0; i < 50; i++ {
+		if _, ok := store.Get(fmt.Sprintf("hot_%d", i)); ok {
+			hits++
+		}
+	}
+	assert.Equal(t, 50, hits)
+	assert.LessOrEqual(t, store.ItemCount(), 100)
+}
+
+func TestLocalCacheManagerStats(t *testing.T) {
+	ctx := context.Background()
+	lc := NewLocalCacheManager(cache.New(time.Minute, time.Minute))
+	lc.Set(ctx, "a", 1, 0)
+	assert.Equal(t, LocalStoreStats{Items: 1}, lc.Stats())
+
+	lc = NewLocalCacheManager(NewLRUStore(LocalStoreWithMaxItems(10), LocalStoreWithMaxBytes(1024)))
+	lc.Set(ctx, "a", 1, 0)
+	_, ok := lc.Get(ctx, "a")
+	require.True(t, ok)
+	stats := lc.Stats()
+	assert.Equal(t, 1, stats.Items)
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, int64(len("a")+1+localStoreEntryOverhead), stats.Bytes)
+}
+
+func TestLRUStoreWithoutMaxBytes(t *testing.T) {
+	sized := 0
+	store := NewLRUStore(LocalStoreWithMaxItems(10), LocalStoreWithSizer(func(key string, value interface{}) int64 {
+		sized++
+		return 1
+	}))
+	store.Set("a", 1, 0)
+	require.NoError(t, store.Add("b", 2, 0))
+	assert.Zero(t, sized)
+	assert.Zero(t, store.(localStoreStatser).Stats().Bytes)
+}
+
+func TestLocalCacheManagerCompatible(t *testing.T) {
+	ctx := context.Background()
+	// 直接设置Cache字段的调用方继续使用go-cache
+	lc := &LocalCacheManager{Cache: cache.New(time.Minute, time.Minute)}
+	lc.Set(ctx, "a", 1, 0)
+	_, ok := lc.Cache.Get("a")
+	assert.True(t, ok)
+
+	lc = NewLocalCacheManager(cache.New(time.Minute, time.Minute))
+	assert.NotNil(t, lc.Cache)
+	assert.Nil(t, lc.Store)
+}
@@ -0,0 +1,201 @@
+// Package cache @Author  wangjian    2023/7/24 2:36 PM
+package cache
+
+import (
+	"hash/fnv"
+)
+
+const (
+	tinyLFUWindow = iota
+	tinyLFUProbation
+	tinyLFUProtected
+)
+
+const (
+	// window区域占总容量的百分比，protected区域占主区域的百分比
+	tinyLFUWindowPercent    = 1
+	tinyLFUProtectedPercent = 80
+	// 访问次数达到容量的该倍数时计数减半，使旧的热点逐渐失效
+	tinyLFUSampleFactor = 10
+	tinyLFUSketchDepth  = 4
+	// sketch每行的计数数量为容量的该倍数，减少hash冲突导致的高估
+	tinyLFUSketchWidthFactor = 4
+	tinyLFUMaxFrequency      = 15
+)
+
+// tinyLFUPolicy W-TinyLFU淘汰策略
+// @Description: 新key先进入LRU的window区域，被挤出window后与主区域(SLRU)中即将被淘汰的key比较访问频率，
+// 频率更高的一方留下。主区域分为probation和protected，probation中的key再次被访问后进入protected。
+// 访问频率由count-min sketch估算，一次性访问的大量key不会挤出热点key
+type tinyLFUPolicy struct {
+	windowCap    int
+	mainCap      int
+	protectedCap int
+
+	window    *entryList
+	probation *entryList
+	protected *entryList
+	sketch    *countMinSketch
+}
+
+func newTinyLFUPolicy(maxItems int) *tinyLFUPolicy {
+	windowCap := maxItems * tinyLFUWindowPercent / 100
+	if windowCap < 1 {
+		windowCap = 1
+	}
+	mainCap := maxItems - windowCap
+	return &tinyLFUPolicy{
+		windowCap:    windowCap,
+		mainCap:      mainCap,
+		protectedCap: mainCap * tinyLFUProtectedPercent / 100,
+		window:       new(entryList).init(),
+		probation:    new(entryList).init(),
+		protected:    new(entryList).init(),
+		sketch:       newCountMinSketch(maxItems),
+	}
+}
+
+func (p *tinyLFUPolicy) onInsert(e *storeEntry) []*storeEntry {
+	p.sketch.increment(e.key)
+	e.region = tinyLFUWindow
+	p.window.pushFront(e)
+	if p.window.len <= p.windowCap {
+		return nil
+	}
+
+	candidate := p.window.back()
+	p.window.remove(candidate)
+	if p.probation.len+p.protected.len < p.mainCap {
+		p.pushProbation(candidate)
+		return nil
+	}
+	victim := p.mainVictim()
+	if victim == nil || p.sketch.estimate(candidate.key) <= p.sketch.estimate(victim.key) {
+		return []*storeEntry{candidate}
+	}
+	p.onRemove(victim)
+	p.pushProbation(candidate)
+	return []*storeEntry{victim}
+}
+
+func (p *tinyLFUPolicy) onAccess(e *storeEntry) {
+	p.sketch.increment(e.key)
+	switch e.region {
+	case tinyLFUWindow:
+		p.window.moveToFront(e)
+	case tinyLFUProbation:
+		p.probation.remove(e)
+		e.region = tinyLFUProtected
+		p.protected.pushFront(e)
+		if p.protected.len > p.protectedCap {
+			demoted := p.protected.back()
+			p.protected.remove(demoted)
+			p.pushProbation(demoted)
+		}
+	case tinyLFUProtected:
+		p.protected.moveToFront(e)
+	}
+}
+
+func (p *tinyLFUPolicy) onRemove(e *storeEntry) {
+	p.list(e.region).remove(e)
+}
+
+func (p *tinyLFUPolicy) victim() *storeEntry {
+	if e := p.mainVictim(); e != nil {
+		return e
+	}
+	return p.window.back()
+}
+
+func (p *tinyLFUPolicy) mainVictim() *storeEntry {
+	if e := p.probation.back(); e != nil {
+		return e
+	}
+	return p.protected.back()
+}
+
+func (p *tinyLFUPolicy) pushProbation(e *storeEntry) {
+	e.region = tinyLFUProbation
+	p.probation.pushFront(e)
+}
+
+func (p *tinyLFUPolicy) list(region int) *entryList {
+	switch region {
+	case tinyLFUProbation:
+		return p.probation
+	case tinyLFUProtected:
+		return p.protected
+	}
+	return p.window
+}
+
+// countMinSketch 估算key的访问频率，每个计数最大为tinyLFUMaxFrequency
+type countMinSketch struct {
+	rows       [tinyLFUSketchDepth][]uint8
+	mask       uint64
+	additions  int
+	sampleSize int
+}
+
+func newCountMinSketch(capacity int) *countMinSketch {
+	width := 16
+	for width < capacity*tinyLFUSketchWidthFactor {
+		width <<= 1
+	}
+	s := &countMinSketch{
+		mask:       uint64(width - 1),
+		sampleSize: capacity * tinyLFUSampleFactor,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *countMinSketch) increment(key string) {
+	h := sketchHash(key)
+	for i := range s.rows {
+		idx := s.index(h, i)
+		if s.rows[i][idx] < tinyLFUMaxFrequency {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+func (s *countMinSketch) estimate(key string) uint8 {
+	h := sketchHash(key)
+	min := uint8(tinyLFUMaxFrequency)
+	for i := range s.rows {
+		if v := s.rows[i][s.index(h, i)]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// reset 所有计数减半
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+// index 由一个64位hash派生每一行的下标
+func (s *countMinSketch) index(h uint64, row int) uint64 {
+	h1, h2 := h, h>>32|h<<32
+	return (h1 + uint64(row)*h2 + uint64(row*row)) & s.mask
+}
+
+func sketchHash(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum64()
+}
@@ -0,0 +1,101 @@
+// Package cache @Author  wangjian    2023/9/15 11:05 AM
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	logger "github.com/JianWangEx/commonService/log"
+	"github.com/pkg/errors"
+)
+
+// redisLease
+//
+//	@Description: 以持有者身份保存在redis中、带过期时间的租约，读写锁和信号量共用，
+//	持有者崩溃未释放时租约在timeout后过期
+type redisLease struct {
+	kind            string // 用于日志及错误信息，如"semaphore"
+	key             string // 添加namespace后的key，释放消息发布在该key对应的channel上
+	holder          string
+	blockingTimeout time.Duration
+	cluster         *redisCluster
+	timeout         time.Duration
+	watchdog        bool // 为true时在operator执行期间调用refresh续期
+
+	tryAcquire func(ctx context.Context) (bool, error)
+	release    func(ctx context.Context) (bool, error)
+	// refresh 仍是持有者时将过期时间重置为timeout，返回false表示租约已丢失
+	refresh func(ctx context.Context) (bool, error)
+	// giveUp 放弃等待时调用，可以为nil
+	giveUp func(ctx context.Context)
+}
+
+func (l *redisLease) acquire(ctx context.Context) bool {
+	log := logger.CtxSugar(ctx)
+	enterTime := time.Now()
+	attempts := 0
+	acquired := blockingAcquire(ctx, l.blockingTimeout, func() bool {
+		attempts++
+		ok, err := l.tryAcquire(ctx)
+		if err != nil {
+			log.Errorf("cache %s acquire call error|key=%s, holder=%s, err=%+v", l.kind, l.key, l.holder, err)
+		}
+		return err == nil && ok
+	}, func() (<-chan struct{}, func()) {
+		if l.cluster.lockNotifier == nil {
+			return nil, func() {}
+		}
+		return l.cluster.lockNotifier.subscribe(ctx, getLockReleasedChannel(l.key))
+	})
+	recordLockWait(l.kind, l.cluster.name, client.metricsPrefix(l.key), acquired, attempts, enterTime)
+	return acquired
+}
+
+// withLease 对o进行修饰，获得newLease返回的租约后执行o，结束后释放，newLease返回错误时不执行o；
+// 开启watchdog时租约丢失会取消o的ctx，o未返回错误时返回ErrorCacheLockLost
+func withLease(o AddCacheLockOperator, newLease func() (*redisLease, error)) AddCacheLockOperator {
+	return func(ctx context.Context) (i interface{}, e error) {
+		log := logger.CtxSugar(ctx)
+		lease, err := newLease()
+		if err != nil {
+			return nil, err
+		}
+		acquired := false
+		defer func() {
+			if err := recover(); err != nil {
+				log.Errorf("cache %s panic|key=%s, err=%+v", lease.kind, lease.key, err)
+				e = errors.WithStack(constant.CommonErrorServer.WithMsgF("cache_%s_panic,err=%+v", lease.kind, err))
+			}
+			if !acquired {
+				return
+			}
+			released, err := lease.release(ctx)
+			if err != nil {
+				log.Errorf("cache %s release error|key=%s, holder=%s, err=%+v", lease.kind, lease.key, lease.holder, err)
+			} else if !released {
+				log.Errorf("cache %s release|lease already expire|key=%s, holder=%s", lease.kind, lease.key, lease.holder)
+			}
+		}()
+
+		if acquired = lease.acquire(ctx); !acquired {
+			if lease.giveUp != nil {
+				// ctx可能已经结束，放弃等待不受其影响
+				lease.giveUp(context.Background())
+			}
+			log.Errorf("func blocked by cache %s|key=%s, holder=%s, ctxErr=%v", lease.kind, lease.key, lease.holder, ctx.Err())
+			return nil, errors.WithStack(constant.CommonErrorServer.WithMsgF("blocked by cache %s", lease.kind))
+		}
+		if lease.watchdog {
+			w := startLockWatchdog(ctx, lease.kind, lease.key, lease.holder, lease.timeout, lease.refresh)
+			// 在release之前停止续期
+			defer w.stop()
+			i, e = o(w.ctx)
+			if w.isLost() && e == nil {
+				e = errors.WithStack(constant.ErrorCacheLockLost)
+			}
+			return i, e
+		}
+		return o(ctx)
+	}
+}
@@ -0,0 +1,93 @@
+// Package cache @Author  wangjian    2023/9/13 10:30 AM
+package cache
+
+import (
+	"context"
+	"sync"
+
+	logger "github.com/JianWangEx/commonService/log"
+	"github.com/redis/go-redis/v9"
+)
+
+// lockNotifier
+//
+//	@Description: 所有等待锁的调用共用一个pub/sub连接，按channel订阅锁的释放消息，
+//	没有等待者的channel会被取消订阅
+type lockNotifier struct {
+	redisClient redis.UniversalClient
+
+	mu      sync.Mutex
+	pubSub  *redis.PubSub
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+func newLockNotifier(redisClient redis.UniversalClient) *lockNotifier {
+	return &lockNotifier{
+		redisClient: redisClient,
+		waiters:     make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// subscribe 返回的channel在收到释放消息时可读，订阅失败时返回nil，等待者只能依赖轮询；
+// 订阅是异步生效的，订阅后需要再尝试一次加锁，避免错过订阅生效前的释放
+func (n *lockNotifier) subscribe(ctx context.Context, channel string) (<-chan struct{}, func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.waiters[channel]) == 0 {
+		if n.pubSub == nil {
+			// pub/sub连接的生命周期与进程相同，不使用调用方的ctx
+			n.pubSub = n.redisClient.Subscribe(context.Background(), channel)
+			go n.run(n.pubSub.Channel())
+		} else if err := n.pubSub.Subscribe(ctx, channel); err != nil {
+			logger.CtxSugar(ctx).Warnf("cache lock subscribe failed, fallback to polling|channel=%s, err=%+v", channel, err)
+			return nil, func() {}
+		}
+		n.waiters[channel] = make(map[chan struct{}]struct{})
+	}
+	ch := make(chan struct{}, 1)
+	n.waiters[channel][ch] = struct{}{}
+	return ch, func() {
+		n.unsubscribe(channel, ch)
+	}
+}
+
+func (n *lockNotifier) unsubscribe(channel string, ch chan struct{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.waiters[channel], ch)
+	if len(n.waiters[channel]) > 0 {
+		return
+	}
+	delete(n.waiters, channel)
+	if n.pubSub == nil {
+		return
+	}
+	if err := n.pubSub.Unsubscribe(context.Background(), channel); err != nil {
+		logger.CtxSugar(context.Background()).Warnf("cache lock unsubscribe failed|channel=%s, err=%+v", channel, err)
+	}
+}
+
+// close 关闭pub/sub连接，正在等待的调用退化为轮询
+func (n *lockNotifier) close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.pubSub != nil {
+		_ = n.pubSub.Close()
+		n.pubSub = nil
+	}
+	n.waiters = make(map[string]map[chan struct{}]struct{})
+}
+
+// run 唤醒channel上的所有等待者，等待者未及时处理时不重复通知
+func (n *lockNotifier) run(msgs <-chan *redis.Message) {
+	for msg := range msgs {
+		n.mu.Lock()
+		for ch := range n.waiters[msg.Channel] {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+		n.mu.Unlock()
+	}
+}
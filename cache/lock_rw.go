@@ -0,0 +1,206 @@
+// Package cache @Author  wangjian    2023/9/15 3:30 PM
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	logger "github.com/JianWangEx/commonService/log"
+	"github.com/pkg/errors"
+)
+
+type rwLockParam struct {
+	cacheKey        string
+	holderFunc      func() string // 默认使用uuid
+	timeout         time.Duration
+	blockingTimeout time.Duration
+	cluster         string
+	watchdog        bool
+}
+
+type SetRWLockParam func(param *rwLockParam)
+
+// NewRWLockParam
+//
+//	@Description: 读写锁，读锁可以被多个调用同时持有，写锁与所有读锁和写锁互斥；
+//	有写者在等待时新的读者需要等待，避免写者饥饿。读者保存在zset中，score为读者的过期时间
+//	@param cacheKey
+//	@param opts
+//	@return *rwLockParam
+func NewRWLockParam(cacheKey string, opts ...SetRWLockParam) *rwLockParam {
+	param := &rwLockParam{
+		cacheKey:        cacheKey,
+		holderFunc:      defaultCacheValueFunc,
+		timeout:         defaultCacheLockTimeout,
+		blockingTimeout: defaultLockingTimeout,
+	}
+	for _, f := range opts {
+		f(param)
+	}
+	return param
+}
+
+// RWLockWithTimeout 读锁和写锁的过期时间，需要大于0，否则加锁时返回ErrorInvalidLockParam
+func RWLockWithTimeout(timeout time.Duration) SetRWLockParam {
+	return func(param *rwLockParam) {
+		param.timeout = timeout
+	}
+}
+
+func RWLockWithBlockingTimeout(t time.Duration) SetRWLockParam {
+	return func(param *rwLockParam) {
+		param.blockingTimeout = t
+	}
+}
+
+func RWLockWithHolderFunc(f func() string) SetRWLockParam {
+	return func(param *rwLockParam) {
+		param.holderFunc = f
+	}
+}
+
+// RWLockWithWatchdog 开启后在operator执行期间每隔timeout/3续期一次读锁或写锁，
+// 锁已过期或在过期前都未能续期成功时取消operator的ctx，operator未返回错误时返回ErrorCacheLockLost
+func RWLockWithWatchdog(b bool) SetRWLockParam {
+	return func(param *rwLockParam) {
+		param.watchdog = b
+	}
+}
+
+// RWLockWithCluster 使用指定名称的redis连接，优先于配置的LockCluster及按key前缀的路由
+func RWLockWithCluster(name string) SetRWLockParam {
+	return func(param *rwLockParam) {
+		param.cluster = name
+	}
+}
+
+// ReadLockHandler
+//
+//	@Description: 对o function进行修饰，获得params中所有读锁后执行o，并返回o执行结果
+//	@param ctx
+//	@param o
+//	@param params
+//	@return interface{}
+//	@return error
+func ReadLockHandler(ctx context.Context, o AddCacheLockOperator, params ...*rwLockParam) (interface{}, error) {
+	for i := len(params) - 1; i >= 0; i-- {
+		o = withLease(o, params[i].newReadLease)
+	}
+	return o(ctx)
+}
+
+// WriteLockHandler
+//
+//	@Description: 对o function进行修饰，获得params中所有写锁后执行o，并返回o执行结果
+//	@param ctx
+//	@param o
+//	@param params
+//	@return interface{}
+//	@return error
+func WriteLockHandler(ctx context.Context, o AddCacheLockOperator, params ...*rwLockParam) (interface{}, error) {
+	for i := len(params) - 1; i >= 0; i-- {
+		o = withLease(o, params[i].newWriteLease)
+	}
+	return o(ctx)
+}
+
+// getKeys 读者集合、写锁及等待中的写者，需要在同一个slot中
+func (param *rwLockParam) getKeys() (string, []string) {
+	key := client.buildKey(param.cacheKey)
+	return key, []string{
+		getLockSubKey(key, rwLockReadersKeySuffix),
+		getLockSubKey(key, rwLockWriterKeySuffix),
+		getLockSubKey(key, rwLockWriterWaitingKeySuffix),
+	}
+}
+
+// validate timeout为0时写锁的SET PX报错，读者立即过期
+func (param *rwLockParam) validate() error {
+	if param.timeout <= 0 {
+		return errors.Wrapf(constant.ErrorInvalidLockParam, "key=%s, timeout=%s", param.cacheKey, param.timeout)
+	}
+	return nil
+}
+
+func (param *rwLockParam) newReadLease() (*redisLease, error) {
+	if err := param.validate(); err != nil {
+		return nil, err
+	}
+	key, keys := param.getKeys()
+	cluster, err := client.getLockCluster(param.cluster, key)
+	if err != nil {
+		return nil, err
+	}
+	holder := param.holderFunc()
+	return &redisLease{
+		kind:            "read lock",
+		key:             key,
+		holder:          holder,
+		blockingTimeout: param.blockingTimeout,
+		cluster:         cluster,
+		timeout:         param.timeout,
+		watchdog:        param.watchdog,
+		tryAcquire: func(ctx context.Context) (bool, error) {
+			n, err := acquireReadLockScript.Run(ctx, cluster.client, keys, holder, param.timeout.Milliseconds()).Int64()
+			if err != nil {
+				return false, errors.Wrap(err, "redis cache read lock acquire error")
+			}
+			return n == 1, nil
+		},
+		release: func(ctx context.Context) (bool, error) {
+			return releaseHolder(ctx, cluster, keys[0], key, holder)
+		},
+		refresh: func(ctx context.Context) (bool, error) {
+			return refreshHolder(ctx, cluster, keys[0], holder, param.timeout)
+		},
+	}, nil
+}
+
+func (param *rwLockParam) newWriteLease() (*redisLease, error) {
+	if err := param.validate(); err != nil {
+		return nil, err
+	}
+	key, keys := param.getKeys()
+	cluster, err := client.getLockCluster(param.cluster, key)
+	if err != nil {
+		return nil, err
+	}
+	holder := param.holderFunc()
+	return &redisLease{
+		kind:            "write lock",
+		key:             key,
+		holder:          holder,
+		blockingTimeout: param.blockingTimeout,
+		cluster:         cluster,
+		timeout:         param.timeout,
+		watchdog:        param.watchdog,
+		tryAcquire: func(ctx context.Context) (bool, error) {
+			n, err := acquireWriteLockScript.Run(ctx, cluster.client, keys, holder, param.timeout.Milliseconds(), lockFairWaiterTimeout.Milliseconds()).Int64()
+			if err != nil {
+				return false, errors.Wrap(err, "redis cache write lock acquire error")
+			}
+			return n == 1, nil
+		},
+		release: func(ctx context.Context) (bool, error) {
+			n, err := releaseLockScript.Run(ctx, cluster.client, []string{keys[1]}, holder, getLockReleasedChannel(key)).Int64()
+			if err != nil {
+				return false, errors.Wrap(err, "redis cache write lock release error")
+			}
+			return n == 1, nil
+		},
+		refresh: func(ctx context.Context) (bool, error) {
+			n, err := refreshLockScript.Run(ctx, cluster.client, []string{keys[1]}, holder, param.timeout.Milliseconds()).Int64()
+			if err != nil {
+				return false, errors.Wrap(err, "redis cache write lock refresh error")
+			}
+			return n == 1, nil
+		},
+		// 放弃等待时清除自己的等待标记，让读者可以继续加锁
+		giveUp: func(ctx context.Context) {
+			if err := compareAndDeleteScript.Run(ctx, cluster.client, []string{keys[2]}, holder).Err(); err != nil {
+				logger.CtxSugar(ctx).Warnf("cache write lock give up failed|key=%s, holder=%s, err=%+v", key, holder, err)
+			}
+		},
+	}, nil
+}
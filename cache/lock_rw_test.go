@@ -0,0 +1,134 @@
+// Package cache @Author  wangjian    2023/9/20 3:40 PM
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadLockShared(t *testing.T) {
+	newTestManager(t)
+	param := NewRWLockParam("rw_key", RWLockWithBlockingTimeout(time.Second))
+
+	var readers int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		_, _ = ReadLockHandler(context.TODO(), func(ctx context.Context) (interface{}, error) {
+			atomic.AddInt32(&readers, 1)
+			close(started)
+			<-release
+			return nil, nil
+		}, param)
+	}()
+	<-started
+
+	// 已有读者时其他读者可以同时获得读锁，写者需要等待
+	_, err := ReadLockHandler(context.TODO(), func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&readers, 1)
+		return nil, nil
+	}, param)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&readers))
+
+	blocked := NewRWLockParam("rw_key", RWLockWithBlockingTimeout(50*time.Millisecond))
+	_, err = WriteLockHandler(context.TODO(), func(ctx context.Context) (interface{}, error) {
+		return nil, nil
+	}, blocked)
+	assert.Error(t, err)
+
+	close(release)
+	_, err = WriteLockHandler(context.TODO(), func(ctx context.Context) (interface{}, error) {
+		return nil, nil
+	}, param)
+	assert.NoError(t, err)
+}
+
+func TestWriteLockExclusive(t *testing.T) {
+	server := newTestManager(t)
+	param := NewRWLockParam("rw_key", RWLockWithBlockingTimeout(5*time.Second))
+
+	var running, maxRunning int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		handler := ReadLockHandler
+		if i%2 == 0 {
+			handler = WriteLockHandler
+		}
+		go func(write bool) {
+			defer wg.Done()
+			_, err := handler(context.TODO(), func(ctx context.Context) (interface{}, error) {
+				if write {
+					n := atomic.AddInt32(&running, 1)
+					defer atomic.AddInt32(&running, -1)
+					if n > atomic.LoadInt32(&maxRunning) {
+						atomic.StoreInt32(&maxRunning, n)
+					}
+				} else if atomic.LoadInt32(&running) > 0 {
+					// 写者持有锁时读者不能进入
+					atomic.StoreInt32(&maxRunning, 100)
+				}
+				time.Sleep(10 * time.Millisecond)
+				return nil, nil
+			}, param)
+			assert.NoError(t, err)
+		}(i%2 == 0)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), maxRunning)
+	assert.Empty(t, server.Keys())
+}
+
+func TestRWLockInvalidTimeout(t *testing.T) {
+	newTestManager(t)
+	param := NewRWLockParam("rw_lock_key", RWLockWithTimeout(0))
+	for _, handler := range []func(context.Context, AddCacheLockOperator, ...*rwLockParam) (interface{}, error){ReadLockHandler, WriteLockHandler} {
+		called := false
+		_, err := handler(context.TODO(), func(ctx context.Context) (interface{}, error) {
+			called = true
+			return nil, nil
+		}, param)
+		assert.ErrorIs(t, err, constant.ErrorInvalidLockParam)
+		assert.False(t, called)
+	}
+}
+
+func TestRWLockWatchdog(t *testing.T) {
+	server := newTestManager(t)
+	ctx := context.TODO()
+	param := NewRWLockParam("rw_watchdog_key", RWLockWithTimeout(60*time.Millisecond), RWLockWithWatchdog(true))
+	writer := NewRWLockParam("rw_watchdog_key", RWLockWithBlockingTimeout(20*time.Millisecond))
+
+	// 运行时间超过timeout，读锁仍被持有，写者无法加锁
+	_, err := ReadLockHandler(ctx, func(ctx context.Context) (interface{}, error) {
+		time.Sleep(200 * time.Millisecond)
+		_, err := WriteLockHandler(context.Background(), func(ctx context.Context) (interface{}, error) {
+			return nil, nil
+		}, writer)
+		assert.Error(t, err)
+		assert.NoError(t, ctx.Err())
+		return nil, nil
+	}, param)
+	require.NoError(t, err)
+	assert.Empty(t, server.Keys())
+
+	// 写锁被删除后取消operator
+	_, err = WriteLockHandler(ctx, func(ctx context.Context) (interface{}, error) {
+		server.Del(getLockSubKey(client.buildKey("rw_watchdog_key"), rwLockWriterKeySuffix))
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Error("operator ctx is not cancelled after write lock lost")
+		}
+		return nil, nil
+	}, param)
+	assert.ErrorIs(t, err, constant.ErrorCacheLockLost)
+}
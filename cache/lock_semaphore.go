@@ -0,0 +1,148 @@
+// Package cache @Author  wangjian    2023/9/15 2:10 PM
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/pkg/errors"
+)
+
+type semaphoreParam struct {
+	cacheKey        string
+	limit           int64
+	holderFunc      func() string // 默认使用uuid
+	timeout         time.Duration
+	blockingTimeout time.Duration
+	cluster         string
+	watchdog        bool
+}
+
+type SetSemaphoreParam func(param *semaphoreParam)
+
+// NewSemaphoreParam
+//
+//	@Description: 计数信号量，集群内最多limit个调用同时持有，持有者保存在key对应的zset中，
+//	score为持有者的过期时间，持有者崩溃后其名额在timeout后释放
+//	@param cacheKey
+//	@param limit 需要大于0，否则SemaphoreHandler返回ErrorInvalidLockParam
+//	@param opts
+//	@return *semaphoreParam
+func NewSemaphoreParam(cacheKey string, limit int64, opts ...SetSemaphoreParam) *semaphoreParam {
+	param := &semaphoreParam{
+		cacheKey:        cacheKey,
+		limit:           limit,
+		holderFunc:      defaultCacheValueFunc,
+		timeout:         defaultCacheLockTimeout,
+		blockingTimeout: defaultLockingTimeout,
+	}
+	for _, f := range opts {
+		f(param)
+	}
+	return param
+}
+
+// SemaphoreWithTimeout 持有者的过期时间，需要大于0，否则SemaphoreHandler返回ErrorInvalidLockParam
+func SemaphoreWithTimeout(timeout time.Duration) SetSemaphoreParam {
+	return func(param *semaphoreParam) {
+		param.timeout = timeout
+	}
+}
+
+func SemaphoreWithBlockingTimeout(t time.Duration) SetSemaphoreParam {
+	return func(param *semaphoreParam) {
+		param.blockingTimeout = t
+	}
+}
+
+func SemaphoreWithHolderFunc(f func() string) SetSemaphoreParam {
+	return func(param *semaphoreParam) {
+		param.holderFunc = f
+	}
+}
+
+// SemaphoreWithWatchdog 开启后在operator执行期间每隔timeout/3续期一次名额，
+// 名额已过期或在过期前都未能续期成功时取消operator的ctx，operator未返回错误时返回ErrorCacheLockLost
+func SemaphoreWithWatchdog(b bool) SetSemaphoreParam {
+	return func(param *semaphoreParam) {
+		param.watchdog = b
+	}
+}
+
+// SemaphoreWithCluster 使用指定名称的redis连接，优先于配置的LockCluster及按key前缀的路由
+func SemaphoreWithCluster(name string) SetSemaphoreParam {
+	return func(param *semaphoreParam) {
+		param.cluster = name
+	}
+}
+
+// SemaphoreHandler
+//
+//	@Description: 对o function进行修饰，获得params中所有信号量的名额后执行o，并返回o执行结果
+//	@param ctx
+//	@param o
+//	@param params
+//	@return interface{}
+//	@return error
+func SemaphoreHandler(ctx context.Context, o AddCacheLockOperator, params ...*semaphoreParam) (interface{}, error) {
+	for i := len(params) - 1; i >= 0; i-- {
+		o = addSemaphore(o, params[i])
+	}
+	return o(ctx)
+}
+
+func addSemaphore(o AddCacheLockOperator, param *semaphoreParam) AddCacheLockOperator {
+	return withLease(o, func() (*redisLease, error) {
+		// limit不大于0时永远无法获得名额，timeout为0时持有者立即过期
+		if param.limit <= 0 || param.timeout <= 0 {
+			return nil, errors.Wrapf(constant.ErrorInvalidLockParam, "key=%s, limit=%d, timeout=%s", param.cacheKey, param.limit, param.timeout)
+		}
+		key := client.buildKey(param.cacheKey)
+		cluster, err := client.getLockCluster(param.cluster, key)
+		if err != nil {
+			return nil, err
+		}
+		holder := param.holderFunc()
+		return &redisLease{
+			kind:            "semaphore",
+			key:             key,
+			holder:          holder,
+			blockingTimeout: param.blockingTimeout,
+			cluster:         cluster,
+			timeout:         param.timeout,
+			watchdog:        param.watchdog,
+			tryAcquire: func(ctx context.Context) (bool, error) {
+				n, err := acquireSemaphoreScript.Run(ctx, cluster.client, []string{key}, holder, param.limit, param.timeout.Milliseconds()).Int64()
+				if err != nil {
+					return false, errors.Wrap(err, "redis cache semaphore acquire error")
+				}
+				return n == 1, nil
+			},
+			release: func(ctx context.Context) (bool, error) {
+				return releaseHolder(ctx, cluster, key, key, holder)
+			},
+			refresh: func(ctx context.Context) (bool, error) {
+				return refreshHolder(ctx, cluster, key, holder, param.timeout)
+			},
+		}, nil
+	})
+}
+
+// releaseHolder 从持有者集合中移除holder，并在key对应的channel上通知等待者
+func releaseHolder(ctx context.Context, cluster *redisCluster, holdersKey string, key string, holder string) (bool, error) {
+	n, err := releaseHolderScript.Run(ctx, cluster.client, []string{holdersKey}, holder, getLockReleasedChannel(key)).Int64()
+	if err != nil {
+		return false, errors.Wrap(err, "redis cache release holder error")
+	}
+	return n == 1, nil
+}
+
+// refreshHolder holder仍在持有者集合中且未过期时将其过期时间重置为timeout后
+func refreshHolder(ctx context.Context, cluster *redisCluster, holdersKey string, holder string, timeout time.Duration) (bool, error) {
+	n, err := refreshHolderScript.Run(ctx, cluster.client, []string{holdersKey}, holder, timeout.Milliseconds()).Int64()
+	if err != nil {
+		return false, errors.Wrap(err, "redis cache refresh holder error")
+	}
+	return n == 1, nil
+}
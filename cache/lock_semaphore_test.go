@@ -0,0 +1,110 @@
+// Package cache @Author  wangjian    2023/9/20 3:10 PM
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemaphoreHandler(t *testing.T) {
+	server := newTestManager(t)
+	param := NewSemaphoreParam("semaphore_key", 2, SemaphoreWithBlockingTimeout(5*time.Second))
+
+	var running, maxRunning int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := SemaphoreHandler(context.TODO(), func(ctx context.Context) (interface{}, error) {
+				n := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					m := atomic.LoadInt32(&maxRunning)
+					if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+				return nil, nil
+			}, param)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), maxRunning)
+	assert.Empty(t, server.Keys())
+}
+
+func TestSemaphoreHandlerBlocked(t *testing.T) {
+	newTestManager(t)
+	param := NewSemaphoreParam("semaphore_key", 1, SemaphoreWithBlockingTimeout(50*time.Millisecond))
+
+	_, err := SemaphoreHandler(context.TODO(), func(ctx context.Context) (interface{}, error) {
+		_, err := SemaphoreHandler(context.Background(), func(ctx context.Context) (interface{}, error) {
+			return nil, nil
+		}, param)
+		return nil, err
+	}, param)
+	assert.Error(t, err)
+}
+
+func TestSemaphoreHandlerInvalidParam(t *testing.T) {
+	newTestManager(t)
+	for _, param := range []*semaphoreParam{
+		NewSemaphoreParam("semaphore_key", 0),
+		NewSemaphoreParam("semaphore_key", 1, SemaphoreWithTimeout(0)),
+	} {
+		called := false
+		_, err := SemaphoreHandler(context.TODO(), func(ctx context.Context) (interface{}, error) {
+			called = true
+			return nil, nil
+		}, param)
+		assert.ErrorIs(t, err, constant.ErrorInvalidLockParam)
+		assert.False(t, called)
+	}
+}
+
+func TestSemaphoreWatchdog(t *testing.T) {
+	server := newTestManager(t)
+	ctx := context.TODO()
+	param := NewSemaphoreParam("semaphore_watchdog_key", 1,
+		SemaphoreWithTimeout(60*time.Millisecond),
+		SemaphoreWithWatchdog(true),
+		SemaphoreWithHolderFunc(func() string { return "owner" }),
+	)
+	other := NewSemaphoreParam("semaphore_watchdog_key", 1, SemaphoreWithBlockingTimeout(20*time.Millisecond))
+
+	// 运行时间超过timeout，名额仍被持有
+	_, err := SemaphoreHandler(ctx, func(ctx context.Context) (interface{}, error) {
+		time.Sleep(200 * time.Millisecond)
+		_, err := SemaphoreHandler(context.Background(), func(ctx context.Context) (interface{}, error) {
+			return nil, nil
+		}, other)
+		assert.Error(t, err)
+		assert.NoError(t, ctx.Err())
+		return nil, nil
+	}, param)
+	require.NoError(t, err)
+	assert.Empty(t, server.Keys())
+
+	// 名额被移除后取消operator
+	_, err = SemaphoreHandler(ctx, func(ctx context.Context) (interface{}, error) {
+		_, err := server.ZRem(client.buildKey("semaphore_watchdog_key"), "owner")
+		require.NoError(t, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Error("operator ctx is not cancelled after semaphore lost")
+		}
+		return nil, nil
+	}, param)
+	assert.ErrorIs(t, err, constant.ErrorCacheLockLost)
+}
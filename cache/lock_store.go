@@ -0,0 +1,345 @@
+// Package cache @Author  wangjian    2023/9/6 11:05 AM
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// lockStore 锁的原子操作，key为添加namespace后的key
+type lockStore interface {
+	// acquire 获得锁时返回单调递增的fencing token，锁已被占用时返回0
+	acquire(ctx context.Context, key string, value string, ttl time.Duration) (int64, error)
+	// release 仅当锁的值等于value时删除，返回是否删除
+	release(ctx context.Context, key string, value string) (bool, error)
+	// refresh 仅当锁的值等于value时重置过期时间，返回是否重置
+	refresh(ctx context.Context, key string, value string, ttl time.Duration) (bool, error)
+	// get 返回锁当前的值，锁不存在时返回空字符串
+	get(ctx context.Context, key string) (string, error)
+	// subscribe 返回的channel在锁可能被释放时可读，不支持通知时返回nil，返回的函数用于取消订阅
+	subscribe(ctx context.Context, key string) (<-chan struct{}, func())
+}
+
+// fairLockStore 支持按等待顺序(FIFO)获得锁的lockStore
+type fairLockStore interface {
+	// acquireFair 锁空闲且没有更早的等待者时获得锁，否则加入等待队列并返回0
+	acquireFair(ctx context.Context, key string, value string, ttl time.Duration) (int64, error)
+	// leaveQueue 放弃等待时离开等待队列
+	leaveQueue(ctx context.Context, key string, value string) error
+}
+
+// getLockStore
+//
+//	@Description: 使用Redlock时在多个独立节点上加锁，否则Local storage的key使用进程内的锁，
+//	其他使用redis锁，redis连接见cacheManager.getLockCluster
+//	@param key
+//	@param redlock
+//	@param cluster 显式指定的redis连接名称，可以为空
+//	@return lockStore
+//	@return string 用于指标的redis连接名称，Redlock及进程内的锁为空
+func getLockStore(key string, redlock bool, cluster string) (lockStore, string) {
+	if redlock {
+		if client.redlock == nil {
+			// 未配置节点，加锁时返回ErrorRedlockNotConfigured
+			return &redlockStore{}, ""
+		}
+		return client.redlock, ""
+	}
+	if client.newCallOption().getStorage(key) == Local {
+		return defaultLocalLockStore, ""
+	}
+	rc, err := client.getLockCluster(cluster, key)
+	if err != nil {
+		return &unavailableLockStore{err: err}, cluster
+	}
+	return &redisLockStore{redisClient: rc.client, notifier: rc.lockNotifier}, rc.name
+}
+
+// getLockSubKey 锁相关的key(fencing计数器、等待队列)需要与锁在同一个slot中才能在一个脚本中操作，
+// key中没有hash tag时使用整个key作为hash tag，否则沿用key中的hash tag
+func getLockSubKey(key string, suffix string) string {
+	if start := strings.Index(key, "{"); start >= 0 {
+		if end := strings.Index(key[start+1:], "}"); end > 0 {
+			return key + suffix
+		}
+	}
+	return "{" + key + "}" + suffix
+}
+
+func getFencingKey(key string) string {
+	return getLockSubKey(key, fencingKeySuffix)
+}
+
+// isFencingKey fencing计数器的key总是带有hash tag，见getLockSubKey
+func isFencingKey(key string) bool {
+	return strings.HasSuffix(key, fencingKeySuffix) && strings.Contains(key, "{")
+}
+
+// getLockReleasedChannel pub/sub的channel不属于任何slot，不需要hash tag
+func getLockReleasedChannel(key string) string {
+	return key + lockReleasedChannelSuffix
+}
+
+type redisLockStore struct {
+	redisClient redis.UniversalClient
+	// 为nil时不支持通知，等待者只能轮询
+	notifier *lockNotifier
+}
+
+func (s *redisLockStore) acquire(ctx context.Context, key string, value string, ttl time.Duration) (int64, error) {
+	token, err := acquireLockScript.Run(ctx, s.redisClient, []string{key, getFencingKey(key)}, value, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return 0, errors.Wrap(err, "redis cache lock acquire error")
+	}
+	return token, nil
+}
+
+func (s *redisLockStore) acquireFair(ctx context.Context, key string, value string, ttl time.Duration) (int64, error) {
+	keys := []string{key, getFencingKey(key), getLockSubKey(key, lockQueueKeySuffix), getLockSubKey(key, lockQueueTimeoutKeySuffix)}
+	token, err := acquireFairLockScript.Run(ctx, s.redisClient, keys, value, ttl.Milliseconds(), time.Now().UnixMilli(), lockFairWaiterTimeout.Milliseconds()).Int64()
+	if err != nil {
+		return 0, errors.Wrap(err, "redis cache fair lock acquire error")
+	}
+	return token, nil
+}
+
+func (s *redisLockStore) leaveQueue(ctx context.Context, key string, value string) error {
+	keys := []string{getLockSubKey(key, lockQueueKeySuffix), getLockSubKey(key, lockQueueTimeoutKeySuffix)}
+	if err := leaveLockQueueScript.Run(ctx, s.redisClient, keys, value).Err(); err != nil {
+		return errors.Wrap(err, "redis cache lock leave queue error")
+	}
+	return nil
+}
+
+func (s *redisLockStore) subscribe(ctx context.Context, key string) (<-chan struct{}, func()) {
+	if s.notifier == nil {
+		return nil, func() {}
+	}
+	return s.notifier.subscribe(ctx, getLockReleasedChannel(key))
+}
+
+func (s *redisLockStore) release(ctx context.Context, key string, value string) (bool, error) {
+	n, err := releaseLockScript.Run(ctx, s.redisClient, []string{key}, value, getLockReleasedChannel(key)).Int64()
+	if err != nil {
+		return false, errors.Wrap(err, "redis cache lock release error")
+	}
+	return n == 1, nil
+}
+
+func (s *redisLockStore) refresh(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	n, err := refreshLockScript.Run(ctx, s.redisClient, []string{key}, value, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return false, errors.Wrap(err, "redis cache lock refresh error")
+	}
+	return n == 1, nil
+}
+
+func (s *redisLockStore) get(ctx context.Context, key string) (string, error) {
+	value, err := s.redisClient.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "redis cache lock get error")
+	}
+	return value, nil
+}
+
+// unavailableLockStore 指定的redis连接不存在时使用，所有操作都返回创建时的错误
+type unavailableLockStore struct {
+	err error
+}
+
+func (s *unavailableLockStore) acquire(context.Context, string, string, time.Duration) (int64, error) {
+	return 0, s.err
+}
+
+func (s *unavailableLockStore) release(context.Context, string, string) (bool, error) {
+	return false, s.err
+}
+
+func (s *unavailableLockStore) refresh(context.Context, string, string, time.Duration) (bool, error) {
+	return false, s.err
+}
+
+func (s *unavailableLockStore) get(context.Context, string) (string, error) {
+	return "", s.err
+}
+
+func (s *unavailableLockStore) subscribe(context.Context, string) (<-chan struct{}, func()) {
+	return nil, func() {}
+}
+
+var defaultLocalLockStore = newLocalLockStore()
+
+type localLock struct {
+	value    string
+	expireAt time.Time // 零值表示不过期
+}
+
+func (l localLock) expired(now time.Time) bool {
+	return !l.expireAt.IsZero() && !now.Before(l.expireAt)
+}
+
+type localLockWaiter struct {
+	value    string
+	deadline time.Time
+}
+
+// localLockStore 进程内的锁，只在当前实例内互斥
+type localLockStore struct {
+	mu      sync.Mutex
+	locks   map[string]localLock
+	tokens  map[string]int64
+	queues  map[string][]localLockWaiter
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+func newLocalLockStore() *localLockStore {
+	return &localLockStore{
+		locks:   make(map[string]localLock),
+		tokens:  make(map[string]int64),
+		queues:  make(map[string][]localLockWaiter),
+		waiters: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+func (s *localLockStore) acquire(ctx context.Context, key string, value string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if l, ok := s.locks[key]; ok && !l.expired(now) {
+		return 0, nil
+	}
+	return s.lock(key, value, ttl, now), nil
+}
+
+// lock 调用方需要持有s.mu
+func (s *localLockStore) lock(key string, value string, ttl time.Duration, now time.Time) int64 {
+	l := localLock{value: value}
+	if ttl > 0 {
+		l.expireAt = now.Add(ttl)
+	}
+	s.locks[key] = l
+	s.tokens[key]++
+	return s.tokens[key]
+}
+
+func (s *localLockStore) acquireFair(ctx context.Context, key string, value string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	queue := s.queues[key]
+	for len(queue) > 0 && !now.Before(queue[0].deadline) {
+		queue = queue[1:]
+	}
+	l, locked := s.locks[key]
+	if (!locked || l.expired(now)) && (len(queue) == 0 || queue[0].value == value) {
+		if len(queue) > 0 {
+			queue = queue[1:]
+		}
+		s.setQueue(key, queue)
+		return s.lock(key, value, ttl, now), nil
+	}
+	deadline := now.Add(lockFairWaiterTimeout)
+	found := false
+	for idx := range queue {
+		if queue[idx].value == value {
+			queue[idx].deadline = deadline
+			found = true
+			break
+		}
+	}
+	if !found {
+		queue = append(queue, localLockWaiter{value: value, deadline: deadline})
+	}
+	s.setQueue(key, queue)
+	return 0, nil
+}
+
+func (s *localLockStore) leaveQueue(ctx context.Context, key string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := make([]localLockWaiter, 0, len(s.queues[key]))
+	for _, w := range s.queues[key] {
+		if w.value != value {
+			queue = append(queue, w)
+		}
+	}
+	s.setQueue(key, queue)
+	return nil
+}
+
+func (s *localLockStore) setQueue(key string, queue []localLockWaiter) {
+	if len(queue) == 0 {
+		delete(s.queues, key)
+		return
+	}
+	s.queues[key] = queue
+}
+
+func (s *localLockStore) subscribe(ctx context.Context, key string) (<-chan struct{}, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	if s.waiters[key] == nil {
+		s.waiters[key] = make(map[chan struct{}]struct{})
+	}
+	s.waiters[key][ch] = struct{}{}
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.waiters[key], ch)
+		if len(s.waiters[key]) == 0 {
+			delete(s.waiters, key)
+		}
+	}
+}
+
+func (s *localLockStore) release(ctx context.Context, key string, value string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.locks[key]
+	if !ok || l.expired(time.Now()) || l.value != value {
+		return false, nil
+	}
+	delete(s.locks, key)
+	for ch := range s.waiters[key] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	return true, nil
+}
+
+func (s *localLockStore) refresh(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	l, ok := s.locks[key]
+	if !ok || l.expired(now) || l.value != value {
+		return false, nil
+	}
+	l.expireAt = time.Time{}
+	if ttl > 0 {
+		l.expireAt = now.Add(ttl)
+	}
+	s.locks[key] = l
+	return true, nil
+}
+
+func (s *localLockStore) get(ctx context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.locks[key]
+	if !ok || l.expired(time.Now()) {
+		return "", nil
+	}
+	return l.value, nil
+}
@@ -0,0 +1,138 @@
+// Package cache @Author  wangjian    2023/9/6 3:30 PM
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetFencingKey(t *testing.T) {
+	assert.Equal(t, "{order_lock}:fencing", getFencingKey("order_lock"))
+	assert.Equal(t, "{user:1}:order_lock:fencing", getFencingKey("{user:1}:order_lock"))
+	// 空的hash tag不生效，使用整个key
+	assert.Equal(t, "{{}order_lock}:fencing", getFencingKey("{}order_lock"))
+}
+
+func TestFlushCacheLockKeys(t *testing.T) {
+	server := newTestManager(t)
+	client.keyPrefix = getKeyPrefix("order", "v2")
+	ctx := context.TODO()
+	require.NoError(t, server.Set("other:lock", "v"))
+	require.NoError(t, server.Set("{other:lock}:fencing", "1"))
+
+	lock := func() int64 {
+		var token int64
+		_, err := AddCacheLockHandler(ctx, func(ctx context.Context) (interface{}, error) {
+			var ok bool
+			token, ok = GetFencingToken(ctx, "flush_lock")
+			require.True(t, ok)
+			return nil, nil
+		}, NewAddCacheLockParam("flush_lock", CacheLockWithFair(true)))
+		require.NoError(t, err)
+		return token
+	}
+	first := lock()
+	fencingKey := getFencingKey("order:v2:flush_lock")
+	require.True(t, server.Exists(fencingKey))
+	require.NoError(t, server.Set("order:v2:job:fencing", "v"))
+
+	_, err := client.FlushCache(ctx)
+	require.NoError(t, err)
+	// fencing计数器保留，普通key即使以:fencing结尾也会被删除
+	assert.ElementsMatch(t, []string{"other:lock", "{other:lock}:fencing", fencingKey}, server.Keys())
+	assert.Greater(t, lock(), first)
+}
+
+func TestLocalLockStore(t *testing.T) {
+	s := newLocalLockStore()
+	ctx := context.TODO()
+
+	token, err := s.acquire(ctx, "k", "a", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), token)
+
+	token, err = s.acquire(ctx, "k", "b", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), token)
+
+	// 其他持有者不能释放或续期
+	released, err := s.release(ctx, "k", "b")
+	require.NoError(t, err)
+	assert.False(t, released)
+	refreshed, err := s.refresh(ctx, "k", "b", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, refreshed)
+
+	refreshed, err = s.refresh(ctx, "k", "a", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, refreshed)
+	released, err = s.release(ctx, "k", "a")
+	require.NoError(t, err)
+	assert.True(t, released)
+
+	// 过期的锁可以被重新获得，token继续递增
+	token, err = s.acquire(ctx, "k", "b", time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), token)
+	time.Sleep(5 * time.Millisecond)
+	released, err = s.release(ctx, "k", "b")
+	require.NoError(t, err)
+	assert.False(t, released)
+	token, err = s.acquire(ctx, "k", "c", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), token)
+}
+
+func TestAddCacheLockHandlerFencingToken(t *testing.T) {
+	newTestManager(t)
+	ctx := context.TODO()
+
+	for _, key := range []string{"fencing_test_lock.local", "fencing_test_lock"} {
+		param := NewAddCacheLockParam(key)
+		tokens := make([]int64, 0)
+		op := func(ctx context.Context) (interface{}, error) {
+			token, ok := GetFencingToken(ctx, key)
+			require.True(t, ok)
+			tokens = append(tokens, token)
+			// 嵌套使用同一个锁时继承外层的锁和token
+			_, err := AddCacheLockHandler(ctx, func(ctx context.Context) (interface{}, error) {
+				inner, ok := GetFencingToken(ctx, key)
+				require.True(t, ok)
+				assert.Equal(t, token, inner)
+				return nil, nil
+			}, param)
+			return nil, err
+		}
+		for i := 0; i < 2; i++ {
+			_, err := AddCacheLockHandler(ctx, op, param)
+			require.NoError(t, err)
+		}
+		require.Len(t, tokens, 2, key)
+		assert.Less(t, tokens[0], tokens[1], key)
+
+		_, ok := GetFencingToken(ctx, key)
+		assert.False(t, ok)
+	}
+}
+
+func TestAddCacheLockHandlerBlocked(t *testing.T) {
+	newTestManager(t)
+	ctx := context.TODO()
+
+	for _, key := range []string{"blocked_test_lock.local", "blocked_test_lock"} {
+		param := NewAddCacheLockParam(key, CacheLockWithBlockingTimeout(20*time.Millisecond))
+		_, err := AddCacheLockHandler(ctx, func(ctx context.Context) (interface{}, error) {
+			// 新的调用链不会继承锁
+			_, err := AddCacheLockHandler(context.TODO(), func(ctx context.Context) (interface{}, error) {
+				return nil, nil
+			}, param)
+			assert.Error(t, err, key)
+			return nil, nil
+		}, param)
+		require.NoError(t, err)
+	}
+}
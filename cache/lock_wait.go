@@ -0,0 +1,61 @@
+// Package cache @Author  wangjian    2023/9/15 10:20 AM
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/JianWangEx/commonService/util"
+)
+
+// blockingAcquire
+//
+//	@Description: 调用tryAcquire直到成功、超过blockingTimeout或ctx结束，第一次失败后订阅释放通知，
+//	收到通知时立即重试，通知不可用或丢失时按指数退避轮询
+//	@param ctx
+//	@param blockingTimeout 为0时只尝试一次
+//	@param tryAcquire
+//	@param subscribe 返回的channel在锁可能被释放时可读，为nil时只轮询
+//	@return bool 是否获得
+func blockingAcquire(ctx context.Context, blockingTimeout time.Duration, tryAcquire func() bool, subscribe func() (<-chan struct{}, func())) bool {
+	enterTime := time.Now()
+	var (
+		notify     <-chan struct{}
+		subscribed bool
+	)
+	numOfRetry := 0
+	for {
+		if tryAcquire() {
+			return true
+		}
+		remaining := blockingTimeout - time.Since(enterTime)
+		if remaining <= 0 || ctx.Err() != nil {
+			return false
+		}
+		if !subscribed {
+			var unsubscribe func()
+			notify, unsubscribe = subscribe()
+			defer unsubscribe()
+			subscribed = true
+			// 订阅生效前锁可能已经被释放，立即重试一次
+			continue
+		}
+		waitTime := util.MinDuration(remaining, lockPollingInterval(numOfRetry))
+		select {
+		case <-notify:
+		case <-time.After(waitTime):
+			numOfRetry++
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// lockPollingInterval 指数退避的轮询间隔，最大为lockPollingMaxInterval
+func lockPollingInterval(retry int) time.Duration {
+	interval := time.Second / lockPollingIntervalDiv
+	for i := 0; i < retry && interval < lockPollingMaxInterval; i++ {
+		interval *= 2
+	}
+	return util.MinDuration(interval, lockPollingMaxInterval)
+}
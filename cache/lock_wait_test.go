@@ -0,0 +1,115 @@
+// Package cache @Author  wangjian    2023/9/13 4:10 PM
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockPollingInterval(t *testing.T) {
+	assert.Equal(t, 10*time.Millisecond, lockPollingInterval(0))
+	assert.Equal(t, 40*time.Millisecond, lockPollingInterval(2))
+	assert.Equal(t, lockPollingMaxInterval, lockPollingInterval(10))
+	assert.Equal(t, lockPollingMaxInterval, lockPollingInterval(100))
+}
+
+func TestLockWaiterWokenOnRelease(t *testing.T) {
+	newTestManager(t)
+	ctx := context.TODO()
+	// local锁通过进程内通知唤醒，redis锁通过pub/sub唤醒
+	for _, key := range []string{"wake_test_lock.local", "wake_test_lock"} {
+		holding := make(chan struct{})
+		go func() {
+			_, _ = AddCacheLockHandler(ctx, func(ctx context.Context) (interface{}, error) {
+				close(holding)
+				time.Sleep(200 * time.Millisecond)
+				return nil, nil
+			}, NewAddCacheLockParam(key))
+		}()
+		<-holding
+
+		startTime := time.Now()
+		_, err := AddCacheLockHandler(ctx, func(ctx context.Context) (interface{}, error) {
+			return nil, nil
+		}, NewAddCacheLockParam(key, CacheLockWithBlockingTimeout(5*time.Second)))
+		require.NoError(t, err)
+		// 轮询会在310ms时才重试，被通知唤醒时接近200ms
+		assert.Less(t, time.Since(startTime), 280*time.Millisecond, key)
+	}
+}
+
+func TestLockWaitHonorsContext(t *testing.T) {
+	newTestManager(t)
+	for _, key := range []string{"ctx_test_lock.local", "ctx_test_lock"} {
+		_, err := AddCacheLockHandler(context.TODO(), func(ctx context.Context) (interface{}, error) {
+			waitCtx, cancel := context.WithTimeout(context.TODO(), 30*time.Millisecond)
+			defer cancel()
+			startTime := time.Now()
+			_, err := AddCacheLockHandler(waitCtx, func(ctx context.Context) (interface{}, error) {
+				return nil, nil
+			}, NewAddCacheLockParam(key, CacheLockWithBlockingTimeout(5*time.Second)))
+			assert.Error(t, err, key)
+			assert.Less(t, time.Since(startTime), time.Second, key)
+			return nil, nil
+		}, NewAddCacheLockParam(key))
+		require.NoError(t, err)
+	}
+}
+
+func TestFairLockOrder(t *testing.T) {
+	newTestManager(t)
+	for _, key := range []string{"fair_test_lock.local", "fair_test_lock"} {
+		assert.Equal(t, []string{"waiter_0", "waiter_1", "waiter_2"}, runFairLockWaiters(t, key), key)
+	}
+}
+
+// runFairLockWaiters 持有锁时依次启动3个等待者，返回获得锁的顺序
+func runFairLockWaiters(t *testing.T, key string) []string {
+	ctx := context.TODO()
+	newParam := func() *addCacheLockParam {
+		return NewAddCacheLockParam(key, CacheLockWithBlockingTimeout(5*time.Second), CacheLockWithFair(true))
+	}
+
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+	)
+	holding := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		_, _ = AddCacheLockHandler(ctx, func(ctx context.Context) (interface{}, error) {
+			close(holding)
+			<-release
+			return nil, nil
+		}, newParam())
+	}()
+	<-holding
+
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("waiter_%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := AddCacheLockHandler(ctx, func(ctx context.Context) (interface{}, error) {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				return nil, nil
+			}, newParam())
+			assert.NoError(t, err)
+		}()
+		// 等待加入队列后再启动下一个等待者
+		time.Sleep(30 * time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	return order
+}
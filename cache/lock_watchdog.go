@@ -0,0 +1,93 @@
+// Package cache @Author  wangjian    2023/9/8 10:40 AM
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	logger "github.com/JianWangEx/commonService/log"
+)
+
+// lockWatchdog 在operator执行期间为锁续期，普通锁、读写锁及信号量共用
+type lockWatchdog struct {
+	ctx    context.Context // operator使用的ctx，锁丢失时被取消
+	cancel context.CancelFunc
+	done   chan struct{}
+	lost   int32
+
+	kind    string // 用于日志，如"lock"、"semaphore"
+	key     string
+	holder  string
+	timeout time.Duration
+	refresh func(ctx context.Context) (bool, error)
+}
+
+func (l *cacheLock) startWatchdog(ctx context.Context) *lockWatchdog {
+	return startLockWatchdog(ctx, "lock", l.cacheKey, l.cacheValue, l.cacheTimeout, l.refresh)
+}
+
+// startLockWatchdog 每隔timeout/lockWatchdogIntervalDiv调用一次refresh，refresh将锁的过期时间重置为timeout
+func startLockWatchdog(ctx context.Context, kind, key, holder string, timeout time.Duration, refresh func(ctx context.Context) (bool, error)) *lockWatchdog {
+	wCtx, cancel := context.WithCancel(ctx)
+	w := &lockWatchdog{
+		ctx:     wCtx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		kind:    kind,
+		key:     key,
+		holder:  holder,
+		timeout: timeout,
+		refresh: refresh,
+	}
+	go w.run()
+	return w
+}
+
+// run 续期返回false说明锁已过期或被其他持有者获得，立即取消；
+// 续期出错时在锁过期前继续重试，超过过期时间仍未成功则取消
+func (w *lockWatchdog) run() {
+	defer close(w.done)
+	log := logger.CtxSugar(w.ctx)
+	interval := w.timeout / lockWatchdogIntervalDiv
+	if interval < lockWatchdogMinInterval {
+		interval = lockWatchdogMinInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastRenewed := time.Now()
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		refreshed, err := w.refresh(w.ctx)
+		if w.ctx.Err() != nil {
+			return
+		}
+		if err == nil && refreshed {
+			lastRenewed = time.Now()
+			continue
+		}
+		if err != nil && time.Since(lastRenewed)+interval < w.timeout {
+			log.Warnf("cache %s watchdog refresh failed, retry|key=%s, holder=%s, err=%+v", w.kind, w.key, w.holder, err)
+			continue
+		}
+		log.Errorf("cache %s watchdog lost lock, cancel operator|key=%s, holder=%s, err=%+v", w.kind, w.key, w.holder, err)
+		atomic.StoreInt32(&w.lost, 1)
+		w.cancel()
+		return
+	}
+}
+
+// stop 停止续期并等待续期goroutine退出
+func (w *lockWatchdog) stop() {
+	w.cancel()
+	<-w.done
+}
+
+func (w *lockWatchdog) isLost() bool {
+	return atomic.LoadInt32(&w.lost) == 1
+}
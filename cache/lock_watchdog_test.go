@@ -0,0 +1,92 @@
+// Package cache @Author  wangjian    2023/9/8 3:15 PM
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockWatchdogRenew(t *testing.T) {
+	newTestManager(t)
+	ctx := context.TODO()
+	for _, key := range []string{"watchdog_renew_lock.local", "watchdog_renew_lock"} {
+		store, _ := getLockStore(client.buildKey(key), false, "")
+		param := NewAddCacheLockParam(key, CacheLockWithTimeout(30*time.Millisecond), CacheLockWithWatchdog(true))
+
+		_, err := AddCacheLockHandler(ctx, func(ctx context.Context) (interface{}, error) {
+			// 运行时间超过timeout，锁仍被持有
+			time.Sleep(100 * time.Millisecond)
+			token, err := store.acquire(ctx, key, "other", time.Minute)
+			require.NoError(t, err)
+			assert.Equal(t, int64(0), token, key)
+			assert.NoError(t, ctx.Err())
+			return nil, nil
+		}, param)
+		require.NoError(t, err)
+
+		// 释放后可以被其他持有者获得
+		value, err := store.get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, "", value, key)
+	}
+}
+
+func TestLockWatchdogLost(t *testing.T) {
+	newTestManager(t)
+	ctx := context.TODO()
+	for _, key := range []string{"watchdog_lost_lock.local", "watchdog_lost_lock"} {
+		store, _ := getLockStore(client.buildKey(key), false, "")
+		param := NewAddCacheLockParam(key,
+			CacheLockWithTimeout(30*time.Millisecond),
+			CacheLockWithWatchdog(true),
+			CacheLockWithCacheValueFunc(func() string { return "owner" }),
+		)
+
+		_, err := AddCacheLockHandler(ctx, func(ctx context.Context) (interface{}, error) {
+			// 模拟锁被其他持有者获得
+			released, err := store.release(ctx, key, "owner")
+			require.NoError(t, err)
+			require.True(t, released)
+			_, err = store.acquire(ctx, key, "other", time.Minute)
+			require.NoError(t, err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+				t.Error("operator ctx is not cancelled after lock lost")
+			}
+			return nil, nil
+		}, param)
+		assert.ErrorIs(t, err, constant.ErrorCacheLockLost, key)
+
+		// 不会删除其他持有者的锁
+		value, err := store.get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, "other", value, key)
+		_, err = store.release(ctx, key, "other")
+		require.NoError(t, err)
+	}
+}
+
+func TestLockWatchdogTinyTimeout(t *testing.T) {
+	newTestManager(t)
+	ctx := context.TODO()
+	// timeout/3为0时使用最小续期间隔，不会panic
+	for _, key := range []string{"watchdog_tiny_lock.local", "watchdog_tiny_lock"} {
+		_, _ = AddCacheLockHandler(ctx, func(ctx context.Context) (interface{}, error) {
+			time.Sleep(5 * time.Millisecond)
+			return nil, nil
+		}, NewAddCacheLockParam(key, CacheLockWithTimeout(2*time.Nanosecond), CacheLockWithWatchdog(true)))
+	}
+	w := startLockWatchdog(ctx, "semaphore", "watchdog_tiny_semaphore", "holder", time.Nanosecond, func(ctx context.Context) (bool, error) {
+		return true, nil
+	})
+	time.Sleep(5 * time.Millisecond)
+	w.stop()
+	assert.False(t, w.isLost())
+}
@@ -0,0 +1,151 @@
+// Package cache @Author  wangjian    2023/9/18 10:20 AM
+package cache
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+)
+
+// Metrics 缓存指标的记录接口，默认使用MetricsRegistry，可通过SetMetrics替换为其他监控系统的实现
+// @Description: prefix为去掉namespace后key中第一个":"之前的部分，用于按业务区分指标，
+// cluster为redis连接的名称，Local storage及进程内的锁为空，
+// 实现需要保证并发安全，且不能阻塞缓存操作
+type Metrics interface {
+	// IncRequest 记录一次缓存操作，op为get、set、add、delete，result为hit、miss、ok、exists、error
+	IncRequest(storage Storage, cluster, prefix, op, result string)
+	// ObserveLatency 记录一次缓存操作的耗时，批量操作的op为mget、mset、mdelete，每个pipeline只记录一次
+	ObserveLatency(storage Storage, cluster, op string, latency time.Duration)
+	// IncEviction 记录因容量不足被淘汰的key
+	IncEviction(storage Storage, prefix string)
+	// ObserveLockWait 记录一次加锁，kind为lock、semaphore、read lock、write lock，
+	// retries为锁被占用后的重试次数，大于0表示发生了竞争
+	ObserveLockWait(kind, cluster, prefix string, acquired bool, retries int, wait time.Duration)
+}
+
+const (
+	metricsOpGet    = "get"
+	metricsOpSet    = "set"
+	metricsOpAdd    = "add"
+	metricsOpDelete = "delete"
+	metricsOpMGet   = "mget"
+	metricsOpMSet   = "mset"
+	metricsOpMDel   = "mdelete"
+
+	metricsResultHit    = "hit"
+	metricsResultMiss   = "miss"
+	metricsResultOK     = "ok"
+	metricsResultExists = "exists"
+	metricsResultError  = "error"
+)
+
+// nopMetrics 关闭指标时使用
+type nopMetrics struct{}
+
+func (nopMetrics) IncRequest(Storage, string, string, string, string)               {}
+func (nopMetrics) ObserveLatency(Storage, string, string, time.Duration)            {}
+func (nopMetrics) IncEviction(Storage, string)                                      {}
+func (nopMetrics) ObserveLockWait(string, string, string, bool, int, time.Duration) {}
+
+type metricsHolder struct {
+	metrics Metrics
+}
+
+var (
+	defaultMetricsRegistry = NewMetricsRegistry()
+	currentMetrics         atomic.Value
+)
+
+func init() {
+	currentMetrics.Store(metricsHolder{defaultMetricsRegistry})
+}
+
+// SetMetrics 替换记录指标的实现，m为nil时关闭指标
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = nopMetrics{}
+	}
+	currentMetrics.Store(metricsHolder{m})
+}
+
+// GetMetrics 获取当前记录指标的实现
+func GetMetrics() Metrics {
+	return currentMetrics.Load().(metricsHolder).metrics
+}
+
+// GetMetricsRegistry 获取默认的MetricsRegistry，可通过WritePrometheus或Handler输出
+func GetMetricsRegistry() *MetricsRegistry {
+	return defaultMetricsRegistry
+}
+
+// metricsKeyPrefix 未添加namespace的key中第一个":"之前的部分，没有":"时为空
+func metricsKeyPrefix(key string) string {
+	if idx := strings.Index(key, keySeparator); idx > 0 {
+		return key[:idx]
+	}
+	return ""
+}
+
+// metricsPrefix key为添加namespace后的key
+func (c *cacheManager) metricsPrefix(key string) string {
+	return metricsKeyPrefix(strings.TrimPrefix(key, c.keyPrefix))
+}
+
+// recordOp 根据操作的返回值记录请求数及耗时
+func (c *cacheManager) recordOp(storage Storage, cluster, op, key string, err error, startTime time.Time) {
+	m := GetMetrics()
+	m.IncRequest(storage, cluster, c.metricsPrefix(key), op, getMetricsResult(op, err))
+	m.ObserveLatency(storage, cluster, op, time.Since(startTime))
+}
+
+// recordBatch 批量操作按key记录请求数，耗时由调用方按整个pipeline记录
+func (c *cacheManager) recordBatch(storage Storage, cluster, op string, keys []string, err error) {
+	m := GetMetrics()
+	result := getMetricsResult(op, err)
+	for _, key := range keys {
+		m.IncRequest(storage, cluster, c.metricsPrefix(key), op, result)
+	}
+}
+
+func getMetricsResult(op string, err error) string {
+	switch {
+	case err == nil && op == metricsOpGet:
+		return metricsResultHit
+	case err == nil:
+		return metricsResultOK
+	case err == constant.ErrorCacheMiss:
+		return metricsResultMiss
+	case err == constant.ErrorNegativeCacheHit:
+		// 命中了空值标记，同样没有访问下游
+		return metricsResultHit
+	case err == constant.ErrorFailedOperation:
+		return metricsResultExists
+	}
+	return metricsResultError
+}
+
+// recordLockWait attempts为尝试加锁的次数，第一次之后的尝试都是因为锁被占用
+func recordLockWait(kind, cluster, prefix string, acquired bool, attempts int, enterTime time.Time) {
+	retries := attempts - 1
+	if retries < 0 {
+		retries = 0
+	}
+	GetMetrics().ObserveLockWait(kind, cluster, prefix, acquired, retries, time.Since(enterTime))
+}
+
+// slowOpThreshold 超过该耗时的缓存操作会打印warning日志，后台刷新的goroutine也会读取，使用atomic保存
+var slowOpThreshold = int64(defaultSlowOpThreshold)
+
+// setSlowOpThreshold d不大于0时使用默认值
+func setSlowOpThreshold(d time.Duration) {
+	if d <= 0 {
+		d = defaultSlowOpThreshold
+	}
+	atomic.StoreInt64(&slowOpThreshold, int64(d))
+}
+
+func getSlowOpThreshold() time.Duration {
+	return time.Duration(atomic.LoadInt64(&slowOpThreshold))
+}
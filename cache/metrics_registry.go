@@ -0,0 +1,381 @@
+// Package cache @Author  wangjian    2023/9/18 2:45 PM
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// 缓存操作耗时的分桶，单位秒
+	defaultLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+	// 等待锁耗时的分桶，单位秒
+	defaultLockWaitBuckets = []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30}
+)
+
+const (
+	metricsTypeCounter   = "counter"
+	metricsTypeGauge     = "gauge"
+	metricsTypeHistogram = "histogram"
+
+	// 不同prefix数量的上限，超过后记为metricsPrefixOther，避免key设计不当时指标数量无限增长
+	maxMetricsPrefixes  = 1000
+	metricsPrefixNone   = "none"
+	metricsPrefixOther  = "other"
+	metricsLabelSep     = "\xff"
+	prometheusMediaType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// MetricsRegistry 在内存中汇总缓存指标，并以Prometheus文本格式输出，
+// 记录指标只使用sync.Map及原子操作，不会使所有缓存操作竞争同一把锁
+type MetricsRegistry struct {
+	// 只保护gauges，families在创建后不再修改
+	mu       sync.Mutex
+	families map[string]*metricFamily
+	gauges   map[string]*gaugeFamily
+
+	prefixes    sync.Map // key为已记录的prefix
+	prefixCount atomic.Int64
+
+	requests   *metricFamily
+	hits       *metricFamily
+	misses     *metricFamily
+	errors     *metricFamily
+	sets       *metricFamily
+	evictions  *metricFamily
+	latency    *metricFamily
+	lockTotal  *metricFamily
+	lockRetry  *metricFamily
+	lockWait   *metricFamily
+	lockFailed *metricFamily
+}
+
+type metricFamily struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+	buckets    []float64
+	series     sync.Map // key为使用metricsLabelSep拼接的labels，value为*metricSeries
+}
+
+// metricSeries 浮点数以math.Float64bits保存，通过CAS累加
+type metricSeries struct {
+	labels []string
+	value  atomic.Uint64
+	// 每个分桶的数量，不累加，最后一个为超过所有分桶上限的数量，总数由各分桶相加得到，与+Inf分桶保持一致
+	counts []atomic.Uint64
+	sum    atomic.Uint64
+}
+
+type gaugeFamily struct {
+	help   string
+	series map[string]*gaugeFunc // key为格式化后的labels
+}
+
+type gaugeFunc struct {
+	labels string
+	f      func() float64
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	r := &MetricsRegistry{
+		families: make(map[string]*metricFamily),
+		gauges:   make(map[string]*gaugeFamily),
+	}
+	r.requests = r.newFamily("cache_requests_total", "Cache operations by storage, cluster, key prefix, op and result.", metricsTypeCounter, nil, "storage", "cluster", "prefix", "op", "result")
+	r.hits = r.newFamily("cache_hits_total", "Cache reads that found a value or a negative cache marker.", metricsTypeCounter, nil, "storage", "cluster", "prefix")
+	r.misses = r.newFamily("cache_misses_total", "Cache reads that found nothing.", metricsTypeCounter, nil, "storage", "cluster", "prefix")
+	r.errors = r.newFamily("cache_errors_total", "Cache operations that returned an error.", metricsTypeCounter, nil, "storage", "cluster", "prefix", "op")
+	r.sets = r.newFamily("cache_sets_total", "Values written to cache by Set, Add and MSet.", metricsTypeCounter, nil, "storage", "cluster", "prefix")
+	r.evictions = r.newFamily("cache_evictions_total", "Keys evicted because the cache reached its capacity.", metricsTypeCounter, nil, "storage", "prefix")
+	r.latency = r.newFamily("cache_op_duration_seconds", "Latency of cache operations.", metricsTypeHistogram, defaultLatencyBuckets, "storage", "cluster", "op")
+	r.lockTotal = r.newFamily("cache_lock_acquire_total", "Lock acquisitions by kind, cluster, key prefix and result.", metricsTypeCounter, nil, "kind", "cluster", "prefix", "result")
+	r.lockFailed = r.newFamily("cache_lock_failed_total", "Lock acquisitions that gave up waiting.", metricsTypeCounter, nil, "kind", "cluster", "prefix")
+	r.lockRetry = r.newFamily("cache_lock_contended_total", "Lock acquisitions that found the lock held and had to wait.", metricsTypeCounter, nil, "kind", "cluster", "prefix")
+	r.lockWait = r.newFamily("cache_lock_wait_seconds", "Time spent acquiring a lock, including waiting.", metricsTypeHistogram, defaultLockWaitBuckets, "kind", "cluster")
+	return r
+}
+
+func (r *MetricsRegistry) newFamily(name, help, typ string, buckets []float64, labelNames ...string) *metricFamily {
+	f := &metricFamily{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		buckets:    buckets,
+	}
+	r.families[name] = f
+	return f
+}
+
+func (r *MetricsRegistry) IncRequest(storage Storage, cluster, prefix, op, result string) {
+	prefix = r.limitPrefix(prefix)
+	r.add(r.requests, 1, storage.name(), cluster, prefix, op, result)
+	switch {
+	case result == metricsResultHit:
+		r.add(r.hits, 1, storage.name(), cluster, prefix)
+	case result == metricsResultMiss:
+		r.add(r.misses, 1, storage.name(), cluster, prefix)
+	case result == metricsResultError:
+		r.add(r.errors, 1, storage.name(), cluster, prefix, op)
+	case result == metricsResultOK && (op == metricsOpSet || op == metricsOpAdd || op == metricsOpMSet):
+		r.add(r.sets, 1, storage.name(), cluster, prefix)
+	}
+}
+
+func (r *MetricsRegistry) ObserveLatency(storage Storage, cluster, op string, latency time.Duration) {
+	r.observe(r.latency, latency.Seconds(), storage.name(), cluster, op)
+}
+
+func (r *MetricsRegistry) IncEviction(storage Storage, prefix string) {
+	r.add(r.evictions, 1, storage.name(), r.limitPrefix(prefix))
+}
+
+func (r *MetricsRegistry) ObserveLockWait(kind, cluster, prefix string, acquired bool, retries int, wait time.Duration) {
+	prefix = r.limitPrefix(prefix)
+	result := "acquired"
+	if !acquired {
+		result = "failed"
+		r.add(r.lockFailed, 1, kind, cluster, prefix)
+	}
+	r.add(r.lockTotal, 1, kind, cluster, prefix, result)
+	if retries > 0 {
+		r.add(r.lockRetry, 1, kind, cluster, prefix)
+	}
+	r.observe(r.lockWait, wait.Seconds(), kind, cluster)
+}
+
+// RegisterGaugeFunc
+//
+//	@Description: 注册输出时才计算的gauge，如local cache的key数量，
+//	labels为成对的label名称及值，如"cluster", "default"，名称及labels都相同的gauge会被覆盖
+//	@param name
+//	@param help
+//	@param f
+//	@param labels
+func (r *MetricsRegistry) RegisterGaugeFunc(name, help string, f func() float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	family, ok := r.gauges[name]
+	if !ok {
+		family = &gaugeFamily{series: make(map[string]*gaugeFunc)}
+		r.gauges[name] = family
+	}
+	family.help = help
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+escapeLabelValue(labels[i+1])+`"`)
+	}
+	joined := strings.Join(pairs, ",")
+	family.series[joined] = &gaugeFunc{labels: joined, f: f}
+}
+
+// Reset 清空所有已记录的指标，不影响已注册的gauge，与记录指标并发时可能丢失正在记录的值
+func (r *MetricsRegistry) Reset() {
+	for _, f := range r.families {
+		f.series.Range(func(key, _ interface{}) bool {
+			f.series.Delete(key)
+			return true
+		})
+	}
+	r.prefixes.Range(func(key, _ interface{}) bool {
+		r.prefixes.Delete(key)
+		r.prefixCount.Add(-1)
+		return true
+	})
+}
+
+// WritePrometheus 以Prometheus文本格式输出所有指标
+func (r *MetricsRegistry) WritePrometheus(w io.Writer) error {
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		writeFamily(bw, r.families[name])
+	}
+	r.mu.Lock()
+	gauges := make(map[string]*gaugeFamily, len(r.gauges))
+	for name, family := range r.gauges {
+		series := make(map[string]*gaugeFunc, len(family.series))
+		for key, g := range family.series {
+			series[key] = g
+		}
+		gauges[name] = &gaugeFamily{help: family.help, series: series}
+	}
+	r.mu.Unlock()
+
+	// gauge的计算可能需要获取其他锁，在锁外执行
+	gaugeNames := make([]string, 0, len(gauges))
+	for name := range gauges {
+		gaugeNames = append(gaugeNames, name)
+	}
+	sort.Strings(gaugeNames)
+	for _, name := range gaugeNames {
+		family := gauges[name]
+		writeHeader(bw, name, family.help, metricsTypeGauge)
+		keys := make([]string, 0, len(family.series))
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			g := family.series[key]
+			fmt.Fprintf(bw, "%s%s %s\n", name, wrapLabels(g.labels), formatFloat(g.f()))
+		}
+	}
+	return bw.Flush()
+}
+
+// Handler 输出Prometheus文本格式指标的http handler
+func (r *MetricsRegistry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", prometheusMediaType)
+		_ = r.WritePrometheus(w)
+	})
+}
+
+// limitPrefix 已记录的prefix达到maxMetricsPrefixes后，新的prefix记为metricsPrefixOther
+func (r *MetricsRegistry) limitPrefix(prefix string) string {
+	if prefix == "" {
+		return metricsPrefixNone
+	}
+	if _, ok := r.prefixes.Load(prefix); ok {
+		return prefix
+	}
+	// 先占用名额再写入，并发时已记录的prefix数量不会超过上限
+	for {
+		n := r.prefixCount.Load()
+		if n >= maxMetricsPrefixes {
+			return metricsPrefixOther
+		}
+		if r.prefixCount.CompareAndSwap(n, n+1) {
+			break
+		}
+	}
+	if _, loaded := r.prefixes.LoadOrStore(prefix, struct{}{}); loaded {
+		r.prefixCount.Add(-1)
+	}
+	return prefix
+}
+
+func (r *MetricsRegistry) add(f *metricFamily, v float64, labels ...string) {
+	addFloat(&f.getSeries(labels).value, v)
+}
+
+func (r *MetricsRegistry) observe(f *metricFamily, v float64, labels ...string) {
+	s := f.getSeries(labels)
+	idx := sort.SearchFloat64s(f.buckets, v)
+	s.counts[idx].Add(1)
+	addFloat(&s.sum, v)
+}
+
+// addFloat 通过CAS为以math.Float64bits保存的浮点数累加v
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func loadFloat(bits *atomic.Uint64) float64 {
+	return math.Float64frombits(bits.Load())
+}
+
+func (f *metricFamily) getSeries(labels []string) *metricSeries {
+	key := strings.Join(labels, metricsLabelSep)
+	if s, ok := f.series.Load(key); ok {
+		return s.(*metricSeries)
+	}
+	s := &metricSeries{labels: labels}
+	if f.typ == metricsTypeHistogram {
+		s.counts = make([]atomic.Uint64, len(f.buckets)+1)
+	}
+	actual, _ := f.series.LoadOrStore(key, s)
+	return actual.(*metricSeries)
+}
+
+func writeFamily(w *bufio.Writer, f *metricFamily) {
+	series := make(map[string]*metricSeries)
+	f.series.Range(func(key, value interface{}) bool {
+		series[key.(string)] = value.(*metricSeries)
+		return true
+	})
+	if len(series) == 0 {
+		return
+	}
+	writeHeader(w, f.name, f.help, f.typ)
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := series[key]
+		labels := formatLabels(f.labelNames, s.labels)
+		if f.typ != metricsTypeHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, wrapLabels(labels), formatFloat(loadFloat(&s.value)))
+			continue
+		}
+		var cumulative uint64
+		for i, upper := range f.buckets {
+			cumulative += s.counts[i].Load()
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, wrapLabels(joinLabels(labels, `le="`+formatFloat(upper)+`"`)), cumulative)
+		}
+		cumulative += s.counts[len(f.buckets)].Load()
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, wrapLabels(joinLabels(labels, `le="+Inf"`)), cumulative)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, wrapLabels(labels), formatFloat(loadFloat(&s.sum)))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, wrapLabels(labels), cumulative)
+	}
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabelValue(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
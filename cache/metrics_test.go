@@ -0,0 +1,167 @@
+// Package cache @Author  wangjian    2023/9/19 10:05 AM
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsKeyPrefix(t *testing.T) {
+	assert.Equal(t, "user", metricsKeyPrefix("user:1"))
+	assert.Equal(t, "", metricsKeyPrefix("user_1"))
+	assert.Equal(t, "", metricsKeyPrefix(":1"))
+
+	c := &cacheManager{keyPrefix: "order:v2:"}
+	assert.Equal(t, "user", c.metricsPrefix("order:v2:user:1"))
+}
+
+func TestGetMetricsResult(t *testing.T) {
+	assert.Equal(t, metricsResultHit, getMetricsResult(metricsOpGet, nil))
+	assert.Equal(t, metricsResultHit, getMetricsResult(metricsOpGet, constant.ErrorNegativeCacheHit))
+	assert.Equal(t, metricsResultMiss, getMetricsResult(metricsOpGet, constant.ErrorCacheMiss))
+	assert.Equal(t, metricsResultOK, getMetricsResult(metricsOpSet, nil))
+	assert.Equal(t, metricsResultExists, getMetricsResult(metricsOpAdd, constant.ErrorFailedOperation))
+	assert.Equal(t, metricsResultError, getMetricsResult(metricsOpSet, fmt.Errorf("timeout")))
+}
+
+func TestMetricsRegistryWritePrometheus(t *testing.T) {
+	r := NewMetricsRegistry()
+	r.IncRequest(Main, "default", "user", metricsOpGet, metricsResultHit)
+	r.IncRequest(Main, "default", "user", metricsOpGet, metricsResultMiss)
+	r.IncRequest(Main, "default", "", metricsOpSet, metricsResultError)
+	r.IncRequest(Local, "", "user", metricsOpSet, metricsResultOK)
+	r.IncEviction(Local, `a"b`)
+	r.ObserveLatency(Main, "default", metricsOpGet, 3*time.Millisecond)
+	r.ObserveLockWait("lock", "lock", "order", false, 2, 200*time.Millisecond)
+	r.RegisterGaugeFunc("cache_local_items", "Keys in local cache.", func() float64 { return 7 })
+	r.RegisterGaugeFunc("cache_redis_circuit_state", "Circuit state.", func() float64 { return 0 }, "cluster", "default")
+	r.RegisterGaugeFunc("cache_redis_circuit_state", "Circuit state.", func() float64 { return 1 }, "cluster", "session")
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, r.WritePrometheus(buf))
+	out := buf.String()
+	assert.Contains(t, out, "# TYPE cache_requests_total counter\n")
+	assert.Contains(t, out, `cache_requests_total{storage="main",cluster="default",prefix="user",op="get",result="hit"} 1`)
+	assert.Contains(t, out, `cache_hits_total{storage="main",cluster="default",prefix="user"} 1`)
+	assert.Contains(t, out, `cache_misses_total{storage="main",cluster="default",prefix="user"} 1`)
+	assert.Contains(t, out, `cache_errors_total{storage="main",cluster="default",prefix="none",op="set"} 1`)
+	assert.Contains(t, out, `cache_sets_total{storage="local",cluster="",prefix="user"} 1`)
+	assert.Contains(t, out, `cache_evictions_total{storage="local",prefix="a\"b"} 1`)
+	assert.Contains(t, out, `cache_op_duration_seconds_bucket{storage="main",cluster="default",op="get",le="0.0025"} 0`)
+	assert.Contains(t, out, `cache_op_duration_seconds_bucket{storage="main",cluster="default",op="get",le="0.005"} 1`)
+	assert.Contains(t, out, `cache_op_duration_seconds_bucket{storage="main",cluster="default",op="get",le="+Inf"} 1`)
+	assert.Contains(t, out, `cache_op_duration_seconds_count{storage="main",cluster="default",op="get"} 1`)
+	assert.Contains(t, out, `cache_lock_acquire_total{kind="lock",cluster="lock",prefix="order",result="failed"} 1`)
+	assert.Contains(t, out, `cache_lock_failed_total{kind="lock",cluster="lock",prefix="order"} 1`)
+	assert.Contains(t, out, `cache_lock_contended_total{kind="lock",cluster="lock",prefix="order"} 1`)
+	assert.Contains(t, out, `cache_lock_wait_seconds_bucket{kind="lock",cluster="lock",le="0.5"} 1`)
+	assert.Contains(t, out, "cache_local_items 7\n")
+	assert.Contains(t, out, "# TYPE cache_redis_circuit_state gauge\ncache_redis_circuit_state{cluster=\"default\"} 0\ncache_redis_circuit_state{cluster=\"session\"} 1\n")
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, prometheusMediaType, rec.Header().Get("Content-Type"))
+	assert.Equal(t, out, rec.Body.String())
+
+	r.Reset()
+	buf.Reset()
+	require.NoError(t, r.WritePrometheus(buf))
+	assert.True(t, strings.HasPrefix(buf.String(), "# HELP cache_local_items Keys in local cache.\n# TYPE cache_local_items gauge\ncache_local_items 7\n"))
+	assert.NotContains(t, buf.String(), "cache_requests_total")
+}
+
+func TestMetricsRegistryLimitPrefix(t *testing.T) {
+	r := NewMetricsRegistry()
+	for i := 0; i < maxMetricsPrefixes; i++ {
+		r.IncRequest(Local, "", fmt.Sprintf("p%d", i), metricsOpGet, metricsResultHit)
+	}
+	r.IncRequest(Local, "", "overflow", metricsOpGet, metricsResultHit)
+	r.IncRequest(Local, "", "p0", metricsOpGet, metricsResultHit)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, r.WritePrometheus(buf))
+	assert.Contains(t, buf.String(), `cache_hits_total{storage="local",cluster="",prefix="other"} 1`)
+	assert.Contains(t, buf.String(), `cache_hits_total{storage="local",cluster="",prefix="p0"} 2`)
+	assert.NotContains(t, buf.String(), "overflow")
+}
+
+func TestMetricsRegistryConcurrent(t *testing.T) {
+	r := NewMetricsRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				r.IncRequest(Main, "default", fmt.Sprintf("p%d", j%4), metricsOpGet, metricsResultHit)
+				r.ObserveLatency(Main, "default", metricsOpGet, time.Duration(i)*time.Second)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, r.WritePrometheus(buf))
+	out := buf.String()
+	for i := 0; i < 4; i++ {
+		assert.Contains(t, out, fmt.Sprintf(`cache_hits_total{storage="main",cluster="default",prefix="p%d"} 2000`, i))
+	}
+	assert.Contains(t, out, `cache_op_duration_seconds_bucket{storage="main",cluster="default",op="get",le="5"} 6000`)
+	assert.Contains(t, out, `cache_op_duration_seconds_bucket{storage="main",cluster="default",op="get",le="+Inf"} 8000`)
+	assert.Contains(t, out, `cache_op_duration_seconds_sum{storage="main",cluster="default",op="get"} 28000`)
+	assert.Contains(t, out, `cache_op_duration_seconds_count{storage="main",cluster="default",op="get"} 8000`)
+}
+
+func TestCacheManagerRecordsMetrics(t *testing.T) {
+	r := NewMetricsRegistry()
+	SetMetrics(r)
+	defer SetMetrics(defaultMetricsRegistry)
+
+	newTestManager(t)
+	lc := NewLocalCacheManager(NewLRUStore(LocalStoreWithMaxItems(1)))
+	client.localCacheClient = lc
+	client.keyPrefix = "svc:"
+	initMetrics(false, lc, client.getClusters(), nil)
+
+	ctx := context.Background()
+	receiver := new(string)
+	require.Equal(t, constant.ErrorCacheMiss, client.Get(ctx, "user:1", receiver, CallWithStorage(Local)))
+	require.NoError(t, client.Set(ctx, "user:1", "a", time.Minute, CallWithStorage(Local)))
+	require.NoError(t, client.Get(ctx, "user:1", receiver, CallWithStorage(Local)))
+	require.Equal(t, constant.ErrorFailedOperation, client.Add(ctx, "user:1", "b", time.Minute, CallWithStorage(Local)))
+	// 容量为1，写入第二个key淘汰第一个
+	require.NoError(t, client.Set(ctx, "order:1", "a", time.Minute, CallWithStorage(Local)))
+	require.NoError(t, client.Set(ctx, "user:2", "a", time.Minute))
+	require.NoError(t, client.Get(ctx, "user:2", receiver))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, r.WritePrometheus(buf))
+	out := buf.String()
+	assert.Contains(t, out, `cache_hits_total{storage="local",cluster="",prefix="user"} 1`)
+	assert.Contains(t, out, `cache_misses_total{storage="local",cluster="",prefix="user"} 1`)
+	assert.Contains(t, out, `cache_sets_total{storage="local",cluster="",prefix="user"} 1`)
+	assert.Contains(t, out, `cache_requests_total{storage="local",cluster="",prefix="user",op="add",result="exists"} 1`)
+	assert.Contains(t, out, `cache_evictions_total{storage="local",prefix="user"} 1`)
+	assert.Contains(t, out, `cache_op_duration_seconds_count{storage="local",cluster="",op="get"} 2`)
+	assert.Contains(t, out, `cache_hits_total{storage="main",cluster="default",prefix="user"} 1`)
+	assert.Contains(t, out, `cache_sets_total{storage="main",cluster="default",prefix="user"} 1`)
+}
+
+func TestSlowOpThreshold(t *testing.T) {
+	defer setSlowOpThreshold(0)
+	assert.Equal(t, defaultSlowOpThreshold, getSlowOpThreshold())
+	setSlowOpThreshold(50 * time.Millisecond)
+	assert.Equal(t, 50*time.Millisecond, getSlowOpThreshold())
+	setSlowOpThreshold(-1)
+	assert.Equal(t, defaultSlowOpThreshold, getSlowOpThreshold())
+}
@@ -0,0 +1,221 @@
+// Package cache @Author  wangjian    2023/9/11 10:45 AM
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	cacheConfig "github.com/JianWangEx/commonService/cache/config"
+	"github.com/JianWangEx/commonService/constant"
+	logger "github.com/JianWangEx/commonService/log"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// redlockStore
+//
+//	@Description: Redlock算法实现的lockStore，在相互独立的多个redis节点上加锁，
+//	在锁的有效时间内获得多数节点时才算获得锁，单个节点故障或主从切换不会让同一把锁被两个持有者获得
+type redlockStore struct {
+	nodes       []redis.UniversalClient
+	nodeTimeout time.Duration
+	driftFactor float64
+}
+
+func getRedlockStore() *redlockStore {
+	config := cacheConfig.GetCacheConfig()
+	if len(config.RedlockAddrs) == 0 {
+		return nil
+	}
+	store := &redlockStore{
+		nodes:       make([]redis.UniversalClient, 0, len(config.RedlockAddrs)),
+		nodeTimeout: time.Duration(config.RedlockNodeTimeout) * time.Millisecond,
+		driftFactor: config.RedlockClockDriftFactor,
+	}
+	if store.nodeTimeout <= 0 {
+		store.nodeTimeout = defaultRedlockNodeTimeout
+	}
+	if store.driftFactor <= 0 {
+		store.driftFactor = defaultRedlockClockDriftFactor
+	}
+	for _, addr := range config.RedlockAddrs {
+		store.nodes = append(store.nodes, redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Username: config.RedlockUsername,
+			Password: config.RedlockPassword,
+			DB:       config.RedlockDB,
+		}))
+	}
+	return store
+}
+
+// close 关闭所有节点的连接，节点由getRedlockStore创建，只属于该store
+func (s *redlockStore) close() error {
+	var closeErr error
+	for idx, node := range s.nodes {
+		if err := node.Close(); err != nil && closeErr == nil {
+			closeErr = errors.Wrapf(err, "redlock node=%d", idx)
+		}
+	}
+	return closeErr
+}
+
+func (s *redlockStore) quorum() int {
+	return len(s.nodes)/2 + 1
+}
+
+// validity 获得锁后剩余的有效时间，需要减去获取锁的耗时和各节点间的时钟漂移
+func (s *redlockStore) validity(ttl time.Duration, elapsed time.Duration) time.Duration {
+	drift := time.Duration(float64(ttl)*s.driftFactor) + redlockClockDriftConstant
+	return ttl - elapsed - drift
+}
+
+// each 在所有节点上并发执行f，每个节点使用独立的超时时间，返回f成功的节点数量和出错的节点数量
+func (s *redlockStore) each(ctx context.Context, f func(ctx context.Context, idx int, node redis.UniversalClient) (bool, error)) (int, int, error) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		success  int
+		failed   int
+		firstErr error
+	)
+	for idx, node := range s.nodes {
+		wg.Add(1)
+		go func(idx int, node redis.UniversalClient) {
+			defer wg.Done()
+			nodeCtx, cancel := context.WithTimeout(ctx, s.nodeTimeout)
+			defer cancel()
+			ok, err := f(nodeCtx, idx, node)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed++
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			if ok {
+				success++
+			}
+		}(idx, node)
+	}
+	wg.Wait()
+	return success, failed, firstErr
+}
+
+// acquire 在多数节点上获得锁且剩余有效时间大于0时成功，返回各节点fencing计数器的最大值，
+// 并将获得锁的节点上的计数器提高到该值，使任意两次加锁的多数派交集节点上的token递增；
+// 失败时释放所有节点上的锁，包括超时但可能已经写入的节点
+func (s *redlockStore) acquire(ctx context.Context, key string, value string, ttl time.Duration) (int64, error) {
+	if len(s.nodes) == 0 {
+		return 0, errors.WithStack(constant.ErrorRedlockNotConfigured)
+	}
+	startTime := time.Now()
+	tokens := make([]int64, len(s.nodes))
+	fencingKey := getFencingKey(key)
+	success, failed, err := s.each(ctx, func(ctx context.Context, idx int, node redis.UniversalClient) (bool, error) {
+		token, err := acquireLockScript.Run(ctx, node, []string{key, fencingKey}, value, ttl.Milliseconds()).Int64()
+		tokens[idx] = token
+		return token > 0, err
+	})
+	elapsed := time.Since(startTime)
+
+	if success >= s.quorum() && (ttl <= 0 || s.validity(ttl, elapsed) > 0) {
+		var token int64
+		for _, t := range tokens {
+			if t > token {
+				token = t
+			}
+		}
+		_, _, raiseErr := s.each(ctx, func(ctx context.Context, idx int, node redis.UniversalClient) (bool, error) {
+			if tokens[idx] == 0 {
+				return false, nil
+			}
+			return true, raiseFencingScript.Run(ctx, node, []string{fencingKey}, token).Err()
+		})
+		if raiseErr != nil {
+			logger.CtxSugar(ctx).Warnf("redlock raise fencing token failed|key=%s, token=%d, err=%+v", key, token, raiseErr)
+		}
+		return token, nil
+	}
+
+	if _, err := s.release(ctx, key, value); err != nil {
+		logger.CtxSugar(ctx).Warnf("redlock release after acquire failed|key=%s, err=%+v", key, err)
+	}
+	// 出错的节点过多，不可能获得多数节点
+	if failed > len(s.nodes)-s.quorum() {
+		return 0, errors.Wrap(err, "redlock acquire error")
+	}
+	return 0, nil
+}
+
+// release 释放所有节点上的锁，多数节点释放成功时返回true
+func (s *redlockStore) release(ctx context.Context, key string, value string) (bool, error) {
+	if len(s.nodes) == 0 {
+		return false, errors.WithStack(constant.ErrorRedlockNotConfigured)
+	}
+	success, failed, err := s.each(ctx, func(ctx context.Context, idx int, node redis.UniversalClient) (bool, error) {
+		n, err := compareAndDeleteScript.Run(ctx, node, []string{key}, value).Int64()
+		return n == 1, err
+	})
+	if failed > len(s.nodes)-s.quorum() {
+		return false, errors.Wrap(err, "redlock release error")
+	}
+	return success >= s.quorum(), nil
+}
+
+// refresh 在所有节点上续期，多数节点续期成功且耗时小于ttl时返回true
+func (s *redlockStore) refresh(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	if len(s.nodes) == 0 {
+		return false, errors.WithStack(constant.ErrorRedlockNotConfigured)
+	}
+	startTime := time.Now()
+	success, failed, err := s.each(ctx, func(ctx context.Context, idx int, node redis.UniversalClient) (bool, error) {
+		n, err := refreshLockScript.Run(ctx, node, []string{key}, value, ttl.Milliseconds()).Int64()
+		return n == 1, err
+	})
+	if success >= s.quorum() && s.validity(ttl, time.Since(startTime)) > 0 {
+		return true, nil
+	}
+	if failed > len(s.nodes)-s.quorum() {
+		return false, errors.Wrap(err, "redlock refresh error")
+	}
+	return false, nil
+}
+
+// subscribe Redlock不支持释放通知，等待者只能轮询
+func (s *redlockStore) subscribe(ctx context.Context, key string) (<-chan struct{}, func()) {
+	return nil, func() {}
+}
+
+// get 返回多数节点上相同的锁的值，没有多数节点一致时返回空字符串
+func (s *redlockStore) get(ctx context.Context, key string) (string, error) {
+	if len(s.nodes) == 0 {
+		return "", errors.WithStack(constant.ErrorRedlockNotConfigured)
+	}
+	values := make([]string, len(s.nodes))
+	_, failed, err := s.each(ctx, func(ctx context.Context, idx int, node redis.UniversalClient) (bool, error) {
+		value, err := node.Get(ctx, key).Result()
+		if err == redis.Nil {
+			return false, nil
+		}
+		values[idx] = value
+		return err == nil, err
+	})
+	if failed > len(s.nodes)-s.quorum() {
+		return "", errors.Wrap(err, "redlock get error")
+	}
+	counts := make(map[string]int)
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		counts[value]++
+		if counts[value] >= s.quorum() {
+			return value, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,144 @@
+// Package cache @Author  wangjian    2023/9/11 4:20 PM
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cacheConfig "github.com/JianWangEx/commonService/cache/config"
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedlockQuorum(t *testing.T) {
+	for n, quorum := range map[int]int{1: 1, 2: 2, 3: 2, 4: 3, 5: 3} {
+		s := &redlockStore{nodes: make([]redis.UniversalClient, n)}
+		assert.Equal(t, quorum, s.quorum(), "nodes=%d", n)
+	}
+}
+
+func TestRedlockValidity(t *testing.T) {
+	s := &redlockStore{driftFactor: 0.01}
+	// 10s * 0.01 + 2ms
+	assert.Equal(t, 10*time.Second-100*time.Millisecond-redlockClockDriftConstant-time.Second, s.validity(10*time.Second, time.Second))
+	assert.LessOrEqual(t, s.validity(100*time.Millisecond, 100*time.Millisecond), time.Duration(0))
+}
+
+func TestRedlockNotConfigured(t *testing.T) {
+	ctx := context.TODO()
+	_, err := (&redlockStore{}).acquire(ctx, "k", "v", time.Second)
+	assert.ErrorIs(t, err, constant.ErrorRedlockNotConfigured)
+
+	newTestManager(t)
+	called := false
+	_, err = AddCacheLockHandler(ctx, func(ctx context.Context) (interface{}, error) {
+		called = true
+		return nil, nil
+	}, NewAddCacheLockParam("redlock_test_lock", CacheLockWithRedlock(true)))
+	require.Error(t, err)
+	assert.False(t, called)
+}
+
+// newTestRedlockStore 使用n个独立的miniredis节点
+func newTestRedlockStore(t *testing.T, n int) (*redlockStore, []*miniredis.Miniredis) {
+	store := &redlockStore{nodeTimeout: 100 * time.Millisecond, driftFactor: defaultRedlockClockDriftFactor}
+	servers := make([]*miniredis.Miniredis, 0, n)
+	for i := 0; i < n; i++ {
+		server := miniredis.RunT(t)
+		node := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+		t.Cleanup(func() {
+			_ = node.Close()
+		})
+		servers = append(servers, server)
+		store.nodes = append(store.nodes, node)
+	}
+	return store, servers
+}
+
+func TestRedlockAcquireRelease(t *testing.T) {
+	store, servers := newTestRedlockStore(t, 3)
+	ctx := context.TODO()
+	key := "redlock_test_key"
+	fencingKey := getFencingKey(key)
+
+	// 一个节点上的锁被其他持有者占用，其余两个节点构成多数
+	require.NoError(t, servers[2].Set(key, "other"))
+	require.NoError(t, servers[0].Set(fencingKey, "10"))
+	token, err := store.acquire(ctx, key, "v1", time.Second)
+	require.NoError(t, err)
+	// token为各节点计数器的最大值，并提高到获得锁的节点上
+	assert.Equal(t, int64(11), token)
+	for _, server := range servers[:2] {
+		server.CheckGet(t, key, "v1")
+		server.CheckGet(t, fencingKey, "11")
+	}
+	value, err := store.get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", value)
+
+	ok, err := store.release(ctx, key, "v1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, servers[0].Exists(key))
+	assert.False(t, servers[1].Exists(key))
+	// 不释放其他持有者的锁
+	servers[2].CheckGet(t, key, "other")
+
+	next, err := store.acquire(ctx, key, "v2", time.Second)
+	require.NoError(t, err)
+	assert.Greater(t, next, token)
+}
+
+func TestRedlockAcquireMinority(t *testing.T) {
+	store, servers := newTestRedlockStore(t, 3)
+	ctx := context.TODO()
+	key := "redlock_test_key"
+
+	// 多数节点上的锁被其他持有者占用，获得的少数节点上的锁需要释放
+	require.NoError(t, servers[1].Set(key, "other"))
+	require.NoError(t, servers[2].Set(key, "other"))
+	token, err := store.acquire(ctx, key, "v1", time.Second)
+	require.NoError(t, err)
+	assert.Zero(t, token)
+	assert.False(t, servers[0].Exists(key))
+
+	// 只有少数节点可达
+	servers[0].Del(key)
+	servers[1].Close()
+	servers[2].Close()
+	token, err = store.acquire(ctx, key, "v1", time.Second)
+	require.Error(t, err)
+	assert.Zero(t, token)
+	assert.False(t, servers[0].Exists(key))
+}
+
+func TestRedlockNodesClosed(t *testing.T) {
+	config := cacheConfig.GetCacheConfig()
+	old := config.RedlockConfig
+	config.RedlockConfig = cacheConfig.RedlockConfig{RedlockAddrs: []string{miniredis.RunT(t).Addr(), miniredis.RunT(t).Addr()}}
+	t.Cleanup(func() {
+		config.RedlockConfig = old
+	})
+	newTestManager(t)
+	ctx := context.TODO()
+
+	// 重新初始化时关闭之前实例的节点
+	nodes := client.redlock.nodes
+	for _, node := range nodes {
+		require.NoError(t, node.Ping(ctx).Err())
+	}
+	require.NoError(t, InitWithClient(GetRedisClient()))
+	for _, node := range nodes {
+		assert.ErrorIs(t, node.Ping(ctx).Err(), redis.ErrClosed)
+	}
+
+	nodes = client.redlock.nodes
+	require.NoError(t, Close())
+	for _, node := range nodes {
+		assert.ErrorIs(t, node.Ping(ctx).Err(), redis.ErrClosed)
+	}
+}
@@ -0,0 +1,201 @@
+// Package cache @Author  wangjian    2023/8/24 2:10 PM
+package cache
+
+import (
+	"github.com/JianWangEx/commonService/internal/redisscript"
+	"github.com/redis/go-redis/v9"
+)
+
+// compareAndDeleteScript 仅当key的值等于ARGV[1]时删除，避免删除其他持有者的锁
+var compareAndDeleteScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// addTagScript 将ARGV[2]及之后的key加入tag集合KEYS[1]，并将集合的过期时间延长至不小于ARGV[1](毫秒)，
+// ARGV[1]为0表示成员永不过期，此时集合也不过期
+var addTagScript = redis.NewScript(`
+local existed = redis.call("EXISTS", KEYS[1])
+for i = 2, #ARGV do
+	redis.call("SADD", KEYS[1], ARGV[i])
+end
+local ttl = tonumber(ARGV[1])
+if ttl <= 0 then
+	redis.call("PERSIST", KEYS[1])
+	return 1
+end
+local current = redis.call("PTTL", KEYS[1])
+if existed == 0 or (current >= 0 and current < ttl) then
+	redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return 1
+`)
+
+// releaseLockScript 仅当锁KEYS[1]的值等于ARGV[1]时删除，并向channel ARGV[2]发布释放消息唤醒等待者
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("DEL", KEYS[1])
+	redis.call("PUBLISH", ARGV[2], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+// acquireLockScript 仅当KEYS[1]不存在时写入ARGV[1]并设置过期时间ARGV[2](毫秒，0表示不过期)，
+// 成功时对fencing计数器KEYS[2]加一并返回新的token，失败返回0；
+// 两个key需要在同一个slot中，见getFencingKey
+var acquireLockScript = redis.NewScript(`
+local ok
+if tonumber(ARGV[2]) > 0 then
+	ok = redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2])
+else
+	ok = redis.call("SET", KEYS[1], ARGV[1], "NX")
+end
+if ok then
+	return redis.call("INCR", KEYS[2])
+end
+return 0
+`)
+
+// refreshLockScript 仅当key的值等于ARGV[1]时将过期时间重置为ARGV[2](毫秒)
+var refreshLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// raiseFencingScript 将fencing计数器KEYS[1]提高到不小于ARGV[1]，
+// Redlock获得锁后对多数节点执行，保证任意两个多数派的交集节点上计数器不小于上一次的token
+var raiseFencingScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+if current < tonumber(ARGV[1]) then
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// acquireFairLockScript 公平锁，KEYS: 锁、fencing计数器、等待队列(list)、等待者过期时间(zset)，
+// ARGV: 锁的值、锁的过期时间(毫秒)、当前时间(unix毫秒)、等待者有效时间(毫秒)；
+// 先移出队首已过期的等待者，锁空闲且队列为空或自己位于队首时获得锁并返回新的token，
+// 否则加入队尾(已在队列中时只刷新过期时间)并返回0
+var acquireFairLockScript = redis.NewScript(`
+local now = tonumber(ARGV[3])
+while true do
+	local first = redis.call("LINDEX", KEYS[3], 0)
+	if not first then
+		break
+	end
+	local deadline = tonumber(redis.call("ZSCORE", KEYS[4], first))
+	if deadline and deadline > now then
+		break
+	end
+	redis.call("LPOP", KEYS[3])
+	redis.call("ZREM", KEYS[4], first)
+end
+local first = redis.call("LINDEX", KEYS[3], 0)
+if redis.call("EXISTS", KEYS[1]) == 0 and ((not first) or first == ARGV[1]) then
+	if tonumber(ARGV[2]) > 0 then
+		redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	else
+		redis.call("SET", KEYS[1], ARGV[1])
+	end
+	if first then
+		redis.call("LPOP", KEYS[3])
+		redis.call("ZREM", KEYS[4], ARGV[1])
+	end
+	return redis.call("INCR", KEYS[2])
+end
+if not redis.call("ZSCORE", KEYS[4], ARGV[1]) then
+	redis.call("RPUSH", KEYS[3], ARGV[1])
+end
+redis.call("ZADD", KEYS[4], now + tonumber(ARGV[4]), ARGV[1])
+redis.call("PEXPIRE", KEYS[3], ARGV[4])
+redis.call("PEXPIRE", KEYS[4], ARGV[4])
+return 0
+`)
+
+// leaveLockQueueScript 等待者放弃等待时从公平锁的等待队列KEYS[1]和KEYS[2]中移除ARGV[1]
+var leaveLockQueueScript = redis.NewScript(`
+redis.call("LREM", KEYS[1], 0, ARGV[1])
+redis.call("ZREM", KEYS[2], ARGV[1])
+return 1
+`)
+
+// luaNowMillis 使用redis服务器的时间计算持有者的过期时间，extend_expire只延长不缩短key的过期时间
+const luaNowMillis = redisscript.NowMillis + `
+local function extend_expire(key, ttl)
+	local current = redis.call("PTTL", key)
+	if current < ttl then
+		redis.call("PEXPIRE", key, ttl)
+	end
+end
+`
+
+// acquireSemaphoreScript 持有者集合KEYS[1]为zset，score为持有者的过期时间(unix毫秒)，
+// 移除过期的持有者后持有者数量小于ARGV[2]时加入ARGV[1]，过期时间为ARGV[3](毫秒)后；已是持有者时只刷新过期时间
+var acquireSemaphoreScript = redis.NewScript(luaNowMillis + `
+local now = now_millis()
+local ttl = tonumber(ARGV[3])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+if redis.call("ZSCORE", KEYS[1], ARGV[1]) or redis.call("ZCARD", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("ZADD", KEYS[1], now + ttl, ARGV[1])
+	extend_expire(KEYS[1], ttl)
+	return 1
+end
+return 0
+`)
+
+// refreshHolderScript 仍是持有者集合KEYS[1]的成员时将ARGV[1]的过期时间重置为ARGV[2](毫秒)后
+var refreshHolderScript = redis.NewScript(luaNowMillis + `
+local now = now_millis()
+local ttl = tonumber(ARGV[2])
+local expireAt = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if expireAt and tonumber(expireAt) > now then
+	redis.call("ZADD", KEYS[1], now + ttl, ARGV[1])
+	extend_expire(KEYS[1], ttl)
+	return 1
+end
+return 0
+`)
+
+// releaseHolderScript 从持有者集合KEYS[1]中移除ARGV[1]，移除成功时向channel ARGV[2]发布释放消息
+var releaseHolderScript = redis.NewScript(`
+local removed = redis.call("ZREM", KEYS[1], ARGV[1])
+if removed == 1 then
+	redis.call("PUBLISH", ARGV[2], ARGV[1])
+end
+return removed
+`)
+
+// acquireReadLockScript KEYS: 读者集合(zset)、写锁、等待中的写者，ARGV: 持有者、过期时间(毫秒)；
+// 写锁被持有或有写者在等待时失败，避免写者饥饿
+var acquireReadLockScript = redis.NewScript(luaNowMillis + `
+if redis.call("EXISTS", KEYS[2]) == 1 or redis.call("EXISTS", KEYS[3]) == 1 then
+	return 0
+end
+local now = now_millis()
+local ttl = tonumber(ARGV[2])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+redis.call("ZADD", KEYS[1], now + ttl, ARGV[1])
+extend_expire(KEYS[1], ttl)
+return 1
+`)
+
+// acquireWriteLockScript KEYS: 读者集合(zset)、写锁、等待中的写者，ARGV: 持有者、过期时间(毫秒)、等待标记的有效时间(毫秒)；
+// 没有写锁和未过期的读者时获得写锁，否则标记有写者在等待，阻止新的读者加入
+var acquireWriteLockScript = redis.NewScript(luaNowMillis + `
+local now = now_millis()
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+if redis.call("EXISTS", KEYS[2]) == 0 and redis.call("ZCARD", KEYS[1]) == 0 then
+	redis.call("SET", KEYS[2], ARGV[1], "PX", ARGV[2])
+	if redis.call("GET", KEYS[3]) == ARGV[1] then
+		redis.call("DEL", KEYS[3])
+	end
+	return 1
+end
+redis.call("SET", KEYS[3], ARGV[1], "PX", ARGV[3])
+return 0
+`)
@@ -0,0 +1,121 @@
+// Package cache @Author  wangjian    2023/9/2 11:20 AM
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// getTagKey tag在redis中对应的集合，集合成员为带有该tag的缓存key(包含namespace前缀)
+func (c *cacheManager) getTagKey(tag string) string {
+	return c.buildKey(strings.Join([]string{tagKeyPrefix, tag}, ""))
+}
+
+// addTags
+//
+//	@Description: 将key加入每个tag的集合，集合的过期时间不小于其中最晚过期的key，
+//	不论key使用哪种storage，tag集合都保存在key路由到的redis连接中，因此任意实例都可以根据tag删除。
+//	key按redis连接分组，每个连接使用一个pipeline，每个tag执行一次脚本
+func (c *cacheManager) addTags(ctx context.Context, expired time.Duration, opt *callOption, keys ...string) error {
+	if len(opt.tags) == 0 {
+		return nil
+	}
+	groups, err := c.groupByCluster(opt.cluster, keys)
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		args := make([]interface{}, 0, len(group.keys)+1)
+		args = append(args, expired.Milliseconds())
+		for _, key := range group.keys {
+			args = append(args, key)
+		}
+		// pipeline中EvalSha的NOSCRIPT错误在执行后才返回，无法回退到Eval，因此直接使用Eval
+		_, err := group.cluster.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, tag := range opt.tags {
+				addTagScript.Eval(ctx, pipe, []string{c.getTagKey(tag)}, args...)
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "redis cache add tag error|cluster=%s, tags=%s", group.cluster.name, strings.Join(opt.tags, ","))
+		}
+	}
+	return nil
+}
+
+// addTagsOrDegrade 降级期间无法记录tag，忽略熔断的错误
+func (c *cacheManager) addTagsOrDegrade(ctx context.Context, expired time.Duration, opt *callOption, keys ...string) error {
+	if err := c.addTags(ctx, expired, opt, keys...); err != nil && !c.degraded(err) {
+		return err
+	}
+	return nil
+}
+
+// InvalidateTags
+//
+//	@Description: 删除带有任意一个tag的所有key，同时作用于每个redis连接和local cache，
+//	开启实例间失效同步时其他实例的local cache也会被删除
+//	@param ctx
+//	@param tags
+//	@return error
+func (c *cacheManager) InvalidateTags(ctx context.Context, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	for _, cluster := range c.getClusters() {
+		if err := c.invalidateClusterTags(ctx, cluster, tags); err != nil {
+			return errors.Wrapf(err, "cluster=%s", cluster.name)
+		}
+	}
+	return nil
+}
+
+// invalidateClusterTags tag集合与其中的key在同一个redis连接中
+func (c *cacheManager) invalidateClusterTags(ctx context.Context, cluster *redisCluster, tags []string) error {
+	tagKeys := make([]string, 0, len(tags))
+	cmds := make([]*redis.StringSliceCmd, 0, len(tags))
+	_, err := cluster.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, tag := range tags {
+			tagKey := c.getTagKey(tag)
+			tagKeys = append(tagKeys, tagKey)
+			cmds = append(cmds, pipe.SMembers(ctx, tagKey))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return errors.Wrap(err, "redis cache get tag members error")
+	}
+
+	keySet := make(map[string]struct{})
+	for _, cmd := range cmds {
+		for _, key := range cmd.Val() {
+			keySet[key] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+		c.localCacheClient.Delete(ctx, key)
+	}
+
+	// 集群模式下多个key可能不在同一个slot，逐个DEL
+	_, err = cluster.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		for _, tagKey := range tagKeys {
+			pipe.Del(ctx, tagKey)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "redis cache invalidate tags error")
+	}
+	c.publishInvalidation(ctx, keys...)
+	return nil
+}
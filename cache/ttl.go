@@ -0,0 +1,125 @@
+// Package cache @Author  wangjian    2023/10/13 11:20 AM
+package cache
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	cacheConfig "github.com/JianWangEx/commonService/cache/config"
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/pkg/errors"
+)
+
+// DefaultTTL 作为Set、Add、MSet的expired时，使用TTLRules中匹配key前缀的过期时间，
+// 没有匹配的规则时使用DefaultCacheTTL，未设置WithTimeout的AddCacheParam同样使用
+const DefaultTTL time.Duration = math.MinInt64
+
+type ttlRule struct {
+	prefix     string
+	expiration time.Duration
+	jitter     int
+	sliding    bool
+}
+
+// ttlRules 按key前缀的默认过期时间，为nil时所有key使用defaultCacheTimeoutSecond
+type ttlRules struct {
+	// 按前缀长度从长到短排序
+	rules []ttlRule
+	// 没有匹配的规则时使用
+	fallback ttlRule
+}
+
+// getTTLRules 前缀重复、过期时间不大于0或浮动百分比不在0-100时返回错误
+func getTTLRules() (*ttlRules, error) {
+	config := cacheConfig.GetCacheConfig()
+	r := &ttlRules{
+		rules:    make([]ttlRule, 0, len(config.TTLRules)),
+		fallback: ttlRule{expiration: defaultCacheTimeoutSecond, jitter: config.DefaultCacheTTLJitter},
+	}
+	if config.DefaultCacheTTL > 0 {
+		r.fallback.expiration = time.Duration(config.DefaultCacheTTL) * time.Second
+	}
+	if !validJitter(r.fallback.jitter) {
+		return nil, errors.Wrapf(constant.ErrorInvalidTTLRule, "default_jitter=%d", r.fallback.jitter)
+	}
+	prefixes := make(map[string]bool)
+	for _, rule := range config.TTLRules {
+		if rule.KeyPrefix == "" || prefixes[rule.KeyPrefix] || rule.Expiration <= 0 || !validJitter(rule.JitterPercent) {
+			return nil, errors.Wrapf(constant.ErrorInvalidTTLRule, "prefix=%s, expiration=%d, jitter=%d",
+				rule.KeyPrefix, rule.Expiration, rule.JitterPercent)
+		}
+		prefixes[rule.KeyPrefix] = true
+		r.rules = append(r.rules, ttlRule{
+			prefix:     rule.KeyPrefix,
+			expiration: time.Duration(rule.Expiration) * time.Second,
+			jitter:     rule.JitterPercent,
+			sliding:    rule.SlidingExpiration,
+		})
+	}
+	sort.SliceStable(r.rules, func(i, j int) bool {
+		return len(r.rules[i].prefix) > len(r.rules[j].prefix)
+	})
+	return r, nil
+}
+
+func validJitter(percent int) bool {
+	return percent >= 0 && percent <= 100
+}
+
+// match key为不含namespace的key
+func (r *ttlRules) match(key string) ttlRule {
+	if r == nil {
+		return ttlRule{expiration: defaultCacheTimeoutSecond}
+	}
+	for _, rule := range r.rules {
+		if strings.HasPrefix(key, rule.prefix) {
+			return rule
+		}
+	}
+	return r.fallback
+}
+
+// jitterTTL 在ttl上随机浮动percent%，ttl不大于0(不过期)时不浮动
+func jitterTTL(ttl time.Duration, percent int) time.Duration {
+	if ttl <= 0 || percent <= 0 {
+		return ttl
+	}
+	delta := int64(float64(ttl) * float64(percent) / 100)
+	if delta <= 0 {
+		return ttl
+	}
+	ttl += time.Duration(rand.Int63n(2*delta+1) - delta)
+	if ttl <= 0 {
+		// percent为100时可能为0，避免写入不过期的key
+		return time.Millisecond
+	}
+	return ttl
+}
+
+// getTTL
+//
+//	@Description: expired为DefaultTTL时使用匹配的规则，否则使用expired并按CallWithTTLJitter浮动
+//	@receiver c
+//	@param key 不含namespace的key，经过WithEncodeKeyType编码的key按编码前的key匹配
+//	@param expired
+//	@param opt
+//	@return time.Duration
+func (c *cacheManager) getTTL(key string, expired time.Duration, opt *callOption) time.Duration {
+	if expired != DefaultTTL {
+		return jitterTTL(expired, opt.ttlJitter)
+	}
+	rule := c.ttlRules.match(opt.ruleKey(key))
+	return jitterTTL(rule.expiration, rule.jitter)
+}
+
+// getSlidingTTL key为添加namespace后的key，匹配的规则开启了SlidingExpiration时返回读取后重置的过期时间
+func (c *cacheManager) getSlidingTTL(key string, opt *callOption) (time.Duration, bool) {
+	rule := c.ttlRules.match(c.getRuleKey(key, opt))
+	if !rule.sliding {
+		return 0, false
+	}
+	return jitterTTL(rule.expiration, rule.jitter), true
+}
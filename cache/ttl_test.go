@@ -0,0 +1,153 @@
+// Package cache @Author  wangjian    2023/10/13 3:30 PM
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cacheConfig "github.com/JianWangEx/commonService/cache/config"
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setTTLConfig(t *testing.T, c cacheConfig.TTLConfig) {
+	config := cacheConfig.GetCacheConfig()
+	old := config.TTLConfig
+	config.TTLConfig = c
+	t.Cleanup(func() {
+		config.TTLConfig = old
+	})
+}
+
+func TestGetTTLRules(t *testing.T) {
+	setTTLConfig(t, cacheConfig.TTLConfig{
+		DefaultCacheTTL: 60,
+		TTLRules: []cacheConfig.TTLRule{
+			{KeyPrefix: "user:", Expiration: 300},
+			{KeyPrefix: "user:vip:", Expiration: 3600, SlidingExpiration: true},
+		},
+	})
+	r, err := getTTLRules()
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, r.match("user:vip:1").expiration)
+	assert.True(t, r.match("user:vip:1").sliding)
+	assert.Equal(t, 5*time.Minute, r.match("user:1").expiration)
+	assert.Equal(t, time.Minute, r.match("order:1").expiration)
+	assert.Equal(t, defaultCacheTimeoutSecond, (*ttlRules)(nil).match("order:1").expiration)
+
+	for _, c := range []cacheConfig.TTLConfig{
+		{DefaultCacheTTLJitter: 101},
+		{TTLRules: []cacheConfig.TTLRule{{KeyPrefix: "", Expiration: 1}}},
+		{TTLRules: []cacheConfig.TTLRule{{KeyPrefix: "user:"}}},
+		{TTLRules: []cacheConfig.TTLRule{{KeyPrefix: "user:", Expiration: 1, JitterPercent: -1}}},
+		{TTLRules: []cacheConfig.TTLRule{{KeyPrefix: "user:", Expiration: 1}, {KeyPrefix: "user:", Expiration: 2}}},
+	} {
+		setTTLConfig(t, c)
+		_, err := getTTLRules()
+		assert.True(t, errors.Is(err, constant.ErrorInvalidTTLRule), "%+v", c)
+	}
+}
+
+func TestJitterTTL(t *testing.T) {
+	assert.Equal(t, time.Minute, jitterTTL(time.Minute, 0))
+	assert.Equal(t, time.Duration(0), jitterTTL(0, 50))
+	values := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		ttl := jitterTTL(100*time.Second, 10)
+		assert.GreaterOrEqual(t, ttl, 90*time.Second)
+		assert.LessOrEqual(t, ttl, 110*time.Second)
+		values[ttl] = true
+	}
+	assert.Greater(t, len(values), 1)
+	for i := 0; i < 100; i++ {
+		assert.Greater(t, jitterTTL(time.Second, 100), time.Duration(0))
+	}
+}
+
+func TestCacheDefaultTTL(t *testing.T) {
+	setTTLConfig(t, cacheConfig.TTLConfig{
+		DefaultCacheTTL: 60,
+		TTLRules: []cacheConfig.TTLRule{
+			{KeyPrefix: "user:", Expiration: 300, JitterPercent: 10},
+			{KeyPrefix: "session:", Expiration: 600, SlidingExpiration: true},
+		},
+	})
+	server := newTestManager(t)
+	manager := GetCacheManager()
+	ctx := context.TODO()
+
+	require.NoError(t, manager.Set(ctx, "order:1", "o", DefaultTTL))
+	assert.Equal(t, time.Minute, server.TTL("order:1"))
+	// 显式指定的过期时间不使用规则
+	require.NoError(t, manager.Set(ctx, "user:1", "u", time.Minute))
+	assert.Equal(t, time.Minute, server.TTL("user:1"))
+	require.NoError(t, manager.Add(ctx, "user:2", "u", DefaultTTL))
+	assert.InDelta(t, 300, server.TTL("user:2").Seconds(), 30)
+
+	require.NoError(t, manager.MSet(ctx, map[string]interface{}{"user:3": "u", "order:2": "o"}, DefaultTTL))
+	assert.InDelta(t, 300, server.TTL("user:3").Seconds(), 30)
+	assert.Equal(t, time.Minute, server.TTL("order:2"))
+	require.NoError(t, manager.MSet(ctx, map[string]interface{}{"order:3": "o"}, time.Minute, CallWithTTLJitter(50)))
+	assert.InDelta(t, 60, server.TTL("order:3").Seconds(), 30)
+
+	// 读取后重置过期时间
+	require.NoError(t, manager.Set(ctx, "session:1", "s", DefaultTTL))
+	server.FastForward(5 * time.Minute)
+	assert.Equal(t, 5*time.Minute, server.TTL("session:1"))
+	val := new(string)
+	require.NoError(t, manager.Get(ctx, "session:1", val))
+	assert.Equal(t, 10*time.Minute, server.TTL("session:1"))
+	require.NoError(t, manager.Set(ctx, "order:4", "o", time.Minute))
+	require.NoError(t, manager.Get(ctx, "order:4", val))
+	assert.Equal(t, time.Minute, server.TTL("order:4"))
+
+	// 未设置WithTimeout的AddCacheHandle使用规则
+	result := new(string)
+	f := func(ctx context.Context, receiver interface{}) (interface{}, error) {
+		return "u", nil
+	}
+	require.NoError(t, AddCacheHandle(ctx, result, f, NewAddCacheParam("user:4")))
+	assert.InDelta(t, 300, server.TTL("user:4").Seconds(), 30)
+	require.NoError(t, AddCacheHandle(ctx, result, f, NewAddCacheParam("user:5", WithTimeout(time.Minute), WithTTLJitter(50))))
+	assert.InDelta(t, 60, server.TTL("user:5").Seconds(), 30)
+	// 编码后的key按编码前的key匹配规则
+	require.NoError(t, AddCacheHandle(ctx, result, f, NewAddCacheParam("user:6", WithEncodeKeyType(Md5))))
+	assert.InDelta(t, 300, server.TTL(md5Key("user:6")).Seconds(), 30)
+	require.NoError(t, AddCacheHandle(ctx, result, f, NewAddCacheParam("user:7", WithEncodeKeyType(Base64))))
+	assert.InDelta(t, 300, server.TTL(getCacheKey(&AddCacheParam{encodeKeyType: Base64}, "user:7")).Seconds(), 30)
+
+	// 编码后的key读取后按编码前的key重置过期时间
+	require.NoError(t, AddCacheHandle(ctx, result, f, NewAddCacheParam("session:2", WithEncodeKeyType(Md5))))
+	server.FastForward(5 * time.Minute)
+	assert.Equal(t, 5*time.Minute, server.TTL(md5Key("session:2")))
+	require.NoError(t, AddCacheHandle(ctx, result, f, NewAddCacheParam("session:2", WithEncodeKeyType(Md5))))
+	assert.Equal(t, 10*time.Minute, server.TTL(md5Key("session:2")))
+}
+
+func TestCacheBatchDefaultTTL(t *testing.T) {
+	setTTLConfig(t, cacheConfig.TTLConfig{
+		DefaultCacheTTL: 60,
+		TTLRules:        []cacheConfig.TTLRule{{KeyPrefix: "user:", Expiration: 300}},
+	})
+	server := newTestManager(t)
+	ctx := context.TODO()
+
+	f := func(ctx context.Context, ids []string) (map[string]interface{}, error) {
+		values := make(map[string]interface{}, len(ids))
+		for _, id := range ids {
+			values[id] = "u" + id
+		}
+		return values, nil
+	}
+	// AddCacheBatchHandle写入的编码后的key按编码前的key匹配规则
+	receiver := make(map[string]string)
+	require.NoError(t, AddCacheBatchHandle(ctx, []string{"1", "2"}, &receiver, f, NewAddCacheParam("user:%s", WithEncodeKeyType(Md5))))
+	for _, id := range []string{"1", "2"} {
+		assert.Equal(t, 5*time.Minute, server.TTL(md5Key("user:"+id)))
+	}
+	require.NoError(t, AddCacheBatchHandle(ctx, []string{"3"}, &receiver, f, NewAddCacheParam("user:%s", WithEncodeKeyType(Base64))))
+	assert.Equal(t, 5*time.Minute, server.TTL(getCacheKey(&AddCacheParam{encodeKeyType: Base64}, "user:3")))
+}
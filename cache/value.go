@@ -0,0 +1,205 @@
+// Package cache @Author  wangjian    2023/8/20 4:40 PM
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/pkg/errors"
+)
+
+// Main storage中缓存值的格式:
+//
+//	| magic(1) | version(1) | codec(1) | compress(1) | flags(1) | [meta(24)] | [key_id_len(1) | key_id] | payload |
+//
+// flags中设置了valueFlagMeta时，header后紧跟valueMeta；设置了valueFlagTombstone时表示空值(negative cache)，没有payload；
+// 设置了valueFlagEncrypted时header中记录加密使用的密钥ID，payload为 nonce(12) | AES-GCM密文，整个header作为附加数据；
+// 读取时根据header解码，因此使用不同codec/compress写入的值可以被任意配置的实例读取；
+// 不以magic开头的值视为历史版本写入的json值；未开启CodecConfig.WriteValueHeader时，
+// codec为json、未压缩、没有flags的值仍以该格式写入，以兼容未升级的实例
+const (
+	valueMagic     byte = 0xCA
+	valueVersion   byte = 1
+	valueHeaderLen      = 5
+	valueMetaLen        = 24
+
+	valueFlagMeta      byte = 1 << 0
+	valueFlagTombstone byte = 1 << 1
+	valueFlagEncrypted byte = 1 << 2
+)
+
+// valueMeta 与缓存值一起存储的元数据，时间均为unix毫秒，0表示未设置
+type valueMeta struct {
+	softExpireAt int64 // 超过后值变为stale，仍可返回但需要后台刷新
+	hardExpireAt int64 // 值的实际过期时间
+	delta        int64 // 上一次回源耗时，unit milliseconds，用于提前刷新
+}
+
+func (m *valueMeta) isZero() bool {
+	return m == nil || (m.softExpireAt == 0 && m.hardExpireAt == 0 && m.delta == 0)
+}
+
+// isStale 是否已超过soft TTL
+func (m *valueMeta) isStale(now time.Time) bool {
+	return m.softExpireAt > 0 && now.UnixMilli() >= m.softExpireAt
+}
+
+// expireAt 逻辑过期时间，优先使用soft TTL
+func (m *valueMeta) expireAt() int64 {
+	if m.softExpireAt > 0 {
+		return m.softExpireAt
+	}
+	return m.hardExpireAt
+}
+
+func (m *valueMeta) marshal() []byte {
+	buf := make([]byte, valueMetaLen)
+	binary.BigEndian.PutUint64(buf[0:8], uint64(m.softExpireAt))
+	binary.BigEndian.PutUint64(buf[8:16], uint64(m.hardExpireAt))
+	binary.BigEndian.PutUint64(buf[16:24], uint64(m.delta))
+	return buf
+}
+
+func (m *valueMeta) unmarshal(data []byte) {
+	m.softExpireAt = int64(binary.BigEndian.Uint64(data[0:8]))
+	m.hardExpireAt = int64(binary.BigEndian.Uint64(data[8:16]))
+	m.delta = int64(binary.BigEndian.Uint64(data[16:24]))
+}
+
+type valueHeader struct {
+	codec    CodecType
+	compress CompressType
+	flags    byte
+	meta     valueMeta
+	keyID    string // 加密使用的密钥ID
+}
+
+func (h valueHeader) marshal() []byte {
+	data := []byte{valueMagic, valueVersion, byte(h.codec), byte(h.compress), h.flags}
+	if h.flags&valueFlagMeta != 0 {
+		data = append(data, h.meta.marshal()...)
+	}
+	if h.flags&valueFlagEncrypted != 0 {
+		data = append(data, byte(len(h.keyID)))
+		data = append(data, h.keyID...)
+	}
+	return data
+}
+
+// parseValueHeader 返回header和payload，ok为false时表示data是历史版本的json值
+func parseValueHeader(data []byte) (h valueHeader, payload []byte, ok bool, err error) {
+	if len(data) < valueHeaderLen || data[0] != valueMagic {
+		return h, data, false, nil
+	}
+	if data[1] != valueVersion {
+		return h, nil, true, errors.Wrapf(constant.ErrorUnknownValueVersion, "version=%d", data[1])
+	}
+	h = valueHeader{
+		codec:    CodecType(data[2]),
+		compress: CompressType(data[3]),
+		flags:    data[4],
+	}
+	payload = data[valueHeaderLen:]
+	if h.flags&valueFlagMeta != 0 {
+		if len(payload) < valueMetaLen {
+			return h, nil, true, errors.Wrap(constant.ErrorUnknownValueVersion, "value meta is truncated")
+		}
+		h.meta.unmarshal(payload[:valueMetaLen])
+		payload = payload[valueMetaLen:]
+	}
+	if h.flags&valueFlagEncrypted != 0 {
+		if len(payload) < 1 || len(payload) < 1+int(payload[0]) {
+			return h, nil, true, errors.Wrap(constant.ErrorUnknownValueVersion, "value key id is truncated")
+		}
+		h.keyID = string(payload[1 : 1+int(payload[0])])
+		payload = payload[1+int(payload[0]):]
+	}
+	return h, payload, true, nil
+}
+
+// encodeValue 使用opt中的codec编码value，payload长度达到阈值时进行压缩，vc.kp不为nil时压缩后使用其当前密钥加密
+func encodeValue(value interface{}, opt *callOption, vc valueCipher) ([]byte, error) {
+	if opt.tombstone {
+		return valueHeader{codec: opt.codec.Type(), compress: CompressNone, flags: valueFlagTombstone}.marshal(), nil
+	}
+	payload, err := opt.codec.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s marshal error", opt.codec.Type())
+	}
+	h := valueHeader{codec: opt.codec.Type(), compress: CompressNone}
+	if !opt.meta.isZero() {
+		h.flags |= valueFlagMeta
+		h.meta = *opt.meta
+	}
+	if opt.compressType != CompressNone && len(payload) >= opt.compressThreshold {
+		compressed, err := compress(opt.compressType, payload)
+		if err != nil {
+			return nil, err
+		}
+		// 压缩后没有变小则不压缩
+		if len(compressed) < len(payload) {
+			payload = compressed
+			h.compress = opt.compressType
+		}
+	}
+	if vc.kp != nil {
+		return vc.sealValue(h, payload)
+	}
+	if !opt.writeValueHeader && h.codec == CodecJSON && h.compress == CompressNone && h.flags == 0 {
+		return payload, nil
+	}
+	header := h.marshal()
+	buf := bytes.NewBuffer(make([]byte, 0, len(header)+len(payload)))
+	buf.Write(header)
+	buf.Write(payload)
+	return buf.Bytes(), nil
+}
+
+// decodeValue 根据值中记录的codec和compress解码到receiver，与当前配置无关；
+// meta不为nil时写入值中存储的元数据，加密的值使用vc.kp中对应ID的密钥解密
+func decodeValue(data []byte, receiver interface{}, meta *valueMeta, vc valueCipher) error {
+	h, payload, ok, err := parseValueHeader(data)
+	if err != nil {
+		return err
+	}
+	if vc.required && (!ok || h.flags&(valueFlagEncrypted|valueFlagTombstone) == 0) {
+		return errors.Wrapf(constant.ErrorValueNotEncrypted, "key=%s", vc.key)
+	}
+	if !ok {
+		// 历史版本只使用json
+		if string(payload) == "null" {
+			return constant.ErrorCacheMiss
+		}
+		return JSONCodec{}.Unmarshal(payload, receiver)
+	}
+	if meta != nil {
+		*meta = h.meta
+	}
+	if h.flags&valueFlagTombstone != 0 {
+		return constant.ErrorNegativeCacheHit
+	}
+	if h.flags&valueFlagEncrypted != 0 {
+		// header为密文的附加数据
+		payload, err = vc.openValue(h, data[:len(data)-len(payload)], payload)
+		if err != nil {
+			return err
+		}
+	}
+	payload, err = decompress(h.compress, payload)
+	if err != nil {
+		return errors.Wrapf(err, "%s decompress error", h.compress)
+	}
+	codec, err := GetCodec(h.codec)
+	if err != nil {
+		return err
+	}
+	if h.codec == CodecJSON && string(payload) == "null" {
+		return constant.ErrorCacheMiss
+	}
+	if err := codec.Unmarshal(payload, receiver); err != nil {
+		return errors.Wrapf(err, "%s unmarshal error", h.codec)
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+// Package cache @Author  wangjian    2023/10/12 11:00 AM
+package cache
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	cacheConfig "github.com/JianWangEx/commonService/cache/config"
+	"github.com/JianWangEx/commonService/constant"
+	logger "github.com/JianWangEx/commonService/log"
+	"github.com/pkg/errors"
+)
+
+// WarmerFunc 预热函数，通常从数据库加载数据后通过c的Set、MSet写入，
+// 写入local、redis或两者由CallWithStorage决定
+type WarmerFunc func(ctx context.Context, c Client) error
+
+type warmer struct {
+	name   string
+	loader WarmerFunc
+	// 为true时WaitReady等待该预热完成
+	required bool
+	// 单次执行的超时时间，为0时只受WarmUp的ctx限制
+	timeout time.Duration
+
+	// 第一次执行成功后关闭
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+type WarmerOption func(w *warmer)
+
+// WarmerWithRequired 为true时WaitReady需要等待该预热执行成功，默认为false
+func WarmerWithRequired(required bool) WarmerOption {
+	return func(w *warmer) {
+		w.required = required
+	}
+}
+
+// WarmerWithTimeout 单次执行的超时时间
+func WarmerWithTimeout(timeout time.Duration) WarmerOption {
+	return func(w *warmer) {
+		w.timeout = timeout
+	}
+}
+
+func (w *warmer) isReady() bool {
+	select {
+	case <-w.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// run loader panic时返回错误
+func (w *warmer) run(ctx context.Context, c Client) (err error) {
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.timeout)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("cache warmer panic|name=%s, err=%+v", w.name, r)
+		}
+	}()
+	if err = w.loader(ctx, c); err != nil {
+		return errors.Wrapf(err, "cache warmer error|name=%s", w.name)
+	}
+	w.readyOnce.Do(func() {
+		close(w.ready)
+	})
+	return nil
+}
+
+type warmerRegistry struct {
+	mu      sync.RWMutex
+	warmers map[string]*warmer
+	// 注册顺序，WarmUp按该顺序执行
+	names []string
+}
+
+var warmers = newWarmerRegistry()
+
+func newWarmerRegistry() *warmerRegistry {
+	return &warmerRegistry{warmers: make(map[string]*warmer)}
+}
+
+// RegisterWarmer
+//
+//	@Description: 注册预热，相同名称的预热会被替换，替换后需要重新执行才算完成。
+//	需要在Init之前注册才会在WarmUpOnInit时执行
+//	@param name
+//	@param loader
+//	@param opts
+func RegisterWarmer(name string, loader WarmerFunc, opts ...WarmerOption) {
+	w := &warmer{
+		name:   name,
+		loader: loader,
+		ready:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	warmers.mu.Lock()
+	defer warmers.mu.Unlock()
+	if _, ok := warmers.warmers[name]; !ok {
+		warmers.names = append(warmers.names, name)
+	}
+	warmers.warmers[name] = w
+}
+
+// list names为空时返回所有预热
+func (r *warmerRegistry) list(names []string) ([]*warmer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(names) == 0 {
+		names = r.names
+	}
+	list := make([]*warmer, 0, len(names))
+	for _, name := range names {
+		w, ok := r.warmers[name]
+		if !ok {
+			return nil, errors.Wrapf(constant.ErrorUnknownWarmer, "name=%s", name)
+		}
+		list = append(list, w)
+	}
+	return list, nil
+}
+
+func (r *warmerRegistry) required() []*warmer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]*warmer, 0)
+	for _, name := range r.names {
+		if w := r.warmers[name]; w.required {
+			list = append(list, w)
+		}
+	}
+	return list
+}
+
+func getWarmUpConcurrency() int {
+	if concurrency := cacheConfig.GetCacheConfig().WarmUpConcurrency; concurrency > 0 {
+		return concurrency
+	}
+	return defaultWarmUpConcurrency
+}
+
+func getWarmUpTimeout() time.Duration {
+	if timeout := cacheConfig.GetCacheConfig().WarmUpTimeout; timeout > 0 {
+		return time.Duration(timeout) * time.Second
+	}
+	return defaultWarmUpTimeout
+}
+
+// warmUp
+//
+//	@Description: 以WarmUpConcurrency的并发执行预热，每个预热完成后输出进度，
+//	ctx结束后不再开始新的预热，未开始的预热记为失败
+//	@receiver c
+//	@param ctx
+//	@param names 为空时执行所有预热
+//	@return error 失败的预热名称，各自的错误只输出到日志
+func (c *cacheManager) warmUp(ctx context.Context, names ...string) error {
+	list, err := warmers.list(names)
+	if err != nil {
+		return err
+	}
+	log := logger.CtxSugar(ctx)
+	concurrency := getWarmUpConcurrency()
+	log.Infof("cache warm up start|warmers=%d, concurrency=%d", len(list), concurrency)
+	start := time.Now()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		finished int
+		failed   []string
+	)
+	done := func(w *warmer, begin time.Time, err error) {
+		mu.Lock()
+		finished++
+		progress := finished
+		if err != nil {
+			failed = append(failed, w.name)
+		}
+		mu.Unlock()
+		if err != nil {
+			log.Errorf("cache warmer failed|name=%s, progress=%d/%d, elapsed=%d[ms], err=%+v",
+				w.name, progress, len(list), time.Since(begin).Milliseconds(), err)
+			return
+		}
+		log.Infof("cache warmer finished|name=%s, progress=%d/%d, elapsed=%d[ms]",
+			w.name, progress, len(list), time.Since(begin).Milliseconds())
+	}
+	sem := make(chan struct{}, concurrency)
+	for _, w := range list {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			done(w, time.Now(), ctx.Err())
+			continue
+		}
+		wg.Add(1)
+		go func(w *warmer) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			begin := time.Now()
+			done(w, begin, w.run(ctx, c))
+		}(w)
+	}
+	wg.Wait()
+
+	log.Infof("cache warm up finished|warmers=%d, failed=%d, elapsed=%d[ms]", len(list), len(failed), time.Since(start).Milliseconds())
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return errors.Wrapf(constant.ErrorWarmUpFailed, "warmers=%s", strings.Join(failed, ","))
+	}
+	return nil
+}
+
+// startWarmUp 开启WarmUpOnInit时在后台执行所有预热，close时取消。
+// connected不为nil时等待其关闭，即后台连接的redis全部可用后再开始，避免预热在熔断打开期间失败，
+// 超时时间WarmUpTimeout从开始预热时计算
+func (c *cacheManager) startWarmUp(connected <-chan struct{}) {
+	if !cacheConfig.GetCacheConfig().WarmUpOnInit {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancelWarmUp = cancel
+	go func() {
+		defer cancel()
+		if connected != nil {
+			select {
+			case <-connected:
+			case <-ctx.Done():
+				return
+			}
+		}
+		ctx, timeoutCancel := context.WithTimeout(ctx, getWarmUpTimeout())
+		defer timeoutCancel()
+		// 错误已输出到日志
+		_ = c.warmUp(ctx)
+	}()
+}
+
+// WarmUp
+//
+//	@Description: 立即执行预热并等待完成，可在Init后用于手动预热或定时刷新
+//	@param ctx
+//	@param names 为空时执行所有注册的预热
+//	@return error 存在未注册的名称时不执行任何预热，返回ErrorUnknownWarmer；
+//	存在失败的预热时返回ErrorWarmUpFailed
+func WarmUp(ctx context.Context, names ...string) error {
+	if client == nil {
+		return constant.ErrorCacheNotInit
+	}
+	return client.warmUp(ctx, names...)
+}
+
+// WaitReady
+//
+//	@Description: 等待所有WarmerWithRequired的预热执行成功，可用于readiness探针，
+//	ctx未设置deadline时最多等待WarmUpTimeout
+//	@param ctx
+//	@return error 超时时返回ErrorWarmUpNotReady，包含未完成的预热名称
+func WaitReady(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, getWarmUpTimeout())
+		defer cancel()
+	}
+	required := warmers.required()
+	for _, w := range required {
+		select {
+		case <-w.ready:
+		case <-ctx.Done():
+			pending := make([]string, 0)
+			for _, w := range required {
+				if !w.isReady() {
+					pending = append(pending, w.name)
+				}
+			}
+			return errors.Wrapf(constant.ErrorWarmUpNotReady, "pending=%s", strings.Join(pending, ","))
+		}
+	}
+	return nil
+}
+
+// IsReady 所有WarmerWithRequired的预热是否都已执行成功
+func IsReady() bool {
+	for _, w := range warmers.required() {
+		if !w.isReady() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+// Package cache @Author  wangjian    2023/10/12 3:40 PM
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cacheConfig "github.com/JianWangEx/commonService/cache/config"
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setWarmUpConfig(t *testing.T, c cacheConfig.WarmUpConfig) {
+	config := cacheConfig.GetCacheConfig()
+	old := config.WarmUpConfig
+	config.WarmUpConfig = c
+	oldWarmers := warmers
+	warmers = newWarmerRegistry()
+	t.Cleanup(func() {
+		config.WarmUpConfig = old
+		warmers = oldWarmers
+	})
+}
+
+func TestCacheWarmUp(t *testing.T) {
+	setWarmUpConfig(t, cacheConfig.WarmUpConfig{WarmUpConcurrency: 2})
+	server := newTestManager(t)
+	ctx := context.TODO()
+
+	var running, maxRunning int32
+	loader := func(key string) WarmerFunc {
+		return func(ctx context.Context, c Client) error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			return c.Set(ctx, key, "v", time.Minute)
+		}
+	}
+	RegisterWarmer("user", loader("user:1"), WarmerWithRequired(true))
+	RegisterWarmer("config", loader("config:1"), WarmerWithRequired(true))
+	RegisterWarmer("local", loader("local:1"))
+	RegisterWarmer("failed", func(ctx context.Context, c Client) error {
+		panic("load failed")
+	})
+	assert.False(t, IsReady())
+
+	err := WarmUp(ctx, "user", "unknown")
+	assert.True(t, errors.Is(err, constant.ErrorUnknownWarmer))
+	assert.False(t, server.Exists("user:1"))
+
+	err = WarmUp(ctx)
+	assert.True(t, errors.Is(err, constant.ErrorWarmUpFailed))
+	assert.Contains(t, err.Error(), "warmers=failed")
+	assert.True(t, server.Exists("user:1"))
+	assert.True(t, server.Exists("config:1"))
+	assert.True(t, server.Exists("local:1"))
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxRunning), int32(2))
+
+	// 非required的预热失败不影响readiness
+	assert.True(t, IsReady())
+	require.NoError(t, WaitReady(ctx))
+}
+
+func TestCacheWaitReady(t *testing.T) {
+	setWarmUpConfig(t, cacheConfig.WarmUpConfig{WarmUpOnInit: true})
+	release := make(chan struct{})
+	RegisterWarmer("slow", func(ctx context.Context, c Client) error {
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return c.Set(ctx, "slow:1", "v", time.Minute, CallWithStorage(Local))
+	}, WarmerWithRequired(true))
+	RegisterWarmer("fast", func(ctx context.Context, c Client) error {
+		return nil
+	}, WarmerWithRequired(true))
+	newTestManager(t)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	err := WaitReady(ctx)
+	assert.True(t, errors.Is(err, constant.ErrorWarmUpNotReady))
+	assert.Contains(t, err.Error(), "pending=slow")
+
+	close(release)
+	require.NoError(t, WaitReady(context.TODO()))
+	assert.True(t, IsReady())
+	val := new(string)
+	require.NoError(t, GetCacheManager().Get(context.TODO(), "slow:1", val, CallWithStorage(Local)))
+	assert.Equal(t, "v", *val)
+}
+
+func TestCacheWarmUpAfterBackgroundConnect(t *testing.T) {
+	setCircuitBreakerConfig(t, cacheConfig.CircuitBreakerConfig{
+		CircuitBreakerEnabled:     true,
+		CircuitBreakerOpenTimeout: 60000,
+	})
+	// InitWithClient时不预热，之后模拟Init在后台连接时开启
+	setWarmUpConfig(t, cacheConfig.WarmUpConfig{})
+	server := newTestManager(t)
+	server.Close()
+	cacheConfig.GetCacheConfig().WarmUpOnInit = true
+	var calls int32
+	RegisterWarmer("user", func(ctx context.Context, c Client) error {
+		atomic.AddInt32(&calls, 1)
+		return c.Set(ctx, "user:1", "v", time.Minute)
+	}, WarmerWithRequired(true))
+
+	// 熔断打开期间不执行预热
+	client.startWarmUp(client.connectInBackground())
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	assert.True(t, errors.Is(WaitReady(ctx), constant.ErrorWarmUpNotReady))
+	assert.Zero(t, atomic.LoadInt32(&calls))
+
+	require.NoError(t, server.Restart())
+	require.NoError(t, WaitReady(context.TODO()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.True(t, server.Exists("user:1"))
+}
@@ -8,12 +8,83 @@ import (
 var (
 	// ErrorNilReceiverOrNotPtr means receiver is nil or not a pointer
 	ErrorNilReceiverOrNotPtr = errors.New("receiver is nil or not a ptr")
+	// ErrorReceiverNotMapPtr means receiver is nil or not a pointer to map with string key
+	ErrorReceiverNotMapPtr = errors.New("receiver is nil or not a ptr to map with string key")
 	// ErrorCacheMiss means that a Get failed because the item wasn't present
 	ErrorCacheMiss = errors.New("cache miss")
 	// ErrorFailedOperation means redis return failed
 	ErrorFailedOperation = errors.New("operation failed")
 	// ErrorAddCacheGotNilResult means add cache real func return nil
 	ErrorAddCacheGotNilResult = errors.New("got nil result from real function")
+	// ErrorNegativeCacheHit means cache found a tombstone stored by negative cache
+	ErrorNegativeCacheHit = errors.New("negative cache hit")
+	// ErrorUnknownCodec means codec type or name is not registered
+	ErrorUnknownCodec = errors.New("unknown cache codec")
+	// ErrorUnknownCompressType means compress type or name is not supported
+	ErrorUnknownCompressType = errors.New("unknown cache compress type")
+	// ErrorUnknownValueVersion means cache value header version is not supported
+	ErrorUnknownValueVersion = errors.New("unknown cache value version")
+	// ErrorNotProtoMessage means value or receiver does not implement proto.Message
+	ErrorNotProtoMessage = errors.New("value or receiver is not a proto.Message")
+	// ErrorUnknownStorage means storage name is not one of main, local and tiered
+	ErrorUnknownStorage = errors.New("unknown cache storage")
+	// ErrorFlushWithoutNamespace means FlushCache is called without namespace configured
+	ErrorFlushWithoutNamespace = errors.New("flush cache without namespace is not allowed")
+	// ErrorCacheLockLost means cache lock watchdog failed to renew the lock before it expired
+	ErrorCacheLockLost = errors.New("cache lock lost")
+	// ErrorRedlockNotConfigured means redlock is used without RedlockAddrs configured
+	ErrorRedlockNotConfigured = errors.New("redlock nodes are not configured")
+	// ErrorInvalidLockParam means lock or semaphore timeout or limit is not positive
+	ErrorInvalidLockParam = errors.New("invalid cache lock param")
+	// ErrorUnknownLocalStore means local store name is not one of lru and tinylfu
+	ErrorUnknownLocalStore = errors.New("unknown local store")
+	// ErrorInvalidLocalStoreCapacity means local store capacity is not positive when required
+	ErrorInvalidLocalStoreCapacity = errors.New("invalid local store capacity")
+	// ErrorLocalCacheKeyExists means Add is called with a key which is already in local cache
+	ErrorLocalCacheKeyExists = errors.New("local cache key already exists")
+	// ErrorCircuitOpen means redis circuit breaker is open and the command is not sent to redis
+	ErrorCircuitOpen = errors.New("redis circuit breaker is open")
+	// ErrorUnknownRedisConnectMode means redis connect mode is not one of eager, lazy and background
+	ErrorUnknownRedisConnectMode = errors.New("unknown redis connect mode")
+	// ErrorUnknownRedisCluster means redis cluster name is not configured
+	ErrorUnknownRedisCluster = errors.New("unknown redis cluster")
+	// ErrorInvalidRedisCluster means redis cluster name is empty, duplicated or reserved
+	ErrorInvalidRedisCluster = errors.New("invalid redis cluster")
+	// ErrorInvalidTTLRule means ttl rule prefix is duplicated, expiration is not positive or jitter is out of range
+	ErrorInvalidTTLRule = errors.New("invalid cache ttl rule")
+	// ErrorUnknownKeyProvider means encryption key provider name is not one of env, file and registered providers
+	ErrorUnknownKeyProvider = errors.New("unknown cache encryption key provider")
+	// ErrorEncryptionNotConfigured means value needs to be encrypted or decrypted without key provider configured
+	ErrorEncryptionNotConfigured = errors.New("cache encryption is not configured")
+	// ErrorUnknownEncryptionKey means encryption key id is not found in key provider
+	ErrorUnknownEncryptionKey = errors.New("unknown cache encryption key")
+	// ErrorInvalidEncryptionKey means encryption key id is empty or too long, or key is not a valid AES key
+	ErrorInvalidEncryptionKey = errors.New("invalid cache encryption key")
+	// ErrorValueDecryptFailed means encrypted value is corrupted or encrypted with another key
+	ErrorValueDecryptFailed = errors.New("cache value decrypt failed")
+	// ErrorValueNotEncrypted means value of a key matching encryption key prefixes is stored without encryption
+	ErrorValueNotEncrypted = errors.New("cache value is not encrypted")
+	// ErrorUnknownWarmer means cache warmer name is not registered
+	ErrorUnknownWarmer = errors.New("unknown cache warmer")
+	// ErrorWarmUpFailed means one or more cache warmers returned error
+	ErrorWarmUpFailed = errors.New("cache warm up failed")
+	// ErrorWarmUpNotReady means required cache warmers did not finish before the deadline
+	ErrorWarmUpNotReady = errors.New("cache warm up not ready")
+	// ErrorCacheNotInit means cache is used before Init
+	ErrorCacheNotInit = errors.New("cache is not initialized")
+)
+
+var (
+	// ErrorRateLimited means request is rejected by rate limiter
+	ErrorRateLimited = errors.New("rate limited")
+	// ErrorRateLimitExceedsCapacity means the requested n is larger than the limiter capacity and can never be allowed
+	ErrorRateLimitExceedsCapacity = errors.New("rate limit request exceeds capacity")
+	// ErrorUnknownRateLimitAlgorithm means rate limit algorithm is not supported
+	ErrorUnknownRateLimitAlgorithm = errors.New("unknown rate limit algorithm")
+	// ErrorInvalidRateLimit means rate limit rate, period or requested n is not positive
+	ErrorInvalidRateLimit = errors.New("invalid rate limit")
+	// ErrorRateLimitRedisNotInit means rate limiter has no redis client and local fallback is disabled
+	ErrorRateLimitRedisNotInit = errors.New("rate limit redis client is not initialized")
 )
 
 var (
@@ -0,0 +1,11 @@
+// Package redisscript @Author  wangjian    2023/9/18 10:20 AM
+package redisscript
+
+// NowMillis 定义返回redis服务器当前时间(unix毫秒)的now_millis()，拼接在脚本之前使用，
+// 不受各实例时钟偏差的影响，cache及ratelimit的脚本共用
+const NowMillis = `
+local function now_millis()
+	local t = redis.call("TIME")
+	return tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+end
+`
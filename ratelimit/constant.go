@@ -0,0 +1,27 @@
+// Package ratelimit @Author  wangjian    2023/9/18 10:05 AM
+package ratelimit
+
+import "time"
+
+type Algorithm string
+
+const (
+	// TokenBucket 令牌桶，以Rate/Period的速度补充令牌，最多积累Burst个，允许突发
+	TokenBucket Algorithm = "token_bucket"
+	// GCRA 通用信元速率算法，效果与令牌桶相同，只需要保存一个时间戳
+	GCRA Algorithm = "gcra"
+	// FixedWindow 固定窗口计数，从窗口内第一个请求开始计时，窗口边界可能出现两倍的突发
+	FixedWindow Algorithm = "fixed_window"
+	// SlidingWindowLog 滑动窗口日志，记录窗口内每个请求的时间，精确但占用内存与Rate成正比
+	SlidingWindowLog Algorithm = "sliding_window_log"
+)
+
+const (
+	keyPrefix = "ratelimit:"
+
+	// Wait时单次等待的最短时间，避免RetryAfter为0时忙等
+	minWaitInterval = time.Millisecond
+
+	// redis不可用时的告警日志间隔
+	fallbackLogInterval = 10 * time.Second
+)
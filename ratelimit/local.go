@@ -0,0 +1,157 @@
+// Package ratelimit @Author  wangjian    2023/9/18 2:40 PM
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// localLimiter
+//
+//	@Description: redis不可用时使用的进程内限流，算法与redis脚本相同，
+//	每个key的状态保存在go-cache中，限流状态恢复后自动过期
+type localLimiter struct {
+	algorithm Algorithm
+	limit     Limit
+	states    *cache.Cache
+	mu        sync.Mutex
+}
+
+func newLocalLimiter(algorithm Algorithm, limit Limit) *localLimiter {
+	return &localLimiter{
+		algorithm: algorithm,
+		limit:     limit,
+		states:    cache.New(limit.Period, limit.Period),
+	}
+}
+
+type tokenBucketState struct {
+	tokens float64
+	ts     time.Time
+}
+
+type fixedWindowState struct {
+	count    int64
+	expireAt time.Time
+}
+
+func (l *localLimiter) allowN(key string, n int64, now time.Time) *Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state, _ := l.states.Get(key)
+	switch l.algorithm {
+	case TokenBucket:
+		return l.tokenBucket(key, state, n, now)
+	case GCRA:
+		return l.gcra(key, state, n, now)
+	case FixedWindow:
+		return l.fixedWindow(key, state, n, now)
+	default:
+		return l.slidingWindowLog(key, state, n, now)
+	}
+}
+
+func (l *localLimiter) tokenBucket(key string, state interface{}, n int64, now time.Time) *Result {
+	capacity := float64(l.limit.capacity())
+	rate := 1 / l.limit.interval() // 每毫秒补充的令牌数
+	s, ok := state.(*tokenBucketState)
+	if !ok {
+		s = &tokenBucketState{tokens: capacity, ts: now}
+	}
+	elapsed := math.Max(0, float64(now.Sub(s.ts).Milliseconds()))
+	s.tokens = math.Min(capacity, s.tokens+elapsed*rate)
+	s.ts = now
+
+	allowed := false
+	retry := int64(-1)
+	if float64(n) <= s.tokens {
+		s.tokens -= float64(n)
+		allowed = true
+	} else if float64(n) <= capacity {
+		retry = int64(math.Ceil((float64(n) - s.tokens) / rate))
+	}
+	reset := int64(math.Ceil((capacity - s.tokens) / rate))
+	l.states.Set(key, s, time.Duration(reset+1)*time.Millisecond)
+	return newResult(allowed, int64(s.tokens), retry, reset)
+}
+
+func (l *localLimiter) gcra(key string, state interface{}, n int64, now time.Time) *Result {
+	interval := l.limit.interval()
+	capacityOffset := interval * float64(l.limit.capacity())
+	nowMs := float64(now.UnixMilli())
+	tat, ok := state.(float64)
+	if !ok || tat < nowMs {
+		tat = nowMs
+	}
+	newTat := tat + interval*float64(n)
+	allowAt := newTat - capacityOffset
+	if nowMs < allowAt {
+		retry := int64(-1)
+		if interval*float64(n) <= capacityOffset {
+			retry = int64(math.Ceil(allowAt - nowMs))
+		}
+		return newResult(false, int64(math.Floor((nowMs-(tat-capacityOffset))/interval)), retry, int64(math.Ceil(tat-nowMs)))
+	}
+	reset := int64(math.Ceil(newTat - nowMs))
+	l.states.Set(key, newTat, time.Duration(reset+1)*time.Millisecond)
+	return newResult(true, int64(math.Floor((nowMs-allowAt)/interval)), -1, reset)
+}
+
+func (l *localLimiter) fixedWindow(key string, state interface{}, n int64, now time.Time) *Result {
+	limit := l.limit.Rate
+	s, ok := state.(*fixedWindowState)
+	if !ok || !now.Before(s.expireAt) {
+		s = &fixedWindowState{}
+	}
+	ttl := int64(0)
+	if !s.expireAt.IsZero() {
+		ttl = s.expireAt.Sub(now).Milliseconds()
+	}
+	if s.count+n > limit {
+		retry := int64(-1)
+		if n <= limit {
+			retry = ttl
+		}
+		return newResult(false, limit-s.count, retry, ttl)
+	}
+	s.count += n
+	if s.expireAt.IsZero() {
+		s.expireAt = now.Add(l.limit.Period)
+		ttl = l.limit.Period.Milliseconds()
+	}
+	l.states.Set(key, s, s.expireAt.Sub(now))
+	return newResult(true, limit-s.count, -1, ttl)
+}
+
+func (l *localLimiter) slidingWindowLog(key string, state interface{}, n int64, now time.Time) *Result {
+	limit := l.limit.Rate
+	window := l.limit.Period
+	logs, _ := state.([]time.Time)
+	// 移除窗口外的请求
+	idx := 0
+	for idx < len(logs) && !logs[idx].After(now.Add(-window)) {
+		idx++
+	}
+	logs = logs[idx:]
+	count := int64(len(logs))
+	if count+n > limit {
+		retry := int64(-1)
+		if n <= limit {
+			retry = logs[count+n-limit-1].Add(window).Sub(now).Milliseconds()
+		}
+		reset := int64(0)
+		if count > 0 {
+			reset = logs[0].Add(window).Sub(now).Milliseconds()
+		}
+		l.states.Set(key, logs, window)
+		return newResult(false, limit-count, retry, reset)
+	}
+	for i := int64(0); i < n; i++ {
+		logs = append(logs, now)
+	}
+	l.states.Set(key, logs, window)
+	return newResult(true, limit-count-n, -1, logs[0].Add(window).Sub(now).Milliseconds())
+}
@@ -0,0 +1,64 @@
+// Package ratelimit @Author  wangjian    2023/9/19 2:30 PM
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalTokenBucket(t *testing.T) {
+	l := newLocalLimiter(TokenBucket, Limit{Rate: 10, Period: time.Second, Burst: 5})
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		assert.True(t, l.allowN("k", 1, now).Allowed)
+	}
+	result := l.allowN("k", 1, now)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 100*time.Millisecond, result.RetryAfter)
+
+	// 100ms补充一个令牌
+	assert.True(t, l.allowN("k", 1, now.Add(100*time.Millisecond)).Allowed)
+	assert.Equal(t, time.Duration(-1), l.allowN("k", 6, now.Add(100*time.Millisecond)).RetryAfter)
+}
+
+func TestLocalGCRA(t *testing.T) {
+	l := newLocalLimiter(GCRA, Limit{Rate: 10, Period: time.Second, Burst: 2})
+	now := time.Now()
+	assert.True(t, l.allowN("k", 1, now).Allowed)
+	assert.True(t, l.allowN("k", 1, now).Allowed)
+	result := l.allowN("k", 1, now)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 100*time.Millisecond, result.RetryAfter)
+	assert.True(t, l.allowN("k", 1, now.Add(100*time.Millisecond)).Allowed)
+	assert.False(t, l.allowN("k", 1, now.Add(100*time.Millisecond)).Allowed)
+}
+
+func TestLocalFixedWindow(t *testing.T) {
+	l := newLocalLimiter(FixedWindow, Limit{Rate: 3, Period: time.Second})
+	now := time.Now()
+	assert.True(t, l.allowN("k", 2, now).Allowed)
+	result := l.allowN("k", 2, now.Add(100*time.Millisecond))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, int64(1), result.Remaining)
+	assert.Equal(t, 900*time.Millisecond, result.RetryAfter)
+	assert.True(t, l.allowN("k", 1, now.Add(100*time.Millisecond)).Allowed)
+	// 新的窗口
+	assert.True(t, l.allowN("k", 3, now.Add(time.Second)).Allowed)
+	// 不同的key互不影响
+	assert.True(t, l.allowN("other", 3, now).Allowed)
+}
+
+func TestLocalSlidingWindowLog(t *testing.T) {
+	l := newLocalLimiter(SlidingWindowLog, Limit{Rate: 2, Period: time.Second})
+	now := time.Now()
+	assert.True(t, l.allowN("k", 1, now).Allowed)
+	assert.True(t, l.allowN("k", 1, now.Add(400*time.Millisecond)).Allowed)
+	result := l.allowN("k", 1, now.Add(500*time.Millisecond))
+	assert.False(t, result.Allowed)
+	// 第一个请求在1s时移出窗口
+	assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+	assert.True(t, l.allowN("k", 1, now.Add(time.Second)).Allowed)
+	assert.False(t, l.allowN("k", 1, now.Add(1100*time.Millisecond)).Allowed)
+}
@@ -0,0 +1,92 @@
+// Package ratelimit @Author  wangjian    2023/9/19 10:15 AM
+package ratelimit
+
+import (
+	"context"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/JianWangEx/commonService/kafka/consume"
+	logger "github.com/JianWangEx/commonService/log"
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// KafkaKeyFunc 根据消息生成限流的key，返回空字符串时不限流
+type KafkaKeyFunc func(ctx context.Context, value string, headers []*sarama.RecordHeader) string
+
+// KafkaConsumeMiddleware
+//
+//	@Description: 对kafka消费函数进行修饰，消费前等待限流器允许，用于限制消费下游的速度，
+//	等待失败(如请求数超过容量、redis不可用且关闭了local fallback)时返回错误，消息按消费失败处理并重试
+//	@param l
+//	@param keyFunc 为nil时所有消息使用同一个key
+//	@param f
+//	@return consume.KafkaConsumeFunc
+func KafkaConsumeMiddleware(l *Limiter, keyFunc KafkaKeyFunc, f consume.KafkaConsumeFunc) consume.KafkaConsumeFunc {
+	return func(ctx context.Context, value string, headers []*sarama.RecordHeader) error {
+		key := ""
+		if keyFunc != nil {
+			if key = keyFunc(ctx, value, headers); key == "" {
+				return f(ctx, value, headers)
+			}
+		}
+		if err := l.Wait(ctx, key); err != nil {
+			logger.CtxSugar(ctx).Warnf("kafka consume rate limit wait failed|name=%s, key=%s, err=%+v", l.name, key, err)
+			return err
+		}
+		return f(ctx, value, headers)
+	}
+}
+
+// GrpcKeyFunc 根据请求生成限流的key，返回空字符串时不限流
+type GrpcKeyFunc func(ctx context.Context, info *grpc.UnaryServerInfo, req interface{}) string
+
+// UnaryServerInterceptor
+//
+//	@Description: grpc服务端限流，超过限额时不等待，直接返回codes.ResourceExhausted；
+//	限流器出错时放行请求，避免redis故障导致服务不可用
+//	@param l
+//	@param keyFunc 为nil时使用请求的方法名作为key
+//	@return grpc.UnaryServerInterceptor
+func UnaryServerInterceptor(l *Limiter, keyFunc GrpcKeyFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		key := info.FullMethod
+		if keyFunc != nil {
+			if key = keyFunc(ctx, info, req); key == "" {
+				return handler(ctx, req)
+			}
+		}
+		result, err := l.Allow(ctx, key)
+		if err != nil {
+			logger.CtxSugar(ctx).Warnf("grpc rate limit failed, allow request|name=%s, key=%s, err=%+v", l.name, key, err)
+			return handler(ctx, req)
+		}
+		if !result.Allowed {
+			return nil, status.Errorf(codes.ResourceExhausted, "%s|name=%s, key=%s, retry_after=%s", constant.ErrorRateLimited, l.name, key, result.RetryAfter)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Handler
+//
+//	@Description: 对任意函数进行限流，超过限额时不执行f，返回ErrorRateLimited
+//	@param ctx
+//	@param l
+//	@param key
+//	@param f
+//	@return interface{}
+//	@return error
+func Handler(ctx context.Context, l *Limiter, key string, f func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	result, err := l.Allow(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Allowed {
+		return nil, errors.Wrapf(constant.ErrorRateLimited, "name=%s, key=%s, retry_after=%s", l.name, key, result.RetryAfter)
+	}
+	return f(ctx)
+}
@@ -0,0 +1,68 @@
+// Package ratelimit @Author  wangjian    2023/9/19 4:00 PM
+package ratelimit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	l, err := NewLimiter("grpc_test", FixedWindow, PerMinute(1))
+	require.NoError(t, err)
+	interceptor := UnaryServerInterceptor(l, nil)
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.UserService/GetUser"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	resp, err := interceptor(context.TODO(), nil, info, handler)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+
+	_, err = interceptor(context.TODO(), nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// 其他方法使用不同的key
+	_, err = interceptor(context.TODO(), nil, &grpc.UnaryServerInfo{FullMethod: "/user.UserService/ListUser"}, handler)
+	assert.NoError(t, err)
+}
+
+func TestKafkaConsumeMiddleware(t *testing.T) {
+	l, err := NewLimiter("kafka_test", TokenBucket, PerSecond(1))
+	require.NoError(t, err)
+	consumed := 0
+	f := KafkaConsumeMiddleware(l, func(ctx context.Context, value string, headers []*sarama.RecordHeader) string {
+		return value
+	}, func(ctx context.Context, value string, headers []*sarama.RecordHeader) error {
+		consumed++
+		return nil
+	})
+	require.NoError(t, f(context.TODO(), "a", nil))
+	require.NoError(t, f(context.TODO(), "b", nil))
+	assert.Equal(t, 2, consumed)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	assert.ErrorIs(t, f(ctx, "a", nil), context.Canceled)
+	assert.Equal(t, 2, consumed)
+}
+
+func TestHandler(t *testing.T) {
+	l, err := NewLimiter("handler_test", SlidingWindowLog, PerMinute(1))
+	require.NoError(t, err)
+	f := func(ctx context.Context) (interface{}, error) {
+		return "ok", nil
+	}
+	_, err = Handler(context.TODO(), l, "k", f)
+	require.NoError(t, err)
+	_, err = Handler(context.TODO(), l, "k", f)
+	assert.ErrorIs(t, err, constant.ErrorRateLimited)
+}
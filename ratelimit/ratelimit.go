@@ -0,0 +1,236 @@
+// Package ratelimit @Author  wangjian    2023/9/18 11:20 AM
+package ratelimit
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/JianWangEx/commonService/cache"
+	"github.com/JianWangEx/commonService/constant"
+	logger "github.com/JianWangEx/commonService/log"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// Limit 每Period允许Rate个请求，Burst为令牌桶和GCRA允许的最大突发请求数，默认等于Rate，窗口算法忽略Burst
+type Limit struct {
+	Rate   int64
+	Period time.Duration
+	Burst  int64
+}
+
+func PerSecond(rate int64) Limit {
+	return Limit{Rate: rate, Period: time.Second}
+}
+
+func PerMinute(rate int64) Limit {
+	return Limit{Rate: rate, Period: time.Minute}
+}
+
+func (l Limit) capacity() int64 {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+// interval 每个请求的平均间隔，unit milliseconds
+func (l Limit) interval() float64 {
+	return float64(l.Period.Milliseconds()) / float64(l.Rate)
+}
+
+// Result 一次限流判断的结果
+type Result struct {
+	Allowed bool
+	// Remaining 本次判断后还允许的请求数
+	Remaining int64
+	// RetryAfter 被拒绝时至少需要等待的时间，-1表示请求数超过容量，永远不会被允许
+	RetryAfter time.Duration
+	// ResetAfter 限流状态恢复到初始状态需要的时间
+	ResetAfter time.Duration
+}
+
+type Limiter struct {
+	name      string
+	algorithm Algorithm
+	limit     Limit
+
+	// 为nil时使用cache包的redis client
+	redisClient   redis.UniversalClient
+	localFallback bool
+	local         *localLimiter
+
+	lastFallbackLog int64 // unix nano
+}
+
+type Option func(l *Limiter)
+
+// WithRedisClient 使用指定的redis client，默认使用cache.GetRedisClient()，key同样会添加cache配置的namespace
+func WithRedisClient(redisClient redis.UniversalClient) Option {
+	return func(l *Limiter) {
+		l.redisClient = redisClient
+	}
+}
+
+// WithLocalFallback redis不可用时是否使用进程内的限流，默认开启；
+// 进程内限流只对当前实例生效，集群总的请求数最多为实例数*Rate。关闭时redis不可用返回错误
+func WithLocalFallback(b bool) Option {
+	return func(l *Limiter) {
+		l.localFallback = b
+	}
+}
+
+// NewLimiter
+//
+//	@Description: 基于redis的分布式限流器，同一个name和key的请求在所有实例间共享限额
+//	@param name 限流器名称，作为redis key的一部分，不同用途的限流器需要使用不同的name
+//	@param algorithm
+//	@param limit Rate需要大于0，Period不能小于1ms，脚本以毫秒计算时间
+//	@param opts
+//	@return *Limiter
+//	@return error
+func NewLimiter(name string, algorithm Algorithm, limit Limit, opts ...Option) (*Limiter, error) {
+	switch algorithm {
+	case TokenBucket, GCRA, FixedWindow, SlidingWindowLog:
+	default:
+		return nil, errors.Wrapf(constant.ErrorUnknownRateLimitAlgorithm, "algorithm=%s", algorithm)
+	}
+	if limit.Rate <= 0 || limit.Period < time.Millisecond {
+		return nil, errors.Wrapf(constant.ErrorInvalidRateLimit, "rate=%d, period=%s", limit.Rate, limit.Period)
+	}
+	l := &Limiter{
+		name:          name,
+		algorithm:     algorithm,
+		limit:         limit,
+		localFallback: true,
+	}
+	for _, f := range opts {
+		f(l)
+	}
+	l.local = newLocalLimiter(algorithm, limit)
+	return l, nil
+}
+
+func (l *Limiter) Allow(ctx context.Context, key string) (*Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN 判断key是否允许n个请求，允许时立即扣除限额，n不大于0时返回ErrorInvalidRateLimit
+func (l *Limiter) AllowN(ctx context.Context, key string, n int64) (*Result, error) {
+	// n为负数时会补充令牌桶或减小固定窗口的计数，绕过限流
+	if n <= 0 {
+		return nil, errors.Wrapf(constant.ErrorInvalidRateLimit, "name=%s, key=%s, n=%d", l.name, key, n)
+	}
+	redisClient := l.getRedisClient()
+	if redisClient == nil {
+		if !l.localFallback {
+			return nil, errors.WithStack(constant.ErrorRateLimitRedisNotInit)
+		}
+		return l.local.allowN(key, n, time.Now()), nil
+	}
+	result, err := l.allowRedis(ctx, redisClient, key, n)
+	if err == nil {
+		return result, nil
+	}
+	if !l.localFallback {
+		return nil, err
+	}
+	l.logFallback(ctx, key, err)
+	return l.local.allowN(key, n, time.Now()), nil
+}
+
+func (l *Limiter) Wait(ctx context.Context, key string) error {
+	return l.WaitN(ctx, key, 1)
+}
+
+// WaitN 阻塞直到key允许n个请求或ctx结束，n超过容量时立即返回ErrorRateLimitExceedsCapacity
+func (l *Limiter) WaitN(ctx context.Context, key string, n int64) error {
+	for {
+		result, err := l.AllowN(ctx, key, n)
+		if err != nil {
+			return err
+		}
+		if result.Allowed {
+			return nil
+		}
+		if result.RetryAfter < 0 {
+			return errors.Wrapf(constant.ErrorRateLimitExceedsCapacity, "name=%s, key=%s, n=%d", l.name, key, n)
+		}
+		waitTime := result.RetryAfter
+		if waitTime < minWaitInterval {
+			waitTime = minWaitInterval
+		}
+		timer := time.NewTimer(waitTime)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (l *Limiter) getRedisClient() redis.UniversalClient {
+	if l.redisClient != nil {
+		return l.redisClient
+	}
+	return cache.GetRedisClient()
+}
+
+// getKey 不同算法保存的数据结构不同，key中包含算法避免修改算法后读到旧的数据
+func (l *Limiter) getKey(key string) string {
+	return cache.BuildKey(strings.Join([]string{keyPrefix, l.name, ":", string(l.algorithm), ":", key}, ""))
+}
+
+func (l *Limiter) allowRedis(ctx context.Context, redisClient redis.UniversalClient, key string, n int64) (*Result, error) {
+	var (
+		values []int64
+		err    error
+	)
+	keys := []string{l.getKey(key)}
+	switch l.algorithm {
+	case TokenBucket:
+		values, err = tokenBucketScript.Run(ctx, redisClient, keys, l.limit.capacity(), 1/l.limit.interval(), n).Int64Slice()
+	case GCRA:
+		interval := l.limit.interval()
+		values, err = gcraScript.Run(ctx, redisClient, keys, interval, interval*float64(l.limit.capacity()), n).Int64Slice()
+	case FixedWindow:
+		values, err = fixedWindowScript.Run(ctx, redisClient, keys, l.limit.Rate, l.limit.Period.Milliseconds(), n).Int64Slice()
+	case SlidingWindowLog:
+		values, err = slidingWindowLogScript.Run(ctx, redisClient, keys, l.limit.Rate, l.limit.Period.Milliseconds(), n, uuid.NewString()).Int64Slice()
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "rate limit redis error|name=%s, key=%s", l.name, key)
+	}
+	return newResult(values[0] == 1, values[1], values[2], values[3]), nil
+}
+
+// logFallback redis不可用时每个请求都会走到这里，限制告警日志的频率
+func (l *Limiter) logFallback(ctx context.Context, key string, err error) {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&l.lastFallbackLog)
+	if now-last < fallbackLogInterval.Nanoseconds() || !atomic.CompareAndSwapInt64(&l.lastFallbackLog, last, now) {
+		return
+	}
+	logger.CtxSugar(ctx).Warnf("rate limit redis unavailable, fallback to local|name=%s, key=%s, err=%+v", l.name, key, err)
+}
+
+// newResult retryAfter和resetAfter的单位为毫秒
+func newResult(allowed bool, remaining int64, retryAfter int64, resetAfter int64) *Result {
+	result := &Result{
+		Allowed:    allowed,
+		Remaining:  remaining,
+		RetryAfter: time.Duration(retryAfter) * time.Millisecond,
+		ResetAfter: time.Duration(resetAfter) * time.Millisecond,
+	}
+	if retryAfter < 0 {
+		result.RetryAfter = -1
+	}
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+	return result
+}
@@ -0,0 +1,112 @@
+// Package ratelimit @Author  wangjian    2023/9/19 3:10 PM
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JianWangEx/commonService/cache/cachetest"
+	"github.com/JianWangEx/commonService/constant"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLimiter(t *testing.T) {
+	_, err := NewLimiter("test", "leaky_bucket", PerSecond(1))
+	assert.ErrorIs(t, err, constant.ErrorUnknownRateLimitAlgorithm)
+	_, err = NewLimiter("test", TokenBucket, PerSecond(0))
+	assert.ErrorIs(t, err, constant.ErrorInvalidRateLimit)
+	// 不足1ms的Period按毫秒计算为0
+	for _, alg := range []Algorithm{TokenBucket, GCRA, FixedWindow, SlidingWindowLog} {
+		_, err = NewLimiter("test", alg, Limit{Rate: 1, Period: time.Microsecond})
+		assert.ErrorIs(t, err, constant.ErrorInvalidRateLimit, "algorithm=%s", alg)
+	}
+	_, err = NewLimiter("test", TokenBucket, Limit{Rate: 1, Period: time.Millisecond})
+	assert.NoError(t, err)
+}
+
+func TestLimiterLocalFallback(t *testing.T) {
+	ctx := context.TODO()
+	// cache未Init，没有redis client
+	l, err := NewLimiter("test", FixedWindow, PerMinute(1))
+	require.NoError(t, err)
+	result, err := l.Allow(ctx, "k")
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	result, err = l.Allow(ctx, "k")
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	l, err = NewLimiter("test", FixedWindow, PerMinute(1), WithLocalFallback(false))
+	require.NoError(t, err)
+	_, err = l.Allow(ctx, "k")
+	assert.ErrorIs(t, err, constant.ErrorRateLimitRedisNotInit)
+}
+
+func TestLimiterWait(t *testing.T) {
+	ctx := context.TODO()
+	l, err := NewLimiter("test", GCRA, Limit{Rate: 20, Period: time.Second, Burst: 1})
+	require.NoError(t, err)
+
+	startTime := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Wait(ctx, "k"))
+	}
+	// 第一个请求立即通过，之后每50ms通过一个
+	assert.GreaterOrEqual(t, time.Since(startTime), 90*time.Millisecond)
+
+	assert.ErrorIs(t, l.WaitN(ctx, "k", 2), constant.ErrorRateLimitExceedsCapacity)
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	l, err = NewLimiter("test", FixedWindow, PerMinute(1))
+	require.NoError(t, err)
+	require.NoError(t, l.Wait(timeoutCtx, "k"))
+	assert.ErrorIs(t, l.Wait(timeoutCtx, "k"), context.DeadlineExceeded)
+}
+
+func TestLimiterRedis(t *testing.T) {
+	ctx := context.TODO()
+	server := miniredis.RunT(t)
+	redisClient := cachetest.NewRedisClient(server)
+
+	for _, alg := range []Algorithm{TokenBucket, GCRA, FixedWindow, SlidingWindowLog} {
+		l, err := NewLimiter("test", alg, PerMinute(2), WithRedisClient(redisClient), WithLocalFallback(false))
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			result, err := l.Allow(ctx, "k")
+			require.NoError(t, err)
+			assert.True(t, result.Allowed, "algorithm=%s", alg)
+		}
+		result, err := l.Allow(ctx, "k")
+		require.NoError(t, err)
+		assert.False(t, result.Allowed, "algorithm=%s", alg)
+		assert.Greater(t, result.RetryAfter, time.Duration(0), "algorithm=%s", alg)
+	}
+}
+
+func TestLimiterInvalidN(t *testing.T) {
+	ctx := context.TODO()
+	server := miniredis.RunT(t)
+	redisClient := cachetest.NewRedisClient(server)
+
+	for _, alg := range []Algorithm{TokenBucket, GCRA, FixedWindow, SlidingWindowLog} {
+		for _, opt := range []Option{WithRedisClient(redisClient), WithLocalFallback(true)} {
+			l, err := NewLimiter("test", alg, PerMinute(1), opt)
+			require.NoError(t, err)
+			for _, n := range []int64{0, -5} {
+				_, err := l.AllowN(ctx, "k", n)
+				assert.ErrorIs(t, err, constant.ErrorInvalidRateLimit, "algorithm=%s", alg)
+			}
+			result, err := l.Allow(ctx, "k")
+			require.NoError(t, err)
+			assert.True(t, result.Allowed, "algorithm=%s", alg)
+			result, err = l.Allow(ctx, "k")
+			require.NoError(t, err)
+			assert.False(t, result.Allowed, "algorithm=%s", alg)
+		}
+		server.FlushAll()
+	}
+}
@@ -0,0 +1,110 @@
+// Package ratelimit @Author  wangjian    2023/9/18 10:30 AM
+package ratelimit
+
+import (
+	"github.com/JianWangEx/commonService/internal/redisscript"
+	"github.com/redis/go-redis/v9"
+)
+
+// 所有脚本返回 {allowed, remaining, retry_after(毫秒，-1表示不需要或永远不能通过), reset_after(毫秒)}，
+// 时间使用redis服务器的时间，不受各实例时钟偏差的影响
+const luaNowMillis = redisscript.NowMillis
+
+// tokenBucketScript KEYS[1]为保存令牌数和上次补充时间的hash，
+// ARGV: 容量、每毫秒补充的令牌数、本次请求的令牌数
+var tokenBucketScript = redis.NewScript(luaNowMillis + `
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local now = now_millis()
+local data = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(data[1]) or capacity
+local ts = tonumber(data[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+local retry = -1
+if n <= tokens then
+	tokens = tokens - n
+	allowed = 1
+elseif n <= capacity then
+	retry = math.ceil((n - tokens) / rate)
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+local reset = math.ceil((capacity - tokens) / rate)
+redis.call("PEXPIRE", KEYS[1], math.max(reset, 1))
+return {allowed, math.floor(tokens), retry, reset}
+`)
+
+// gcraScript KEYS[1]为理论到达时间(TAT)，ARGV: 每个请求的间隔(毫秒)、容量*间隔(毫秒)、本次请求数
+var gcraScript = redis.NewScript(luaNowMillis + `
+local interval = tonumber(ARGV[1])
+local capacityOffset = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local now = now_millis()
+local tat = tonumber(redis.call("GET", KEYS[1]) or now)
+tat = math.max(tat, now)
+local newTat = tat + interval * n
+local allowAt = newTat - capacityOffset
+if now < allowAt then
+	local retry = -1
+	if interval * n <= capacityOffset then
+		retry = math.ceil(allowAt - now)
+	end
+	return {0, math.floor((now - (tat - capacityOffset)) / interval), retry, math.ceil(tat - now)}
+end
+redis.call("SET", KEYS[1], tostring(newTat), "PX", math.max(math.ceil(newTat - now), 1))
+return {1, math.floor((now - allowAt) / interval), -1, math.ceil(newTat - now)}
+`)
+
+// fixedWindowScript KEYS[1]为窗口内的计数，ARGV: 窗口内允许的请求数、窗口长度(毫秒)、本次请求数
+var fixedWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local n = tonumber(ARGV[3])
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+if current + n > limit then
+	local ttl = redis.call("PTTL", KEYS[1])
+	local retry = -1
+	if n <= limit then
+		retry = math.max(ttl, 0)
+	end
+	return {0, limit - current, retry, math.max(ttl, 0)}
+end
+current = redis.call("INCRBY", KEYS[1], n)
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	ttl = tonumber(ARGV[2])
+end
+return {1, limit - current, -1, ttl}
+`)
+
+// slidingWindowLogScript KEYS[1]为窗口内请求时间的zset，
+// ARGV: 窗口内允许的请求数、窗口长度(毫秒)、本次请求数、本次请求的唯一id
+var slidingWindowLogScript = redis.NewScript(luaNowMillis + `
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local now = now_millis()
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+if count + n > limit then
+	local retry = -1
+	if n <= limit then
+		local idx = count + n - limit - 1
+		local entry = redis.call("ZRANGE", KEYS[1], idx, idx, "WITHSCORES")
+		retry = math.max(tonumber(entry[2]) + window - now, 0)
+	end
+	local reset = 0
+	local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+	if oldest[2] then
+		reset = math.max(tonumber(oldest[2]) + window - now, 0)
+	end
+	return {0, limit - count, retry, reset}
+end
+for i = 1, n do
+	redis.call("ZADD", KEYS[1], now, ARGV[4] .. ":" .. i)
+end
+redis.call("PEXPIRE", KEYS[1], window)
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+return {1, limit - count - n, -1, tonumber(oldest[2]) + window - now}
+`)